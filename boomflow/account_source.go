@@ -0,0 +1,301 @@
+package boomflow
+
+import (
+	"math/big"
+
+	sdk "github.com/Conflux-Chain/go-conflux-sdk"
+	"github.com/Conflux-Chain/go-conflux-sdk/types"
+	"github.com/Conflux-Chain/go-conflux-sdk/types/cfxaddress"
+	"github.com/open-dex/conflux-dex-audit/common"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Account sources configured in config.json.
+const (
+	AccountSourceMigration = "migration"
+	AccountSourceIndexer   = "indexer"
+)
+
+const (
+	accountIndexPrefix = "index/"
+
+	// number of epochs to persist the account index once during scanning
+	accountIndexSaveIntervalEpochs = 10000
+
+	// number of recent epochs to keep balance changes in account index, so as to rewind on
+	// pivot chain reorg, which should cover the epochs after the latest checkpoint
+	accountIndexRewindEpochs = 10000
+)
+
+// AccountSource provides all accounts in CRCL to audit balance.
+type AccountSource interface {
+	// ListAccounts lists all accounts in CRCL at the specified epoch.
+	ListAccounts(epoch *big.Int) ([]string, error)
+}
+
+// migrationAccountSource lists accounts by the data migration API in CRCL.
+type migrationAccountSource struct {
+	crcl   *common.Contract
+	logger logrus.FieldLogger
+}
+
+// ListAccounts implements the AccountSource interface.
+func (source *migrationAccountSource) ListAccounts(epochNum *big.Int) ([]string, error) {
+	epoch := types.NewEpochNumberBig(epochNum)
+
+	total, err := source.crcl.TotalAccount(epoch)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to get number of accounts in CRCL")
+	}
+
+	source.logger.WithField("total", total).Debug("succeed to query total accounts")
+
+	var result []string
+	for offset := big.NewInt(0); offset.Cmp(total) < 0; {
+		accounts, err := source.crcl.ListAccounts(offset, epoch)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "failed to list accounts since offset %v", offset)
+		}
+
+		result = append(result, accounts...)
+		offset = new(big.Int).Add(offset, big.NewInt(int64(len(accounts))))
+	}
+
+	return result, nil
+}
+
+// accountIndexChange is the balance changes of accounts in an indexed epoch.
+type accountIndexChange struct {
+	Epoch    *big.Int                `json:"epoch"`
+	Balances *common.AccountBalances `json:"balances"`
+}
+
+// accountIndex is the persisted state of accountIndexer.
+type accountIndex struct {
+	Epoch       *big.Int                `json:"epoch"`       // last indexed epoch
+	Balances    *common.AccountBalances `json:"balances"`    // balance snapshot of all accounts at epoch
+	Changes     []accountIndexChange    `json:"changes"`     // recent changes in ascending order of epoch
+	ChangesFrom *big.Int                `json:"changesFrom"` // first epoch covered by recent changes
+}
+
+// accountIndexer builds the CRCL account set by scanning Transfer logs since the deployment
+// epoch of CRCL, so as to not depend on the data migration API.
+type accountIndexer struct {
+	asset      string
+	crcl       *common.Contract
+	store      *common.Store
+	logFetcher *common.EpochLogFetcher
+	index      *accountIndex
+	logger     logrus.FieldLogger
+}
+
+func newAccountIndexer(cfx *sdk.Client, asset common.Asset, crcl *common.Contract, store *common.Store) *accountIndexer {
+	addresses := []types.Address{cfxaddress.MustNewFromHex(asset.ContractAddress, common.GetNetworkId())}
+	topics := [][]types.Hash{{common.EventHashTransfer}}
+
+	return &accountIndexer{
+		asset:      asset.Name,
+		crcl:       crcl,
+		store:      store,
+		logFetcher: common.NewEpochLogFetcher(cfx, addresses, topics),
+		logger:     logger.WithFields(logrus.Fields{"asset": asset.Name, "source": AccountSourceIndexer}),
+	}
+}
+
+// ListAccounts implements the AccountSource interface. Note, the accounts in the latest index are
+// returned for an epoch before the indexed epoch, which is a superset of accounts at the specified
+// epoch.
+func (indexer *accountIndexer) ListAccounts(epoch *big.Int) ([]string, error) {
+	if err := indexer.load(); err != nil {
+		return nil, err
+	}
+
+	if indexer.index.Epoch.Cmp(epoch) > 0 {
+		indexer.logger.WithFields(logrus.Fields{
+			"epoch":        epoch,
+			"indexedEpoch": indexer.index.Epoch,
+		}).Warn("list accounts of later indexed epoch")
+	} else if err := indexer.scan(epoch); err != nil {
+		return nil, errors.WithMessagef(err, "failed to index accounts until epoch %v", epoch)
+	}
+
+	var result []string
+	for account := range indexer.index.Balances.Map() {
+		result = append(result, account)
+	}
+
+	return result, nil
+}
+
+// load loads the persisted index, or starts from the deployment epoch of CRCL, which must be
+// configured in deploymentEpochs.
+func (indexer *accountIndexer) load() error {
+	if indexer.index != nil {
+		return nil
+	}
+
+	index := accountIndex{
+		Balances: common.NewAccountBalances(),
+	}
+
+	found, err := indexer.store.Get(accountIndexPrefix+indexer.asset, &index)
+	if err != nil {
+		return errors.WithMessage(err, "failed to load account index")
+	}
+
+	if !found {
+		if err = index.reset(indexer.asset); err != nil {
+			return err
+		}
+	}
+
+	indexer.index = &index
+
+	return nil
+}
+
+// reset resets the index to the state before the deployment epoch of CRCL.
+func (index *accountIndex) reset(asset string) error {
+	// indexing since genesis is too slow and hides misconfiguration
+	deploymentEpoch := config.DeploymentEpochs[asset]
+	if deploymentEpoch == 0 {
+		return common.PermanentErrorf("deployment epoch of asset %v not configured for indexer account source", asset)
+	}
+
+	index.Epoch = new(big.Int).Sub(new(big.Int).SetUint64(deploymentEpoch), common.Big1)
+	index.Balances = common.NewAccountBalances()
+	index.Changes = nil
+	index.ChangesFrom = new(big.Int).SetUint64(deploymentEpoch)
+
+	return nil
+}
+
+// prune removes the balance changes that are too old to rewind.
+func (index *accountIndex) prune() {
+	from := new(big.Int).SetUint64(accountIndexRewindEpochs - 1)
+	if from = from.Sub(index.Epoch, from); index.ChangesFrom != nil && from.Cmp(index.ChangesFrom) <= 0 {
+		return
+	}
+
+	i := 0
+	for i < len(index.Changes) && index.Changes[i].Epoch.Cmp(from) < 0 {
+		i++
+	}
+
+	index.Changes = index.Changes[i:]
+	index.ChangesFrom = from
+}
+
+// rewind drops the indexed balance changes since the specified epoch, e.g. pivot chain reorg,
+// so that the reorged epochs will be indexed again. If the changes to rewind are pruned, the
+// index is rebuilt from the deployment epoch of CRCL.
+func (indexer *accountIndexer) rewind(epoch *big.Int) error {
+	if indexer.index == nil || indexer.index.Epoch.Cmp(epoch) < 0 {
+		return nil
+	}
+
+	indexer.logFetcher.Reset()
+
+	index := indexer.index
+	if index.ChangesFrom == nil || index.ChangesFrom.Cmp(epoch) > 0 {
+		indexer.logger.WithFields(logrus.Fields{
+			"epoch":       epoch,
+			"changesFrom": index.ChangesFrom,
+		}).Warn("index changes pruned, rebuild account index from deployment epoch")
+
+		if err := index.reset(indexer.asset); err != nil {
+			return err
+		}
+
+		return indexer.save()
+	}
+
+	i := len(index.Changes)
+	for i > 0 && index.Changes[i-1].Epoch.Cmp(epoch) >= 0 {
+		i--
+		for account, amount := range index.Changes[i].Balances.Map() {
+			index.Balances.Add(account, new(big.Int).Neg(amount))
+		}
+	}
+
+	index.Changes = index.Changes[:i]
+	index.Epoch = new(big.Int).Sub(epoch, common.Big1)
+
+	indexer.logger.WithField("epoch", index.Epoch).Info("account index rewound")
+
+	return indexer.save()
+}
+
+// scan replays the Transfer logs after the indexed epoch until the specified epoch, and
+// checks the balance snapshot against the total supply in CRCL.
+func (indexer *accountIndexer) scan(epoch *big.Int) error {
+	indexer.logger.WithFields(logrus.Fields{
+		"from": indexer.index.Epoch,
+		"to":   epoch,
+	}).Debug("begin to index accounts")
+
+	for indexer.index.Epoch.Cmp(epoch) < 0 {
+		next := new(big.Int).Add(indexer.index.Epoch, common.Big1)
+
+		logs, err := indexer.logFetcher.GetLogs(next)
+		if err != nil {
+			return errors.WithMessagef(err, "failed to poll event logs for epoch %v", next)
+		}
+
+		changes := common.NewAccountBalances()
+		for i := range logs {
+			sender, recipient, amount := decodeCrclEvent(&logs[i])
+
+			if sender != common.ZeroAddress {
+				changes.Add(sender, new(big.Int).Neg(amount))
+			}
+
+			if recipient != common.ZeroAddress {
+				changes.Add(recipient, amount)
+			}
+		}
+
+		for account, amount := range changes.Map() {
+			indexer.index.Balances.Add(account, amount)
+		}
+
+		if len(changes.Map()) > 0 {
+			indexer.index.Changes = append(indexer.index.Changes, accountIndexChange{next, changes})
+		}
+
+		indexer.index.Epoch = next
+
+		if next.Uint64()%accountIndexSaveIntervalEpochs == 0 {
+			if err = indexer.save(); err != nil {
+				return err
+			}
+		}
+	}
+
+	total, err := indexer.crcl.TotalSupply(types.NewEpochNumberBig(epoch))
+	if err != nil {
+		return errors.WithMessage(err, "failed to get total supply in CRCL")
+	}
+
+	if sum := indexer.index.Balances.Sum(); sum.Cmp(total) != 0 {
+		return common.InconsistencyErrorf("inconsistent balance snapshot, CRCL.totalSupply = %v, Sum(indexed balances) = %v, epoch = %v", total, sum, epoch)
+	}
+
+	indexer.logger.WithFields(logrus.Fields{
+		"epoch":    epoch,
+		"accounts": len(indexer.index.Balances.Map()),
+	}).Debug("succeed to index accounts")
+
+	return indexer.save()
+}
+
+func (indexer *accountIndexer) save() error {
+	indexer.index.prune()
+
+	if err := indexer.store.Put(accountIndexPrefix+indexer.asset, indexer.index); err != nil {
+		return errors.WithMessage(err, "failed to save account index")
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package boomflow
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/Conflux-Chain/go-conflux-sdk/types"
+	ethCommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/open-dex/conflux-dex-audit/common"
+)
+
+// fakeTransferChain serves CRCL Transfer logs and total supply of a pivot chain in test.
+type fakeTransferChain struct {
+	logs map[uint64][]types.Log // key is epoch number
+}
+
+func (c *fakeTransferChain) transfer(epoch uint64, from, to string, amount int64) {
+	topic := func(account string) types.Hash {
+		return types.Hash(ethCommon.BytesToHash(ethCommon.HexToAddress(account).Bytes()).Hex())
+	}
+
+	c.logs[epoch] = append(c.logs[epoch], types.Log{
+		Topics:      []types.Hash{common.EventHashTransfer, topic(from), topic(to)},
+		Data:        math.U256Bytes(big.NewInt(amount)),
+		EpochNumber: (*hexutil.Big)(new(big.Int).SetUint64(epoch)),
+	})
+}
+
+func (c *fakeTransferChain) call(resultPtr interface{}, method string, args ...interface{}) error {
+	switch method {
+	case "cfx_epochNumber":
+		*resultPtr.(**hexutil.Big) = (*hexutil.Big)(big.NewInt(1000))
+	case "cfx_getLogs":
+		filter := args[0].(types.LogFilter)
+		from, _ := filter.FromEpoch.ToInt()
+		to, _ := filter.ToEpoch.ToInt()
+
+		var logs []types.Log
+		for epoch := from.Uint64(); epoch <= to.Uint64(); epoch++ {
+			logs = append(logs, c.logs[epoch]...)
+		}
+
+		*resultPtr.(*[]types.Log) = logs
+	case "cfx_call":
+		epoch, _ := args[1].(*types.Epoch).ToInt()
+
+		// total supply is the sum of minted amounts
+		supply := big.NewInt(0)
+		for e, logs := range c.logs {
+			if e > epoch.Uint64() {
+				continue
+			}
+
+			for i := range logs {
+				if sender, _, amount := decodeCrclEvent(&logs[i]); sender == common.ZeroAddress {
+					supply.Add(supply, amount)
+				}
+			}
+		}
+
+		*resultPtr.(*hexutil.Bytes) = math.U256Bytes(supply)
+	}
+
+	return nil
+}
+
+func TestAccountIndexerRewind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "indexer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := common.OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	defer func(epochs map[string]uint64) { config.DeploymentEpochs = epochs }(config.DeploymentEpochs)
+	config.DeploymentEpochs = map[string]uint64{"CFX": 10}
+
+	defer chdirRoot(t)()
+
+	const (
+		alice = "0x1000000000000000000000000000000000000001"
+		bob   = "0x1000000000000000000000000000000000000002"
+	)
+
+	chain := &fakeTransferChain{make(map[uint64][]types.Log)}
+	chain.transfer(10, common.ZeroAddress, alice, 100)
+	chain.transfer(12, alice, bob, 30)
+
+	asset := common.Asset{Name: "CFX", ContractAddress: "0x8000000000000000000000000000000000000001"}
+	cfx := newFakeClient(chain.call)
+	indexer := newAccountIndexer(cfx, asset, common.GetContract(cfx, common.CrclABI, asset.ContractAddress), store)
+
+	if _, err = indexer.ListAccounts(big.NewInt(15)); err != nil {
+		t.Fatal(err)
+	}
+
+	// pivot chain switched since epoch 12, where bob is minted instead
+	delete(chain.logs, 12)
+	chain.transfer(13, common.ZeroAddress, bob, 50)
+
+	if err = indexer.rewind(big.NewInt(12)); err != nil {
+		t.Fatal(err)
+	}
+
+	if indexer.index.Epoch.Int64() != 11 || indexer.index.Balances.Get(alice).Int64() != 100 {
+		t.Fatalf("index not rewound, epoch = %v, balances = %v", indexer.index.Epoch, indexer.index.Balances)
+	}
+
+	// index is consistent with total supply of new pivot chain
+	if _, err = indexer.ListAccounts(big.NewInt(15)); err != nil {
+		t.Fatal(err)
+	}
+
+	if indexer.index.Balances.Get(alice).Int64() != 100 || indexer.index.Balances.Get(bob).Int64() != 50 {
+		t.Errorf("balances = %v", indexer.index.Balances)
+	}
+
+	// rebuild from deployment epoch if changes to rewind are pruned
+	indexer.index.ChangesFrom = big.NewInt(14)
+	if err = indexer.rewind(big.NewInt(13)); err != nil {
+		t.Fatal(err)
+	}
+
+	if indexer.index.Epoch.Int64() != 9 || len(indexer.index.Balances.Map()) != 0 {
+		t.Fatalf("index not reset, epoch = %v, balances = %v", indexer.index.Epoch, indexer.index.Balances)
+	}
+
+	if _, err = indexer.ListAccounts(big.NewInt(15)); err != nil {
+		t.Fatal(err)
+	}
+
+	if indexer.index.Balances.Get(alice).Int64() != 100 || indexer.index.Balances.Get(bob).Int64() != 50 {
+		t.Errorf("balances = %v", indexer.index.Balances)
+	}
+}
@@ -0,0 +1,347 @@
+package boomflow
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/Conflux-Chain/go-conflux-sdk/types"
+	"github.com/open-dex/conflux-dex-audit/common"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	anomalyStateKey       = "anomaly"
+	anomalyBaselinePrefix = "anomaly/" // baselines are persisted per key
+
+	// interval to evict inactive account baselines
+	anomalyEvictIntervalEpochs = 10000
+)
+
+// Flow metrics of asset and account in an epoch.
+const (
+	metricVolume      = "volume"      // sum of debited amounts in CRCL
+	metricTransfers   = "transfers"   // number of Transfer events in CRCL
+	metricWithdrawals = "withdrawals" // sum of withdrawn amounts from CRCL
+	metricNetOutflow  = "netOutflow"  // withdrawn amounts minus deposited amounts
+	metricOutflow     = "outflow"     // net debited amount of an account
+)
+
+// AnomalyConfig configures the anomaly detection on CRCL flows, which alerts on outliers
+// even if balances are consistent, e.g. user funds drained by a stolen key.
+type AnomalyConfig struct {
+	Alpha      float64 `json:"alpha"`      // smoothing factor of EWMA baselines, in (0, 1]
+	Threshold  float64 `json:"threshold"`  // number of standard deviations above mean treated as outlier
+	MinSamples int64   `json:"minSamples"` // number of samples required before a baseline takes effect
+
+	// max share of CRCL total supply that an account could drain in an epoch, zero means disabled
+	ShareOfSupply float64 `json:"shareOfSupply"`
+
+	// number of epochs that an account baseline is evicted if not updated, zero means never
+	InactiveEpochs uint64 `json:"inactiveEpochs"`
+}
+
+func (c *AnomalyConfig) validate() error {
+	if c.Alpha <= 0 || c.Alpha > 1 {
+		return fmt.Errorf("invalid alpha %v", c.Alpha)
+	}
+
+	if c.Threshold <= 0 {
+		return fmt.Errorf("invalid threshold %v", c.Threshold)
+	}
+
+	if c.ShareOfSupply < 0 || c.ShareOfSupply > 1 {
+		return fmt.Errorf("invalid share of supply %v", c.ShareOfSupply)
+	}
+
+	return nil
+}
+
+// flowBaseline is the exponentially weighted moving average and variance of a flow metric.
+// Note, it is updated only for epochs in which the asset or account is active, so that the
+// idle epochs do not shrink the baseline to zero.
+type flowBaseline struct {
+	Count     int64   `json:"count"`
+	Mean      float64 `json:"mean"`
+	Variance  float64 `json:"variance"`
+	LastEpoch uint64  `json:"lastEpoch"` // last updated epoch
+}
+
+// isOutlier returns true if the value is far above the baseline with enough samples.
+func (b *flowBaseline) isOutlier(value float64) bool {
+	if b.Count < config.Anomaly.MinSamples || value <= b.Mean {
+		return false
+	}
+
+	return value > b.Mean+config.Anomaly.Threshold*math.Sqrt(b.Variance)
+}
+
+func (b *flowBaseline) update(epoch *big.Int, value float64) {
+	if b.Count == 0 {
+		b.Mean = value
+	} else {
+		diff := value - b.Mean
+		incr := config.Anomaly.Alpha * diff
+		b.Mean += incr
+		b.Variance = (1 - config.Anomaly.Alpha) * (b.Variance + diff*incr)
+	}
+
+	b.Count++
+	b.LastEpoch = epoch.Uint64()
+}
+
+// anomalyDetector keeps the rolling baselines of flow metrics per asset and per account,
+// which are fed by the event audit details of each epoch. Account baselines not updated
+// for InactiveEpochs are evicted, so that the baselines will not grow unbounded.
+type anomalyDetector struct {
+	Epoch     *big.Int                 `json:"epoch"` // last detected epoch
+	Baselines map[string]*flowBaseline `json:"-"`     // key is asset/metric or asset/account/metric
+
+	lastEvictEpoch uint64
+	changed        map[string]bool // keys of baselines changed since last checkpoint
+	evicted        map[string]bool // keys of baselines evicted since last checkpoint
+}
+
+func newAnomalyDetector() *anomalyDetector {
+	return &anomalyDetector{
+		Baselines: make(map[string]*flowBaseline),
+		changed:   make(map[string]bool),
+		evicted:   make(map[string]bool),
+	}
+}
+
+// loadAnomalyDetector loads the state of anomaly detector from checkpoint store.
+func loadAnomalyDetector(store *common.Store) (*anomalyDetector, error) {
+	detector := newAnomalyDetector()
+
+	if _, err := store.Get(anomalyStateKey, detector); err != nil {
+		return nil, errors.WithMessage(err, "failed to load anomaly detector")
+	}
+
+	keys, err := store.Keys(anomalyBaselinePrefix)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to list anomaly baselines")
+	}
+
+	for _, key := range keys {
+		var baseline flowBaseline
+		if _, err = store.Get(key, &baseline); err != nil {
+			return nil, errors.WithMessagef(err, "failed to load anomaly baseline %v", key)
+		}
+
+		detector.Baselines[strings.TrimPrefix(key, anomalyBaselinePrefix)] = &baseline
+	}
+
+	return detector, nil
+}
+
+// put adds the changed baselines and the detected epoch into batch, and deletes the evicted
+// baselines.
+func (detector *anomalyDetector) put(batch *common.StoreBatch) error {
+	for key := range detector.changed {
+		if err := batch.Put(anomalyBaselinePrefix+key, detector.Baselines[key]); err != nil {
+			return errors.WithMessagef(err, "failed to save anomaly baseline %v", key)
+		}
+	}
+
+	for key := range detector.evicted {
+		batch.Delete(anomalyBaselinePrefix + key)
+	}
+
+	if err := batch.Put(anomalyStateKey, detector); err != nil {
+		return errors.WithMessage(err, "failed to save anomaly detector")
+	}
+
+	detector.changed = make(map[string]bool)
+	detector.evicted = make(map[string]bool)
+
+	return nil
+}
+
+// rewind makes the epochs since the specified epoch to be detected again, e.g. pivot chain
+// reorg. Note, the EWMA baselines could not be reverted, so the observations of reorged epochs
+// on the abandoned fork are kept, whose impact decays by alpha.
+func (detector *anomalyDetector) rewind(epoch *big.Int) {
+	if detector.Epoch != nil && detector.Epoch.Cmp(epoch) >= 0 {
+		detector.Epoch = new(big.Int).Sub(epoch, common.Big1)
+	}
+}
+
+// evict removes the account baselines not updated for InactiveEpochs before the specified
+// epoch. Asset baselines are always kept.
+func (detector *anomalyDetector) evict(epoch uint64) {
+	inactive := config.Anomaly.InactiveEpochs
+	if inactive == 0 || epoch < inactive {
+		return
+	}
+
+	var evicted int
+	for key, baseline := range detector.Baselines {
+		if !strings.HasSuffix(key, "/"+metricOutflow) || baseline.LastEpoch+inactive > epoch {
+			continue
+		}
+
+		delete(detector.Baselines, key)
+		delete(detector.changed, key)
+		detector.evicted[key] = true
+		evicted++
+	}
+
+	if evicted > 0 {
+		logger.WithFields(logrus.Fields{
+			"epoch":     epoch,
+			"evicted":   evicted,
+			"baselines": len(detector.Baselines),
+		}).Debug("inactive account baselines evicted")
+	}
+}
+
+// loadAnomalyDetector loads the state of anomaly detector from checkpoint store.
+func (am *AuditManager) loadAnomalyDetector() error {
+	detector, err := loadAnomalyDetector(am.store)
+	if err != nil {
+		return err
+	}
+
+	am.anomalies = detector
+
+	return nil
+}
+
+// putAnomalyDetector adds the state of anomaly detector into batch if changed.
+func (am *AuditManager) putAnomalyDetector(batch *common.StoreBatch) error {
+	return am.anomalies.put(batch)
+}
+
+// detectAnomalies checks the flows of each asset and account in the specified epoch against
+// baselines, and then updates baselines. Epochs already detected are skipped, so that an epoch
+// could be audited again, unless rewound on pivot chain reorg.
+func (am *AuditManager) detectAnomalies(epoch *big.Int, allDetails map[string]EventAuditDetails, logs []types.Log) {
+	detector := am.anomalies
+	if detector.Epoch != nil && detector.Epoch.Cmp(epoch) >= 0 {
+		return
+	}
+
+	// number of transfers and withdrawn amounts, key is CRCL address
+	transfers := make(map[string]int64)
+	withdrawals := make(map[string]*big.Int)
+	for i := range logs {
+		log := &logs[i]
+		if len(log.Topics) == 0 || log.Topics[0] != common.EventHashTransfer {
+			continue
+		}
+
+		crcl := log.Address.GetHexAddress()
+		transfers[crcl]++
+
+		if _, recipient, amount := decodeCrclEvent(log); recipient == common.ZeroAddress {
+			if withdrawals[crcl] == nil {
+				withdrawals[crcl] = big.NewInt(0)
+			}
+
+			withdrawals[crcl].Add(withdrawals[crcl], amount)
+		}
+	}
+
+	var crcls []string
+	for crcl := range allDetails {
+		crcls = append(crcls, crcl)
+	}
+	sort.Strings(crcls)
+
+	for _, crcl := range crcls {
+		asset, ok := am.crcl2AssetMap[crcl]
+		if !ok {
+			continue
+		}
+
+		details := allDetails[crcl]
+		reduced := details.BalanceReduced.Sum()
+		netOutflow := new(big.Int).Sub(reduced, details.BalanceIncreased.Sum())
+
+		withdrawn := withdrawals[crcl]
+		if withdrawn == nil {
+			withdrawn = common.Big0
+		}
+
+		detector.observe(epoch, asset, metricVolume, reduced)
+		detector.observe(epoch, asset, metricTransfers, big.NewInt(transfers[crcl]))
+		detector.observe(epoch, asset, metricWithdrawals, withdrawn)
+		detector.observe(epoch, asset, metricNetOutflow, netOutflow)
+
+		// total supply before epoch, which is updated for the epoch in advance
+		supply := new(big.Int).Add(am.lastAuditDetails[asset].TotalSupply, netOutflow)
+
+		for account, amount := range details.BalanceReduced.Map() {
+			outflow := new(big.Int).Sub(amount, details.BalanceIncreased.Get(account))
+			if outflow.Sign() <= 0 {
+				continue
+			}
+
+			detector.checkShareOfSupply(epoch, asset, account, outflow, supply)
+			detector.observe(epoch, asset+"/"+account, metricOutflow, outflow)
+		}
+	}
+
+	detector.Epoch = epoch
+
+	if epochNum := epoch.Uint64(); epochNum >= detector.lastEvictEpoch+anomalyEvictIntervalEpochs {
+		detector.evict(epochNum)
+		detector.lastEvictEpoch = epochNum
+	}
+}
+
+// observe alerts if the value of metric is an outlier, and then updates the baseline.
+func (detector *anomalyDetector) observe(epoch *big.Int, subject, metric string, value *big.Int) {
+	key := subject + "/" + metric
+	baseline, ok := detector.Baselines[key]
+	if !ok {
+		baseline = &flowBaseline{}
+		detector.Baselines[key] = baseline
+	}
+
+	fValue, _ := new(big.Float).SetInt(value).Float64()
+
+	if baseline.isOutlier(fValue) {
+		logger.WithFields(logrus.Fields{
+			"epoch":  epoch,
+			"metric": key,
+			"value":  value,
+			"mean":   baseline.Mean,
+			"stddev": math.Sqrt(baseline.Variance),
+		}).Warn("abnormal flow detected")
+
+		common.Alertf(module, "Abnormal flow detected, severity = %v, metric = %v, epoch = %v, value = %v, mean = %.0f, stddev = %.0f",
+			SeverityMedium, key, epoch, value, baseline.Mean, math.Sqrt(baseline.Variance))
+	}
+
+	baseline.update(epoch, fValue)
+	detector.changed[key] = true
+	delete(detector.evicted, key)
+}
+
+// checkShareOfSupply alerts if an account drains too much share of total supply in an epoch.
+func (detector *anomalyDetector) checkShareOfSupply(epoch *big.Int, asset, account string, outflow, supply *big.Int) {
+	if config.Anomaly.ShareOfSupply <= 0 || supply.Sign() <= 0 {
+		return
+	}
+
+	share, _ := new(big.Rat).SetFrac(outflow, supply).Float64()
+	if share <= config.Anomaly.ShareOfSupply {
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"epoch":   epoch,
+		"asset":   asset,
+		"account": account,
+		"outflow": outflow,
+		"supply":  supply,
+	}).Warn("account drains unusual share of total supply")
+
+	common.Criticalf(module, "Account drains unusual share of total supply, severity = %v, asset = %v, account = %v, epoch = %v, outflow = %v, totalSupply = %v, share = %.2f%%",
+		SeverityHigh, asset, account, epoch, outflow, supply, share*100)
+}
@@ -0,0 +1,95 @@
+package boomflow
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/open-dex/conflux-dex-audit/common"
+)
+
+func TestAnomalyDetectorEvictAndPersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anomaly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := common.OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	defer func(inactive uint64) { config.Anomaly.InactiveEpochs = inactive }(config.Anomaly.InactiveEpochs)
+	config.Anomaly.InactiveEpochs = 100
+
+	detector := newAnomalyDetector()
+	detector.observe(big.NewInt(10), "CFX", metricVolume, big.NewInt(1))
+	detector.observe(big.NewInt(10), "CFX/0xa", metricOutflow, big.NewInt(1))
+	detector.observe(big.NewInt(50), "CFX/0xb", metricOutflow, big.NewInt(1))
+	detector.Epoch = big.NewInt(50)
+
+	save := func() {
+		batch := common.NewStoreBatch()
+		if err := detector.put(batch); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Write(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	save()
+
+	// 0xa inactive for 100 epochs, while asset baseline is kept
+	detector.evict(110)
+	if _, ok := detector.Baselines["CFX/0xa/"+metricOutflow]; ok {
+		t.Error("inactive account baseline not evicted")
+	}
+	if len(detector.Baselines) != 2 {
+		t.Errorf("baselines = %v", detector.Baselines)
+	}
+
+	detector.Epoch = big.NewInt(110)
+	save()
+
+	loaded, err := loadAnomalyDetector(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.Epoch.Cmp(detector.Epoch) != 0 {
+		t.Errorf("epoch = %v, want %v", loaded.Epoch, detector.Epoch)
+	}
+
+	if len(loaded.Baselines) != 2 {
+		t.Fatalf("loaded baselines = %v", loaded.Baselines)
+	}
+
+	for key, baseline := range detector.Baselines {
+		if got := loaded.Baselines[key]; got == nil || *got != *baseline {
+			t.Errorf("baseline %v = %v, want %v", key, got, baseline)
+		}
+	}
+}
+
+func TestAnomalyDetectorRewind(t *testing.T) {
+	detector := newAnomalyDetector()
+	detector.rewind(big.NewInt(10))
+	if detector.Epoch != nil {
+		t.Fatalf("epoch = %v", detector.Epoch)
+	}
+
+	detector.Epoch = big.NewInt(50)
+	detector.rewind(big.NewInt(60))
+	if detector.Epoch.Int64() != 50 {
+		t.Fatalf("epoch = %v", detector.Epoch)
+	}
+
+	detector.rewind(big.NewInt(40))
+	if detector.Epoch.Int64() != 39 {
+		t.Fatalf("epoch = %v", detector.Epoch)
+	}
+}
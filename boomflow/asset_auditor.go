@@ -1,11 +1,11 @@
 package boomflow
 
 import (
-	"fmt"
 	"math/big"
 
 	sdk "github.com/Conflux-Chain/go-conflux-sdk"
 	"github.com/Conflux-Chain/go-conflux-sdk/types"
+	ethCommon "github.com/ethereum/go-ethereum/common"
 	"github.com/open-dex/conflux-dex-audit/common"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -13,8 +13,9 @@ import (
 
 // BalanceAuditDetails represents the details of audit against balance.
 type BalanceAuditDetails struct {
-	TotalSupply     *big.Int                // total supply in CRCL
-	AccountBalances *common.AccountBalances // account balances in CRCL
+	TotalSupply     *big.Int                `json:"totalSupply"`     // total supply in CRCL
+	AccountBalances *common.AccountBalances `json:"accountBalances"` // account balances in CRCL
+	MerkleRoot      ethCommon.Hash          `json:"merkleRoot"`      // merkle root of account balances when last recorded
 }
 
 // AssetAuditor audits contract data for a single asset.
@@ -22,15 +23,16 @@ type AssetAuditor struct {
 	asset             common.Asset
 	erc777            *common.Contract
 	crcl              *common.Contract
+	accounts          AccountSource
 	logger            logrus.FieldLogger
-	balanceByTransfer *big.Int // balance that transfered to ERC777, not by send method
+	balanceByTransfer *big.Int         // balance that transfered to ERC777, not by send method, nil to track
+	stranded          *strandedTracker // tracks balance that transfered to ERC777, used if deployment epoch configured
 }
 
 // NewAssetAuditor creates an instance of NewAssetAuditor.
 func NewAssetAuditor(asset common.Asset, cfx *sdk.Client) *AssetAuditor {
 	transfer := config.getBalanceByTransfer(asset.Name)
-	logger.Debug("diff for", asset.Name, "is ", transfer)
-	return &AssetAuditor{
+	auditor := AssetAuditor{
 		asset:  asset,
 		erc777: common.GetContract(cfx, common.Erc777ABI, asset.TokenAddress),
 		crcl:   common.GetContract(cfx, common.CrclABI, asset.ContractAddress),
@@ -38,7 +40,16 @@ func NewAssetAuditor(asset common.Asset, cfx *sdk.Client) *AssetAuditor {
 			"asset": asset.Name,
 		}),
 		balanceByTransfer: transfer,
+		stranded:          newStrandedTracker(cfx, asset),
 	}
+
+	auditor.accounts = &migrationAccountSource{auditor.crcl, auditor.logger}
+
+	if transfer != nil {
+		auditor.logger.WithField("balanceByTransfer", transfer).Debug("use configured balance by transfer")
+	}
+
+	return &auditor
 }
 
 // AuditTotalSupply audits the total supply in CRCL against the balance of CRCL account in ERC777.
@@ -55,58 +66,60 @@ func (auditor *AssetAuditor) AuditTotalSupply(epochNum *big.Int) (*big.Int, erro
 		return nil, errors.WithMessage(err, "failed to get balance of CRCL account in ERC777")
 	}
 
-	balanceBySend := new(big.Int).Sub(balance, auditor.balanceByTransfer)
+	balanceByTransfer, err := auditor.getBalanceByTransfer(epochNum)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to get balance of CRCL account by transfer in ERC777")
+	}
+
+	balanceBySend := new(big.Int).Sub(balance, balanceByTransfer)
 
 	if total.Cmp(balanceBySend) != 0 {
-		return nil, fmt.Errorf("inconsistent balance, CRCL.totalSupply = %v, "+
-			"ERC777.balanceBySend = %v, Diff = %v, config diff = %v",
-			total, balanceBySend, total.Sub(total, balanceBySend), auditor.balanceByTransfer)
+		return nil, common.InconsistencyErrorf("inconsistent balance, CRCL.totalSupply = %v, "+
+			"ERC777.balanceBySend = %v, Diff = %v, balance by transfer = %v",
+			total, balanceBySend, total.Sub(total, balanceBySend), balanceByTransfer)
 	}
 
 	return total, nil
 }
 
+// getBalanceByTransfer returns the configured (or default 0) balance by transfer if any,
+// otherwise the tracked balance stranded in CRCL by transfer until the specified epoch.
+func (auditor *AssetAuditor) getBalanceByTransfer(epoch *big.Int) (*big.Int, error) {
+	if auditor.balanceByTransfer != nil {
+		return auditor.balanceByTransfer, nil
+	}
+
+	return auditor.stranded.StrandedAt(epoch)
+}
+
 // AuditAccountBalance queries account list and calculates the sum of their balances in CRCL.
-// Note, this kind of audit depends on the data migration API in CRCL, which may be removed in future.
+// Note, accounts are listed by the data migration API in CRCL by default, which may be removed
+// in future, or by the account indexer if configured.
 func (auditor *AssetAuditor) AuditAccountBalance(epochNum *big.Int) (*BalanceAuditDetails, error) {
 	logger := auditor.logger.WithField("epoch", epochNum)
 
 	epoch := types.NewEpochNumberBig(epochNum)
 
-	total, err := auditor.crcl.TotalAccount(epoch)
+	accounts, err := auditor.accounts.ListAccounts(epochNum)
 	if err != nil {
-		return nil, errors.WithMessage(err, "failed to get number of accounts in CRCL")
+		return nil, errors.WithMessage(err, "failed to list accounts in CRCL")
 	}
 
-	logger.WithField("total", total).Debug("succeed to query total accounts")
+	logger.WithField("accounts", len(accounts)).Trace("begin to query balance of accounts")
 
 	result := BalanceAuditDetails{
 		TotalSupply:     common.Big0,
 		AccountBalances: common.NewAccountBalances(),
 	}
 
-	for offset := big.NewInt(0); offset.Cmp(total) < 0; {
-		accounts, err := auditor.crcl.ListAccounts(offset, epoch)
+	for _, account := range accounts {
+		balance, err := auditor.crcl.BalanceOf(account, epoch)
 		if err != nil {
-			return nil, errors.WithMessagef(err, "failed to list accounts since offset %v", offset)
-		}
-
-		logger.WithFields(logrus.Fields{
-			"offset": offset,
-			"limit":  len(accounts),
-		}).Trace("begin to query balance of accounts")
-
-		for _, account := range accounts {
-			balance, err := auditor.crcl.BalanceOf(account, epoch)
-			if err != nil {
-				return nil, errors.WithMessagef(err, "failed to get balance of account %v", account)
-			}
-
-			result.TotalSupply = new(big.Int).Add(result.TotalSupply, balance)
-			result.AccountBalances.Add(account, balance)
+			return nil, errors.WithMessagef(err, "failed to get balance of account %v", account)
 		}
 
-		offset = new(big.Int).Add(offset, big.NewInt(int64(len(accounts))))
+		result.TotalSupply = new(big.Int).Add(result.TotalSupply, balance)
+		result.AccountBalances.Add(account, balance)
 	}
 
 	return &result, nil
@@ -133,7 +146,7 @@ func (auditor *AssetAuditor) AuditBalance(epoch *big.Int) (*BalanceAuditDetails,
 	logger.WithField("sum", details.TotalSupply).Debug("succeed to audit account balances in CRCL")
 
 	if details.TotalSupply.Cmp(total) != 0 {
-		return nil, fmt.Errorf("inconsistent balance, CRCL.totalSupply = %v, Sum(CRCL.balanceOf(account)) = %v, Diff = %v", total, details.TotalSupply, total.Sub(total, details.TotalSupply))
+		return nil, common.InconsistencyErrorf("inconsistent balance, CRCL.totalSupply = %v, Sum(CRCL.balanceOf(account)) = %v, Diff = %v", total, details.TotalSupply, total.Sub(total, details.TotalSupply))
 	}
 
 	return details, nil
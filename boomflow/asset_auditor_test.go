@@ -0,0 +1,81 @@
+package boomflow
+
+import (
+	"context"
+	"math/big"
+	"os"
+	"testing"
+
+	sdk "github.com/Conflux-Chain/go-conflux-sdk"
+	"github.com/Conflux-Chain/go-conflux-sdk/rpc"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/open-dex/conflux-dex-audit/common"
+)
+
+// fakeRequester serves RPC requests of full node in test.
+type fakeRequester struct {
+	call func(resultPtr interface{}, method string, args ...interface{}) error
+}
+
+func (r *fakeRequester) Call(resultPtr interface{}, method string, args ...interface{}) error {
+	return r.call(resultPtr, method, args...)
+}
+
+func (r *fakeRequester) BatchCall(b []rpc.BatchElem) error { return nil }
+
+func (r *fakeRequester) Subscribe(ctx context.Context, namespace string, channel interface{}, args ...interface{}) (*rpc.ClientSubscription, error) {
+	return nil, nil
+}
+
+func (r *fakeRequester) Close() {}
+
+func newFakeClient(call func(resultPtr interface{}, method string, args ...interface{}) error) *sdk.Client {
+	client, _ := sdk.NewClientWithRPCRequester(&fakeRequester{call})
+	return client
+}
+
+// chdirRoot changes to the repository root, where ABI files are located relatively.
+func chdirRoot(t *testing.T) func() {
+	if err := os.Chdir(".."); err != nil {
+		t.Fatal(err)
+	}
+
+	return func() { os.Chdir("boomflow") }
+}
+
+func TestAuditTotalSupplyWithDefaultConfig(t *testing.T) {
+	loaded, err := loadConfig("config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defaultConfig := config
+	config = *loaded
+	defer func() { config = defaultConfig }()
+
+	defer chdirRoot(t)()
+
+	// same value for both CRCL.totalSupply and ERC777.balanceOf(CRCL)
+	cfx := newFakeClient(func(resultPtr interface{}, method string, args ...interface{}) error {
+		*resultPtr.(*hexutil.Bytes) = math.U256Bytes(big.NewInt(100))
+		return nil
+	})
+
+	for _, name := range []string{"CFX", "ETH"} {
+		auditor := NewAssetAuditor(common.Asset{
+			Name:            name,
+			ContractAddress: "0x8000000000000000000000000000000000000001",
+			TokenAddress:    "0x8000000000000000000000000000000000000002",
+		}, cfx)
+
+		total, err := auditor.AuditTotalSupply(big.NewInt(10))
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+
+		if total.Cmp(big.NewInt(100)) != 0 {
+			t.Errorf("%v: total supply = %v", name, total)
+		}
+	}
+}
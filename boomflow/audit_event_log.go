@@ -3,6 +3,7 @@ package boomflow
 import (
 	"fmt"
 	"math/big"
+	"sync"
 
 	sdk "github.com/Conflux-Chain/go-conflux-sdk"
 	"github.com/Conflux-Chain/go-conflux-sdk/types"
@@ -14,6 +15,33 @@ import (
 type EventAuditDetails struct {
 	BalanceIncreased *common.AccountBalances
 	BalanceReduced   *common.AccountBalances
+	Annotations      []Annotation // balance-neutral events
+}
+
+// Annotation represents a balance-neutral event in CRCL, e.g. admin action.
+type Annotation struct {
+	Event  string                 `json:"event"`
+	TxHash string                 `json:"txHash"`
+	Args   map[string]interface{} `json:"args"`
+}
+
+// String implements the fmt.Stringer interface.
+func (a Annotation) String() string {
+	return fmt.Sprintf("%v%v (tx = %v)", a.Event, a.Args, a.TxHash)
+}
+
+var (
+	crclDecoder     *common.EventDecoder
+	crclDecoderOnce sync.Once
+)
+
+// crclEventDecoder returns the event decoder of CRCL contract.
+func crclEventDecoder() *common.EventDecoder {
+	crclDecoderOnce.Do(func() {
+		crclDecoder = common.MustNewEventDecoder(common.CrclABI)
+	})
+
+	return crclDecoder
 }
 
 // merge merges the changed balances from event logs into specified account balances
@@ -34,6 +62,17 @@ func (details *EventAuditDetails) merge(accountBalances *common.AccountBalances)
 	return changed
 }
 
+// revert reverts the changed balances from event logs that merged into specified account balances.
+func (details *EventAuditDetails) revert(accountBalances *common.AccountBalances) {
+	for account, amount := range details.BalanceIncreased.Map() {
+		accountBalances.Add(account, new(big.Int).Neg(amount))
+	}
+
+	for account, amount := range details.BalanceReduced.Map() {
+		accountBalances.Add(account, amount)
+	}
+}
+
 // AuditEventLogs audits event logs of CRCL for the specified epoch.
 func AuditEventLogs(cfx *sdk.Client, epoch *big.Int, crcls []types.Address) (map[string]EventAuditDetails, error) {
 	filter := types.LogFilter{
@@ -47,10 +86,15 @@ func AuditEventLogs(cfx *sdk.Client, epoch *big.Int, crcls []types.Address) (map
 		return nil, errors.WithMessagef(err, "failed to poll event logs from full node for epoch %v", epoch)
 	}
 
+	return auditLogs(logs)
+}
+
+// auditLogs audits the CRCL event logs of a single epoch.
+func auditLogs(logs []types.Log) (map[string]EventAuditDetails, error) {
 	result := make(map[string]EventAuditDetails)
 
 	for i := range logs {
-		if err = updateEventAuditDetails(&logs[i], result); err != nil {
+		if err := updateEventAuditDetails(&logs[i], result); err != nil {
 			return nil, errors.WithMessagef(err, "failed to update event audit details, log index = %v", i)
 		}
 	}
@@ -59,6 +103,19 @@ func AuditEventLogs(cfx *sdk.Client, epoch *big.Int, crcls []types.Address) (map
 }
 
 func updateEventAuditDetails(log *types.Log, result map[string]EventAuditDetails) error {
+	if len(log.Topics) == 0 {
+		return common.InconsistencyErrorf("anonymous event found")
+	}
+
+	if log.Topics[0] == common.EventHashWrite {
+		return common.InconsistencyErrorf("Write event found")
+	}
+
+	event, err := crclEventDecoder().Decode(log)
+	if err != nil {
+		return errors.WithMessage(err, "failed to decode CRCL event")
+	}
+
 	crcl := log.Address.GetHexAddress()
 	details, ok := result[crcl]
 	if !ok {
@@ -66,22 +123,29 @@ func updateEventAuditDetails(log *types.Log, result map[string]EventAuditDetails
 			BalanceIncreased: common.NewAccountBalances(),
 			BalanceReduced:   common.NewAccountBalances(),
 		}
-		result[crcl] = details
 	}
 
-	switch log.Topics[0] {
-	case common.EventHashTransfer:
+	switch event.Name {
+	case "Transfer":
 		updateOnTransfer(details, log)
-	case common.EventHashDeposit:
+	case "Deposit":
 		// do nothing due to Transfer(0, recipient, amount)
-	case common.EventHashWithdraw:
+	case "Withdraw":
 		// do nothing due to Transfer(sender, 0, amount)
-	case common.EventHashWrite:
-		return fmt.Errorf("Write event found")
+	case "ScheduleWithdraw", "Paused", "Unpaused",
+		"WhitelistedAdded", "WhitelistedRemoved", "WhitelistAdminAdded", "WhitelistAdminRemoved":
+		// balance-neutral events
+		details.Annotations = append(details.Annotations, Annotation{
+			Event:  event.Name,
+			TxHash: event.TxHash(),
+			Args:   event.Args,
+		})
 	default:
-		return fmt.Errorf("unknown event hash %v", log.Topics[0])
+		return common.InconsistencyErrorf("unsupported event %v", event.Name)
 	}
 
+	result[crcl] = details
+
 	return nil
 }
 
@@ -91,13 +155,6 @@ func updateOnTransfer(details EventAuditDetails, log *types.Log) {
 	// transfer or withdraw
 	if sender != common.ZeroAddress {
 		details.BalanceReduced.Add(sender, amount)
-
-		// monitor withdraw event for market makers
-		if recipient == common.ZeroAddress {
-			if config.MarketMakers[sender] {
-				common.Notifyf("Withdraw from market maker, address = %v, amount = %v", sender, amount)
-			}
-		}
 	}
 
 	// transfer or deposit
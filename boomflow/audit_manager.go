@@ -3,6 +3,9 @@ package boomflow
 import (
 	"fmt"
 	"math/big"
+	"os"
+	"sync"
+	"time"
 
 	sdk "github.com/Conflux-Chain/go-conflux-sdk"
 	"github.com/Conflux-Chain/go-conflux-sdk/types"
@@ -12,6 +15,9 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// MaxConcurrentAssets is the maximum number of assets to audit balance concurrently.
+var MaxConcurrentAssets = 4
+
 // AuditManager audits data for all ERC777 and CRCL contracts of boomflow.
 type AuditManager struct {
 	Cfx *sdk.Client
@@ -19,15 +25,37 @@ type AuditManager struct {
 	auditors         map[string]*AssetAuditor // key is asset name
 	lastAuditEpoch   *big.Int
 	lastAuditDetails map[string]*BalanceAuditDetails // key is asset name
+	baselineEpoch    *big.Int                        // epoch of last balance audit
+	store            *common.Store                   // checkpoint store, optional
+	indexStore       *common.Store                   // account index store, optional
+
+	accountsChanged         map[string]map[string]bool  // accounts changed since last checkpoint, key is asset, nil means all
+	rootsChanged            map[string]bool             // assets changed since last recorded merkle root
+	forceWithdrawals        map[string]*ForceWithdrawal // pending forced withdrawals, key is store key
+	forceWithdrawalsChanged map[string]bool             // forced withdrawals changed since last checkpoint
+	marketMakers            *marketMakerMonitor         // withdrawal policies of market makers
+	anomalies               *anomalyDetector            // baselines of CRCL flows
 
 	pollLogAddresses []types.Address
+	logFetcher       *common.EpochLogFetcher
+	fillAuditor      *FillAuditor // audit Fill events if Boomflow address configured
+	pivots           *common.PivotTracker
+	reorgEpoch       *big.Int          // earliest reorged epoch that requires to audit again
 	crcl2AssetMap    map[string]string // crcl address to asset name map
+
+	matchflowURL   string                   // poll new assets if configured
+	pendingAssets  map[string]common.Asset  // new assets to audit balance as baseline, key is asset name
+	pendingRetries map[string]*pendingRetry // pending assets that failed to audit balance, key is asset name
+	lastReload     time.Time                // last time to poll new assets and check config file
+	configModTime  time.Time                // modification time of loaded config file
 }
 
 // NewAuditManager creates an instance of AuditManager.
 func NewAuditManager(cfxURL, matchflowURL string) *AuditManager {
 	assets := common.GetAssets(matchflowURL)
-	return NewAuditManagerWithAssets(cfxURL, assets)
+	am := NewAuditManagerWithAssets(cfxURL, assets)
+	am.matchflowURL = matchflowURL
+	return am
 }
 
 // NewAuditManagerWithAssets creates an instance of AuditManager.
@@ -39,39 +67,185 @@ func NewAuditManagerWithAssets(cfxURL string, assets []common.Asset) *AuditManag
 		auditors:         make(map[string]*AssetAuditor),
 		lastAuditDetails: make(map[string]*BalanceAuditDetails),
 		crcl2AssetMap:    make(map[string]string),
+		pendingAssets:    make(map[string]common.Asset),
+		pendingRetries:   make(map[string]*pendingRetry),
+
+		accountsChanged:         make(map[string]map[string]bool),
+		rootsChanged:            make(map[string]bool),
+		forceWithdrawals:        make(map[string]*ForceWithdrawal),
+		forceWithdrawalsChanged: make(map[string]bool),
+		marketMakers:            newMarketMakerMonitor(),
+		anomalies:               newAnomalyDetector(),
+	}
+
+	if config.AccountSource == AccountSourceIndexer {
+		store, err := common.OpenStore(config.IndexerPath)
+		if err != nil {
+			logger.WithError(err).Fatal("failed to open account index store")
+		}
+
+		am.indexStore = store
 	}
 
 	for _, asset := range assets {
-		am.auditors[asset.Name] = NewAssetAuditor(asset, cfx)
-		am.pollLogAddresses = append(am.pollLogAddresses, cfxaddress.MustNewFromHex(asset.ContractAddress, common.GetNetworkId()))
-		am.crcl2AssetMap[asset.ContractAddress] = asset.Name
+		am.addAssetAuditor(am.newAssetAuditor(asset))
+	}
+
+	am.logFetcher = common.NewEpochLogFetcher(cfx, am.pollLogAddresses, nil)
+	am.pivots = common.NewPivotTracker(cfx, module)
+
+	if len(config.BoomflowAddress) > 0 {
+		am.fillAuditor = NewFillAuditor(cfx, config.BoomflowAddress)
+	}
+
+	if info, err := os.Stat(configPath); err == nil {
+		am.configModTime = info.ModTime()
 	}
 
 	return &am
 }
 
+func (am *AuditManager) newAssetAuditor(asset common.Asset) *AssetAuditor {
+	auditor := NewAssetAuditor(asset, am.Cfx)
+	if am.indexStore != nil {
+		auditor.accounts = newAccountIndexer(am.Cfx, asset, auditor.crcl, am.indexStore)
+	}
+
+	return auditor
+}
+
+// addAssetAuditor adds the asset auditor, and the CRCL event logs of asset will be polled
+// after the log fetcher updated.
+func (am *AuditManager) addAssetAuditor(auditor *AssetAuditor) {
+	asset := auditor.asset
+	am.auditors[asset.Name] = auditor
+	am.pollLogAddresses = append(am.pollLogAddresses, cfxaddress.MustNewFromHex(asset.ContractAddress, common.GetNetworkId()))
+	am.crcl2AssetMap[asset.ContractAddress] = asset.Name
+}
+
+// removeAssetAuditor removes the asset auditor, and updates the log fetcher accordingly.
+func (am *AuditManager) removeAssetAuditor(name string) {
+	auditor, ok := am.auditors[name]
+	if !ok {
+		return
+	}
+
+	delete(am.auditors, name)
+	delete(am.lastAuditDetails, name)
+	delete(am.accountsChanged, name)
+	delete(am.rootsChanged, name)
+	delete(am.crcl2AssetMap, auditor.asset.ContractAddress)
+
+	am.pollLogAddresses = nil
+	for _, auditor := range am.auditors {
+		am.pollLogAddresses = append(am.pollLogAddresses, cfxaddress.MustNewFromHex(auditor.asset.ContractAddress, common.GetNetworkId()))
+	}
+
+	am.logFetcher.SetAddresses(am.pollLogAddresses)
+}
+
 // Close releases resources hold by Boomflow auditor.
 func (am *AuditManager) Close() {
 	am.Cfx.Close()
+
+	if am.store != nil {
+		am.store.Close()
+	}
+
+	if am.indexStore != nil {
+		am.indexStore.Close()
+	}
+}
+
+// assetBalanceAudit is the result of balance audit for an asset.
+type assetBalanceAudit struct {
+	asset   string
+	details *BalanceAuditDetails
+	err     error
+}
+
+// auditBalanceConcurrently audits balance for all assets in goroutines, at most MaxConcurrentAssets
+// at the same time. Note, RPC requests to full node are throttled by common.RPCLimiter.
+func (am *AuditManager) auditBalanceConcurrently(epoch *big.Int) (map[string]*BalanceAuditDetails, error) {
+	concurrency := MaxConcurrentAssets
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	sem := make(chan struct{}, concurrency)
+	resultCh := make(chan assetBalanceAudit, len(am.auditors))
+
+	var wg sync.WaitGroup
+	for asset, auditor := range am.auditors {
+		wg.Add(1)
+		go func(asset string, auditor *AssetAuditor) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			logger.WithFields(logrus.Fields{
+				"asset": asset,
+				"epoch": epoch,
+			}).Debug("begin to audit balance")
+
+			details, err := auditor.AuditBalance(epoch)
+			resultCh <- assetBalanceAudit{asset, details, err}
+		}(asset, auditor)
+	}
+
+	wg.Wait()
+	close(resultCh)
+
+	// inconsistency and permanent error take precedence over transient error
+	var auditErr error
+	allDetails := make(map[string]*BalanceAuditDetails)
+	for result := range resultCh {
+		if result.err == nil {
+			allDetails[result.asset] = result.details
+		} else if auditErr == nil || (common.IsTransient(auditErr) && !common.IsTransient(result.err)) {
+			auditErr = errors.WithMessagef(result.err, "failed to audit balance for asset %v", result.asset)
+		}
+	}
+
+	return allDetails, auditErr
 }
 
 // AuditBalance audits balance for the specified epoch.
 func (am *AuditManager) AuditBalance(epoch *big.Int) error {
 	logger := logger.WithField("epoch", epoch)
 
-	// Could use goroutine for each asset if perf is low.
-	// Note, do not violate the throttling on full node.
-	for asset, auditor := range am.auditors {
-		logger.WithField("asset", asset).Debug("begin to audit balance")
-		details, err := auditor.AuditBalance(epoch)
-		if err != nil {
-			return errors.WithMessagef(err, "failed to audit balance for asset %v", asset)
-		}
+	allDetails, err := am.auditBalanceConcurrently(epoch)
+	if err != nil {
+		return err
+	}
 
+	var assets []string
+	for asset := range allDetails {
+		assets = append(assets, asset)
+	}
+
+	// update baseline only if all assets audited
+	for asset, details := range allDetails {
 		am.lastAuditDetails[asset] = details
+		am.accountsChanged[asset] = nil
 	}
 
 	am.lastAuditEpoch = epoch
+	am.baselineEpoch = epoch
+	am.reorgEpoch = nil
+
+	if err = am.updateMerkleRoots(assets); err != nil {
+		return err
+	}
+
+	if err := am.pivots.Record(epoch); err != nil {
+		logger.WithError(err).Warn("failed to record pivot block")
+	}
+
+	if err := am.saveCheckpoint(assets); err != nil {
+		return errors.WithMessagef(err, "failed to save checkpoint for epoch %v", epoch)
+	}
 
 	return nil
 }
@@ -88,13 +262,21 @@ func (am *AuditManager) AuditBalanceForAsset(asset string, epoch *big.Int) (*Bal
 		return nil, errors.WithMessagef(err, "failed to audit balance for asset %v", asset)
 	}
 
+	tree, err := common.NewMerkleTree(details.AccountBalances)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to build merkle tree for asset %v", asset)
+	}
+
+	details.MerkleRoot = tree.Root()
+
 	return details, nil
 }
 
 // AuditNextEpoch audits event logs in CRCL for the next epoch of last balance audit details.
-func (am *AuditManager) AuditNextEpoch() error {
+// If failed, the last balance audit details keep unchanged, so that the epoch could be audited again.
+func (am *AuditManager) AuditNextEpoch() (err error) {
 	if len(am.auditors) != len(am.lastAuditDetails) {
-		return fmt.Errorf("any asset has no baseline yet")
+		return common.PermanentErrorf("any asset has no baseline yet")
 	}
 
 	epoch := new(big.Int).Add(am.lastAuditEpoch, common.Big1)
@@ -113,29 +295,92 @@ func (am *AuditManager) AuditNextEpoch() error {
 		am.lastAuditDetails[asset].TotalSupply = total
 	}
 
-	// audit event logs
-	allDetails, err := AuditEventLogs(am.Cfx, epoch, am.pollLogAddresses)
+	// audit event logs, which are polled in batch for a window of epochs
+	var logs []types.Log
+	logs, err = am.logFetcher.GetLogs(epoch)
 	if err != nil {
+		return errors.WithMessagef(err, "failed to poll event logs for epoch %v", epoch)
+	}
+
+	var allDetails map[string]EventAuditDetails
+	if allDetails, err = auditLogs(logs); err != nil {
 		return errors.WithMessagef(err, "failed to audit event logs for epoch %v", epoch)
 	}
 
+	// revert the merged balance changes if any audit failed
+	merged := make(map[string]EventAuditDetails)
+	defer func() {
+		if err != nil {
+			am.revertBalanceChanges(merged)
+		}
+	}()
+
+	var changedAssets []string
 	for crcl, details := range allDetails {
-		if err = am.auditBalanceChange(crcl, details, epoch); err != nil {
-			return errors.WithMessagef(err, "failed to audit changed balances, epoch = %v, asset = %v (%v)", epoch, am.crcl2AssetMap[crcl], crcl)
+		asset, ok := am.crcl2AssetMap[crcl]
+		if !ok {
+			return common.InconsistencyErrorf("cannot find asset for CRCL address, epoch = %v, CRCL = %v", epoch, crcl)
+		}
+
+		changed := details.merge(am.lastAuditDetails[asset].AccountBalances)
+		merged[asset] = details
+
+		if err = am.auditBalanceChange(asset, details, changed, epoch, logs); err != nil {
+			return errors.WithMessagef(err, "failed to audit changed balances, epoch = %v, asset = %v (%v)", epoch, asset, crcl)
+		}
+
+		changedAssets = append(changedAssets, asset)
+	}
+
+	if am.fillAuditor != nil {
+		if err = am.fillAuditor.AuditFills(epoch, logs); err != nil {
+			return errors.WithMessagef(err, "failed to audit Fill events for epoch %v", epoch)
 		}
 	}
 
+	if err = am.trackForceWithdrawals(epoch, logs); err != nil {
+		return errors.WithMessagef(err, "failed to track forced withdrawals for epoch %v", epoch)
+	}
+
+	if err = am.monitorMarketMakers(epoch, logs); err != nil {
+		return errors.WithMessagef(err, "failed to monitor market makers for epoch %v", epoch)
+	}
+
+	am.detectAnomalies(epoch, allDetails, logs)
+
+	if err = am.pivots.Record(epoch); err != nil {
+		return errors.WithMessagef(err, "failed to record pivot block for epoch %v", epoch)
+	}
+
+	// epoch audited, and should not be reverted even if failed to save checkpoint
+	for asset, details := range merged {
+		am.markAccountsChanged(asset, details)
+	}
+	merged = nil
+
 	am.lastAuditEpoch = epoch
 
+	roots := am.merkleRootsToRecord(changedAssets)
+	if err = am.updateMerkleRoots(roots); err != nil {
+		return errors.WithMessagef(err, "failed to update merkle roots for epoch %v", epoch)
+	}
+
+	if err = am.saveCheckpoint(roots); err != nil {
+		return errors.WithMessagef(err, "failed to save checkpoint for epoch %v", epoch)
+	}
+
 	return nil
 }
 
-func (am *AuditManager) auditBalanceChange(crcl string, details EventAuditDetails, epochNum *big.Int) error {
-	asset, ok := am.crcl2AssetMap[crcl]
-	if !ok {
-		return fmt.Errorf("cannot find asset for CRCL address, epoch = %v, CRCL = %v", epochNum, crcl)
+// revertBalanceChanges reverts the balance changes merged into the last balance audit details,
+// key of specified details is asset name.
+func (am *AuditManager) revertBalanceChanges(merged map[string]EventAuditDetails) {
+	for asset, details := range merged {
+		details.revert(am.lastAuditDetails[asset].AccountBalances)
 	}
+}
 
+func (am *AuditManager) auditBalanceChange(asset string, details EventAuditDetails, changed map[string]*big.Int, epochNum *big.Int, logs []types.Log) error {
 	logger.WithFields(logrus.Fields{
 		"asset":     asset,
 		"epoch":     epochNum,
@@ -143,15 +388,24 @@ func (am *AuditManager) auditBalanceChange(crcl string, details EventAuditDetail
 		"reduced":   details.BalanceReduced,
 	}).Debug("balance changed")
 
+	for _, annotation := range details.Annotations {
+		logger.WithFields(logrus.Fields{
+			"asset":      asset,
+			"epoch":      epochNum,
+			"annotation": annotation,
+		}).Info("balance-neutral event found")
+
+		common.Notifyf("CRCL event %v, asset = %v, epoch = %v", annotation, asset, epochNum)
+	}
+
 	baseline := am.lastAuditDetails[asset]
-	changed := details.merge(baseline.AccountBalances)
 
 	logger.WithField("changed", changed).Debug("new balances for changed accounts")
 
 	// check the local updated balances with total supply
 	sum := baseline.AccountBalances.Sum()
 	if baseline.TotalSupply.Cmp(sum) != 0 {
-		return fmt.Errorf("inconsistent balance, total supply = %v, sum(accounts) = %v", baseline.TotalSupply, sum)
+		return common.InconsistencyErrorf("inconsistent balance, total supply = %v, sum(accounts) = %v", baseline.TotalSupply, sum)
 	}
 
 	epoch := types.NewEpochNumberBig(epochNum)
@@ -159,7 +413,8 @@ func (am *AuditManager) auditBalanceChange(crcl string, details EventAuditDetail
 	// check changed balance on chain
 	for account, balance := range changed {
 		if balance.Sign() < 0 {
-			return fmt.Errorf("the changed account balance is negative, asset = %v, epoch = %v, account = %v, balance = %v", asset, epochNum, account, balance)
+			err := common.InconsistencyErrorf("the changed account balance is negative, asset = %v, epoch = %v, account = %v, balance = %v", asset, epochNum, account, balance)
+			return am.locateInconsistency(err, asset, account, balance, details, epochNum, logs)
 		}
 
 		actualBalance, err := am.auditors[asset].crcl.BalanceOf(account, epoch)
@@ -168,9 +423,91 @@ func (am *AuditManager) auditBalanceChange(crcl string, details EventAuditDetail
 		}
 
 		if balance.Cmp(actualBalance) != 0 {
-			return fmt.Errorf("inconsistent balance, audit = %v, actual = %v, asset = %v, epoch = %v, account = %v", balance, actualBalance, asset, epochNum, account)
+			err := common.InconsistencyErrorf("inconsistent balance, audit = %v, actual = %v, asset = %v, epoch = %v, account = %v", balance, actualBalance, asset, epochNum, account)
+			return am.locateInconsistency(err, asset, account, balance, details, epochNum, logs)
 		}
 	}
 
 	return nil
 }
+
+// locateInconsistency drills down the inconsistent balance of account to transactions,
+// and appends the located transactions to the audit error.
+func (am *AuditManager) locateInconsistency(auditErr error, asset, account string, balance *big.Int, details EventAuditDetails,
+	epoch *big.Int, logs []types.Log) error {
+	// audited balance before epoch
+	balanceBefore := new(big.Int).Sub(balance, details.BalanceIncreased.Get(account))
+	balanceBefore.Add(balanceBefore, details.BalanceReduced.Get(account))
+
+	report, err := am.locateInconsistentTx(asset, account, balanceBefore, epoch, logs)
+	if err != nil {
+		logger.WithError(err).WithField("account", account).Warn("failed to locate inconsistent transaction")
+		return auditErr
+	}
+
+	logger.WithField("report", report).Error("inconsistent transaction located")
+
+	return common.InconsistencyErrorf("%v, located: %v", auditErr, report)
+}
+
+// CheckReorg checks the pivot blocks of audited epochs periodically. If pivot chain reorg
+// detected, audit balance as a new baseline before the reorged epoch, so that the reorged
+// epochs will be audited again. Errors after reorg detected are annotated with the epoch
+// before the reorged epoch by common.WithEpoch.
+func (am *AuditManager) CheckReorg() error {
+	reorg, err := am.pivots.Check()
+	if err != nil {
+		return errors.WithMessage(err, "failed to check pivot chain reorg")
+	}
+
+	if reorg != nil {
+		logger.WithFields(logrus.Fields{
+			"epoch": reorg.Epoch,
+			"depth": reorg.Depth,
+		}).Warn(reorg.String())
+
+		common.Alert(module, reorg.String())
+
+		if am.reorgEpoch == nil || am.reorgEpoch.Cmp(reorg.Epoch) > 0 {
+			am.reorgEpoch = reorg.Epoch
+		}
+
+		// drop the cached event logs of reorged epochs
+		am.logFetcher.Reset()
+		if am.fillAuditor != nil {
+			am.fillAuditor.logFetcher.Reset()
+		}
+
+		am.anomalies.rewind(reorg.Epoch)
+
+		// reorg records dropped, so rewind all before return any error
+		var rewindErr error
+		for asset, auditor := range am.auditors {
+			auditor.stranded.rewind(reorg.Epoch)
+
+			if indexer, ok := auditor.accounts.(*accountIndexer); ok {
+				if err = indexer.rewind(reorg.Epoch); err != nil && rewindErr == nil {
+					rewindErr = errors.WithMessagef(err, "failed to rewind account index of asset %v", asset)
+				}
+			}
+		}
+
+		if rewindErr != nil {
+			return common.WithEpoch(rewindErr, new(big.Int).Sub(am.reorgEpoch, common.Big1))
+		}
+	}
+
+	if am.reorgEpoch == nil {
+		return nil
+	}
+
+	// reorged epochs will be audited again after the failed epoch
+	epoch := new(big.Int).Sub(am.reorgEpoch, common.Big1)
+	if err = am.AuditBalance(epoch); err != nil {
+		return common.WithEpoch(errors.WithMessagef(err, "failed to audit balance before reorged epoch %v", am.reorgEpoch), epoch)
+	}
+
+	logger.WithField("epoch", epoch).Info("succeed to audit balance before reorged epoch")
+
+	return nil
+}
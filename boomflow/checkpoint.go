@@ -0,0 +1,279 @@
+package boomflow
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/open-dex/conflux-dex-audit/common"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	checkpointKey           = "checkpoint"
+	checkpointDetailsPrefix = "details/"
+	checkpointBalancePrefix = "balance/" // balance of each account, key is balance/asset/account
+)
+
+// checkpoint represents the last verified epoch of AuditManager. Balance audit details
+// of each asset are persisted separately, along with the balance of each account, so that
+// only the changed assets and accounts are saved.
+type checkpoint struct {
+	Epoch         *big.Int `json:"epoch"`         // last audited epoch
+	BaselineEpoch *big.Int `json:"baselineEpoch"` // epoch of last balance audit
+	Assets        []string `json:"assets"`        // assets that have balance audit details
+}
+
+// OpenCheckpoint opens the checkpoint store at the specified path, so that audit state
+// will be saved after each audited epoch. If reset is true, any saved checkpoint is removed.
+func (am *AuditManager) OpenCheckpoint(path string, reset bool) error {
+	store, err := common.OpenStore(path)
+	if err != nil {
+		return errors.WithMessage(err, "failed to open checkpoint store")
+	}
+
+	if reset {
+		if err = store.Reset(""); err != nil {
+			store.Close()
+			return errors.WithMessage(err, "failed to reset checkpoint store")
+		}
+
+		logger.WithField("path", path).Info("checkpoint reset")
+	}
+
+	am.store = store
+
+	if err = am.loadStrandedBalances(); err != nil {
+		return err
+	}
+
+	if err = am.loadMarketMakerMonitor(); err != nil {
+		return err
+	}
+
+	if err = am.loadAnomalyDetector(); err != nil {
+		return err
+	}
+
+	return am.loadForceWithdrawals()
+}
+
+// LoadCheckpoint loads the last saved checkpoint as baseline, and returns false if
+// there is no checkpoint or any asset has no balance audit details in checkpoint.
+func (am *AuditManager) LoadCheckpoint() (bool, error) {
+	if am.store == nil {
+		return false, nil
+	}
+
+	var cp checkpoint
+	found, err := am.store.Get(checkpointKey, &cp)
+	if err != nil {
+		return false, errors.WithMessage(err, "failed to load checkpoint")
+	}
+
+	if !found {
+		return false, nil
+	}
+
+	details := make(map[string]*BalanceAuditDetails)
+	var newAssets []string
+	for asset := range am.auditors {
+		var assetDetails BalanceAuditDetails
+		found, err = am.store.Get(checkpointDetailsPrefix+asset, &assetDetails)
+		if err != nil {
+			return false, errors.WithMessagef(err, "failed to load balance audit details of asset %v", asset)
+		}
+
+		if !found {
+			logger.WithField("asset", asset).Info("no balance audit details in checkpoint")
+			newAssets = append(newAssets, asset)
+			continue
+		}
+
+		balances, err := am.loadAccountBalances(asset)
+		if err != nil {
+			return false, err
+		}
+
+		// account balances saved along with details in old checkpoint, and will be
+		// saved separately in next checkpoint
+		if len(balances.Map()) == 0 && assetDetails.AccountBalances != nil {
+			am.accountsChanged[asset] = nil
+		} else {
+			assetDetails.AccountBalances = balances
+		}
+
+		details[asset] = &assetDetails
+	}
+
+	if len(details) == 0 {
+		return false, nil
+	}
+
+	// assets listed after checkpoint saved will be audited as new assets, so that the
+	// audit state of existing assets is kept
+	for _, asset := range newAssets {
+		am.pendingAssets[asset] = am.auditors[asset].asset
+		am.removeAssetAuditor(asset)
+	}
+
+	am.lastAuditEpoch = cp.Epoch
+	am.baselineEpoch = cp.BaselineEpoch
+	am.lastAuditDetails = details
+
+	// balances may have changed since last recorded merkle roots
+	for asset := range details {
+		am.rootsChanged[asset] = true
+	}
+
+	logger.WithFields(logrus.Fields{
+		"epoch":         cp.Epoch,
+		"baselineEpoch": cp.BaselineEpoch,
+	}).Info("succeed to load checkpoint")
+
+	return true, nil
+}
+
+// loadAccountBalances loads the balance of all accounts of asset from checkpoint store.
+func (am *AuditManager) loadAccountBalances(asset string) (*common.AccountBalances, error) {
+	prefix := checkpointBalancePrefix + asset + "/"
+
+	keys, err := am.store.Keys(prefix)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to list accounts of asset %v", asset)
+	}
+
+	balances := common.NewAccountBalances()
+	for _, key := range keys {
+		balance := new(big.Int)
+		if _, err = am.store.Get(key, balance); err != nil {
+			return nil, errors.WithMessagef(err, "failed to load account balance %v", key)
+		}
+
+		balances.Add(strings.TrimPrefix(key, prefix), balance)
+	}
+
+	return balances, nil
+}
+
+// markAccountsChanged marks the accounts changed by event logs to save in next checkpoint.
+func (am *AuditManager) markAccountsChanged(asset string, details EventAuditDetails) {
+	accounts, ok := am.accountsChanged[asset]
+	if ok && accounts == nil {
+		return // all accounts to save
+	}
+
+	if !ok {
+		accounts = make(map[string]bool)
+		am.accountsChanged[asset] = accounts
+	}
+
+	for _, changes := range []*common.AccountBalances{details.BalanceIncreased, details.BalanceReduced} {
+		for account := range changes.Map() {
+			accounts[strings.ToLower(account)] = true
+		}
+	}
+}
+
+// putAccountBalances adds the balance audit details and the balance of accounts changed
+// since last checkpoint into batch. For a new baseline, all accounts are saved instead.
+func (am *AuditManager) putAccountBalances(batch *common.StoreBatch) error {
+	for asset, accounts := range am.accountsChanged {
+		details := am.lastAuditDetails[asset]
+
+		// account balances are saved separately
+		summary := BalanceAuditDetails{
+			TotalSupply: details.TotalSupply,
+			MerkleRoot:  details.MerkleRoot,
+		}
+
+		if err := batch.Put(checkpointDetailsPrefix+asset, &summary); err != nil {
+			return errors.WithMessagef(err, "failed to save balance audit details of asset %v", asset)
+		}
+
+		prefix := checkpointBalancePrefix + asset + "/"
+
+		if accounts == nil {
+			stale, err := am.store.Keys(prefix)
+			if err != nil {
+				return errors.WithMessagef(err, "failed to list accounts of asset %v", asset)
+			}
+
+			for _, key := range stale {
+				batch.Delete(key)
+			}
+
+			accounts = make(map[string]bool)
+			for account := range details.AccountBalances.Map() {
+				accounts[account] = true
+			}
+		}
+
+		for account := range accounts {
+			if err := batch.Put(prefix+account, details.AccountBalances.Get(account)); err != nil {
+				return errors.WithMessagef(err, "failed to save balance of account %v, asset = %v", account, asset)
+			}
+		}
+	}
+
+	return nil
+}
+
+// saveCheckpoint saves the last audited epoch along with the balance audit details changed
+// since last checkpoint, and records merkle roots of specified assets.
+func (am *AuditManager) saveCheckpoint(assets []string) error {
+	if am.store == nil {
+		return nil
+	}
+
+	batch := common.NewStoreBatch()
+
+	if err := am.putAccountBalances(batch); err != nil {
+		return err
+	}
+
+	if err := am.putMerkleRoots(batch, assets); err != nil {
+		return err
+	}
+
+	if err := am.putForceWithdrawals(batch); err != nil {
+		return err
+	}
+
+	if err := am.putStrandedBalances(batch); err != nil {
+		return err
+	}
+
+	if err := am.putMarketMakerMonitor(batch); err != nil {
+		return err
+	}
+
+	if err := am.putAnomalyDetector(batch); err != nil {
+		return err
+	}
+
+	cp := checkpoint{
+		Epoch:         am.lastAuditEpoch,
+		BaselineEpoch: am.baselineEpoch,
+	}
+
+	for asset := range am.lastAuditDetails {
+		cp.Assets = append(cp.Assets, asset)
+	}
+
+	if err := batch.Put(checkpointKey, &cp); err != nil {
+		return errors.WithMessage(err, "failed to save checkpoint")
+	}
+
+	if err := am.store.Write(batch); err != nil {
+		return err
+	}
+
+	am.accountsChanged = make(map[string]map[string]bool)
+
+	for _, asset := range assets {
+		delete(am.rootsChanged, asset)
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package boomflow
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/open-dex/conflux-dex-audit/common"
+)
+
+func TestCheckpointAccountBalances(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := common.OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	am := AuditManager{
+		store:            store,
+		lastAuditDetails: make(map[string]*BalanceAuditDetails),
+		accountsChanged:  make(map[string]map[string]bool),
+	}
+
+	save := func() {
+		batch := common.NewStoreBatch()
+		if err := am.putAccountBalances(batch); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Write(batch); err != nil {
+			t.Fatal(err)
+		}
+		am.accountsChanged = make(map[string]map[string]bool)
+	}
+
+	check := func(expected map[string]int64) {
+		loaded, err := am.loadAccountBalances("CFX")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(loaded.Map()) != len(expected) {
+			t.Fatalf("loaded balances = %v", loaded)
+		}
+		for account, balance := range expected {
+			if loaded.Get(account).Int64() != balance {
+				t.Fatalf("balance of %v = %v, expected %v", account, loaded.Get(account), balance)
+			}
+		}
+	}
+
+	// baseline saves all accounts
+	balances := common.NewAccountBalances()
+	balances.Add("0xA", big.NewInt(1))
+	balances.Add("0xb", big.NewInt(2))
+	am.lastAuditDetails["CFX"] = &BalanceAuditDetails{TotalSupply: big.NewInt(3), AccountBalances: balances}
+	am.accountsChanged["CFX"] = nil
+	save()
+	check(map[string]int64{"0xa": 1, "0xb": 2})
+
+	// only changed accounts are saved
+	increased := common.NewAccountBalances()
+	increased.Add("0xA", big.NewInt(5))
+	balances.Add("0xA", big.NewInt(5))
+	balances.Add("0xc", big.NewInt(7)) // not marked, so not saved
+	am.markAccountsChanged("CFX", EventAuditDetails{BalanceIncreased: increased, BalanceReduced: common.NewAccountBalances()})
+	save()
+	check(map[string]int64{"0xa": 6, "0xb": 2})
+
+	// new baseline removes stale accounts
+	balances = common.NewAccountBalances()
+	balances.Add("0xd", big.NewInt(4))
+	am.lastAuditDetails["CFX"] = &BalanceAuditDetails{TotalSupply: big.NewInt(4), AccountBalances: balances}
+	am.accountsChanged["CFX"] = nil
+	save()
+	check(map[string]int64{"0xd": 4})
+
+	var details BalanceAuditDetails
+	if _, err = store.Get(checkpointDetailsPrefix+"CFX", &details); err != nil {
+		t.Fatal(err)
+	}
+	if details.AccountBalances != nil || details.TotalSupply.Int64() != 4 {
+		t.Fatalf("details = %+v", details)
+	}
+}
@@ -2,43 +2,145 @@ package boomflow
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"math/big"
+	"strings"
 
 	"github.com/open-dex/conflux-dex-audit/common"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
-var config = boomflowConfig{
-	MarketMakers:       make(map[string]bool),
-	BalancesByTransfer: make(map[string]string),
+var (
+	config = newDefaultConfig()
+
+	// configPath is the path of loaded config file, which is watched and reloaded by audit
+	// service. Empty value means default config used.
+	configPath string
+)
+
+func newDefaultConfig() boomflowConfig {
+	return boomflowConfig{
+		MarketMakers:                   make(map[string]bool),
+		MarketMakerPolicies:            make(map[string]MarketMakerPolicy),
+		BalancesByTransfer:             make(map[string]string),
+		ForceWithdrawAlertAheadSeconds: 3600,
+		AccountSource:                  AccountSourceMigration,
+		IndexerPath:                    "./leveldb/boomflow/indexer",
+		DeploymentEpochs:               make(map[string]uint64),
+		Anomaly: AnomalyConfig{
+			Alpha:          0.01,
+			Threshold:      6,
+			MinSamples:     100,
+			ShareOfSupply:  0.05,
+			InactiveEpochs: 2592000,
+		},
+	}
 }
 
 type boomflowConfig struct {
+	BoomflowAddress    string            `json:"boomflowAddress"` // audit Fill events if configured
 	MarketMakers       map[string]bool   `json:"marketMakers"`
-	BalancesByTransfer map[string]string `json:"balancesByTransfer"`
+	BalancesByTransfer map[string]string `json:"balancesByTransfer"` // optional, overrides the tracked stranded balance
+
+	// withdrawal policies of market makers, key is market maker address
+	MarketMakerPolicies map[string]MarketMakerPolicy `json:"marketMakerPolicies"`
+
+	// alert ahead of the time when forced withdrawal becomes executable
+	ForceWithdrawAlertAheadSeconds int64 `json:"forceWithdrawAlertAheadSeconds"`
+
+	// source to list accounts in CRCL, migration or indexer
+	AccountSource    string            `json:"accountSource"`
+	IndexerPath      string            `json:"indexerPath"`      // path to leveldb folder of account index
+	DeploymentEpochs map[string]uint64 `json:"deploymentEpochs"` // key is asset name, value is CRCL deployment epoch
+
+	// anomaly detection on CRCL flows
+	Anomaly AnomalyConfig `json:"anomaly"`
 }
 
+// getBalanceByTransfer returns the configured balance that transferred to CRCL not by send
+// method. If not configured, nil is returned to track the stranded balance when deployment
+// epoch of asset configured, otherwise 0 by default.
 func (config boomflowConfig) getBalanceByTransfer(asset string) *big.Int {
-	balance, ok := config.BalancesByTransfer[asset]
-	if !ok {
-		return common.Big0
+	if balance, ok := config.BalancesByTransfer[asset]; ok {
+		if result, ok := new(big.Int).SetString(balance, 10); ok {
+			return result
+		}
+
+		logger.WithFields(logrus.Fields{
+			"asset":   asset,
+			"balance": balance,
+		}).Warn("invalid balance by transfer configured, ignored")
 	}
 
-	result, ok := new(big.Int).SetString(balance, 10)
-	if !ok {
-		return common.Big0
+	if config.DeploymentEpochs[asset] > 0 {
+		return nil
 	}
 
-	return result
+	return common.Big0
 }
 
-func init() {
-	data, err := ioutil.ReadFile("./boomflow/config.json")
+// loadConfig reads and validates the config file at specified path.
+func loadConfig(path string) (*boomflowConfig, error) {
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		logger.WithError(err).Fatal("failed to read config file")
+		return nil, errors.WithMessage(err, "failed to read config file")
+	}
+
+	result := newDefaultConfig()
+	if err = json.Unmarshal(data, &result); err != nil {
+		return nil, errors.WithMessage(err, "failed to unmarshal config")
 	}
 
-	if err = json.Unmarshal(data, &config); err != nil {
-		logger.WithError(err).Fatal("failed to unmarshal config")
+	if result.AccountSource != AccountSourceMigration && result.AccountSource != AccountSourceIndexer {
+		return nil, fmt.Errorf("invalid account source %v", result.AccountSource)
+	}
+
+	if result.AccountSource == AccountSourceIndexer {
+		if len(result.DeploymentEpochs) == 0 {
+			return nil, fmt.Errorf("deployment epochs required for indexer account source")
+		}
+
+		for asset, epoch := range result.DeploymentEpochs {
+			if epoch == 0 {
+				return nil, fmt.Errorf("invalid deployment epoch of asset %v", asset)
+			}
+		}
 	}
+
+	if err = result.Anomaly.validate(); err != nil {
+		return nil, errors.WithMessage(err, "invalid anomaly config")
+	}
+
+	// addresses in event logs are in lower case
+	policies := make(map[string]MarketMakerPolicy)
+	for marketMaker, policy := range result.MarketMakerPolicies {
+		if err = policy.validate(); err != nil {
+			return nil, errors.WithMessagef(err, "invalid policy of market maker %v", marketMaker)
+		}
+
+		for i, recipient := range policy.AllowedRecipients {
+			policy.AllowedRecipients[i] = strings.ToLower(recipient)
+		}
+
+		policies[strings.ToLower(marketMaker)] = policy
+	}
+	result.MarketMakerPolicies = policies
+
+	return &result, nil
+}
+
+// LoadConfig loads the config file at specified path, which will be watched and reloaded by
+// the audit service. Default config is used if not loaded.
+func LoadConfig(path string) error {
+	loaded, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	config = *loaded
+	configPath = path
+
+	return nil
 }
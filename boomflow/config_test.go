@@ -0,0 +1,74 @@
+package boomflow
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/open-dex/conflux-dex-audit/common"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boomflow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, c := range []struct {
+		name  string
+		data  string
+		valid bool
+	}{
+		{"empty", `{}`, true},
+		{"default file", "", true},
+		{"invalid account source", `{"accountSource": "unknown"}`, false},
+		{"indexer without deployment epochs", `{"accountSource": "indexer"}`, false},
+		{"indexer with zero deployment epoch", `{"accountSource": "indexer", "deploymentEpochs": {"CFX": 0}}`, false},
+		{"indexer", `{"accountSource": "indexer", "deploymentEpochs": {"CFX": 100}}`, true},
+		{"invalid anomaly", `{"anomaly": {"alpha": 2}}`, false},
+	} {
+		path := "config.json"
+		if len(c.data) > 0 {
+			path = filepath.Join(dir, "config.json")
+			if err = ioutil.WriteFile(path, []byte(c.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if _, err = loadConfig(path); (err == nil) != c.valid {
+			t.Errorf("%v: valid = %v, err = %v", c.name, c.valid, err)
+		}
+	}
+}
+
+func TestLoadConfigMissingFile(t *testing.T) {
+	if err := LoadConfig("not-exist.json"); err == nil {
+		t.Fatal("error expected")
+	}
+
+	// default config kept if failed to load
+	if len(configPath) != 0 || config.AccountSource != AccountSourceMigration {
+		t.Errorf("config changed, path = %v, account source = %v", configPath, config.AccountSource)
+	}
+}
+
+func TestGetBalanceByTransfer(t *testing.T) {
+	c := newDefaultConfig()
+	c.BalancesByTransfer = map[string]string{"CFX": "10", "ETH": "invalid"}
+	c.DeploymentEpochs = map[string]uint64{"ETH": 100, "USDT": 100}
+
+	for asset, expected := range map[string]*big.Int{
+		"CFX":  big.NewInt(10),
+		"ETH":  nil, // invalid value ignored, tracked since deployment epoch
+		"USDT": nil,
+		"BTC":  common.Big0,
+	} {
+		actual := c.getBalanceByTransfer(asset)
+		if (actual == nil) != (expected == nil) || (actual != nil && actual.Cmp(expected) != 0) {
+			t.Errorf("%v: expected = %v, actual = %v", asset, expected, actual)
+		}
+	}
+}
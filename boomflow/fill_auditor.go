@@ -0,0 +1,191 @@
+package boomflow
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	sdk "github.com/Conflux-Chain/go-conflux-sdk"
+	"github.com/Conflux-Chain/go-conflux-sdk/types"
+	"github.com/Conflux-Chain/go-conflux-sdk/types/cfxaddress"
+	"github.com/open-dex/conflux-dex-audit/common"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// pricePrecision is the precision of price in Fill event.
+var pricePrecision = new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+
+// txBalanceChanges represents the net balance changes of a transaction, key is CRCL address
+// and account in lower case hex.
+type txBalanceChanges map[string]map[string]*big.Int
+
+func (changes txBalanceChanges) add(crcl, account string, amount *big.Int) {
+	if _, ok := changes[crcl]; !ok {
+		changes[crcl] = make(map[string]*big.Int)
+	}
+
+	if current, ok := changes[crcl][account]; ok {
+		changes[crcl][account] = new(big.Int).Add(current, amount)
+	} else {
+		changes[crcl][account] = new(big.Int).Set(amount)
+	}
+}
+
+func (changes txBalanceChanges) get(crcl, account string) *big.Int {
+	if amount, ok := changes[crcl][account]; ok {
+		return amount
+	}
+
+	return common.Big0
+}
+
+// FillAuditor audits the Fill events of Boomflow against CRCL transfers in the same transaction.
+type FillAuditor struct {
+	decoder    *common.EventDecoder
+	logFetcher *common.EpochLogFetcher
+	logger     logrus.FieldLogger
+}
+
+// NewFillAuditor creates an instance of FillAuditor for the Boomflow contract at specified address.
+func NewFillAuditor(cfx *sdk.Client, boomflow string) *FillAuditor {
+	decoder := common.MustNewEventDecoder(common.BoomflowABI)
+	addresses := []types.Address{cfxaddress.MustNewFromHex(boomflow, common.GetNetworkId())}
+	topics := [][]types.Hash{{decoder.EventHash("Fill")}}
+
+	return &FillAuditor{
+		decoder:    decoder,
+		logFetcher: common.NewEpochLogFetcher(cfx, addresses, topics),
+		logger:     logger.WithField("boomflow", boomflow),
+	}
+}
+
+// AuditFills decodes the Fill events of specified epoch, and checks the base, quote and fee
+// transfers implied by Fill events against the CRCL Transfer events in the same transaction.
+func (auditor *FillAuditor) AuditFills(epoch *big.Int, crclLogs []types.Log) error {
+	logs, err := auditor.logFetcher.GetLogs(epoch)
+	if err != nil {
+		return errors.WithMessagef(err, "failed to poll Fill event logs for epoch %v", epoch)
+	}
+
+	if len(logs) == 0 {
+		return nil
+	}
+
+	// balance changes implied by Fill events
+	var txHashes []string
+	implied := make(map[string]txBalanceChanges)
+
+	for i := range logs {
+		event, err := auditor.decoder.Decode(&logs[i])
+		if err != nil {
+			return errors.WithMessagef(err, "failed to decode Fill event, epoch = %v, log index = %v", epoch, i)
+		}
+
+		txHash := event.TxHash()
+		if _, ok := implied[txHash]; !ok {
+			txHashes = append(txHashes, txHash)
+			implied[txHash] = make(txBalanceChanges)
+		}
+
+		applyFill(implied[txHash], event)
+	}
+
+	// balance changes of CRCL transfers in the transactions with Fill events
+	actual := make(map[string]txBalanceChanges)
+	for i := range crclLogs {
+		log := &crclLogs[i]
+		txHash := log.TransactionHash.String()
+		if _, ok := implied[txHash]; !ok || len(log.Topics) == 0 || log.Topics[0] != common.EventHashTransfer {
+			continue
+		}
+
+		if _, ok := actual[txHash]; !ok {
+			actual[txHash] = make(txBalanceChanges)
+		}
+
+		crcl := strings.ToLower(log.Address.GetHexAddress())
+		sender, recipient, amount := decodeCrclEvent(log)
+		actual[txHash].add(crcl, sender, new(big.Int).Neg(amount))
+		actual[txHash].add(crcl, recipient, amount)
+	}
+
+	for _, txHash := range txHashes {
+		if mismatches := compareBalanceChanges(implied[txHash], actual[txHash]); len(mismatches) > 0 {
+			return common.InconsistencyErrorf("CRCL transfers mismatch with Fill events, epoch = %v, tx = %v, mismatches = %v", epoch, txHash, strings.Join(mismatches, "; "))
+		}
+
+		auditor.logger.WithFields(logrus.Fields{
+			"epoch": epoch,
+			"tx":    txHash,
+		}).Trace("succeed to audit Fill events")
+	}
+
+	return nil
+}
+
+// applyFill adds the balance changes implied by Fill event, in which fee is charged
+// in the received asset:
+// 1) Buy: base += tradeAmount - fee, quote -= funds, and fee in base to feeAddress.
+// 2) Sell: base -= tradeAmount, quote += funds - fee, and fee in quote to feeAddress.
+func applyFill(changes txBalanceChanges, event *common.Event) {
+	user := event.Address("userAddress")
+	base := event.Address("baseAssetAddress")
+	quote := event.Address("quoteAssetAddress")
+	feeAddress := event.Address("feeAddress")
+	tradeAmount := event.BigInt("tradeAmount")
+	fee := event.BigInt("fee")
+
+	funds := new(big.Int).Mul(tradeAmount, event.BigInt("price"))
+	funds.Div(funds, pricePrecision)
+
+	if buy, _ := event.Args["side"].(bool); buy {
+		changes.add(base, user, new(big.Int).Sub(tradeAmount, fee))
+		changes.add(base, feeAddress, fee)
+		changes.add(quote, user, new(big.Int).Neg(funds))
+	} else {
+		changes.add(base, user, new(big.Int).Neg(tradeAmount))
+		changes.add(quote, user, new(big.Int).Sub(funds, fee))
+		changes.add(quote, feeAddress, fee)
+	}
+}
+
+// compareBalanceChanges returns the mismatched net balance changes of accounts.
+func compareBalanceChanges(implied, actual txBalanceChanges) []string {
+	var mismatches []string
+
+	check := func(changes txBalanceChanges) {
+		for crcl, accounts := range changes {
+			for account := range accounts {
+				if account == common.ZeroAddress {
+					continue
+				}
+
+				expected, got := implied.get(crcl, account), actual.get(crcl, account)
+				if expected.Cmp(got) == 0 {
+					continue
+				}
+
+				mismatch := fmt.Sprintf("CRCL = %v, account = %v, fill = %v, transfer = %v", crcl, account, expected, got)
+				if !containsString(mismatches, mismatch) {
+					mismatches = append(mismatches, mismatch)
+				}
+			}
+		}
+	}
+
+	check(implied)
+	check(actual)
+
+	return mismatches
+}
+
+func containsString(items []string, item string) bool {
+	for _, v := range items {
+		if v == item {
+			return true
+		}
+	}
+
+	return false
+}
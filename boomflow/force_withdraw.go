@@ -0,0 +1,214 @@
+package boomflow
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/Conflux-Chain/go-conflux-sdk/types"
+	"github.com/open-dex/conflux-dex-audit/common"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const forceWithdrawPrefix = "forceWithdraw/"
+
+// ForceWithdrawal represents a forced withdrawal scheduled by user in CRCL, which could be
+// executed by user without DEX after the defer time.
+type ForceWithdrawal struct {
+	Asset          string   `json:"asset"`
+	Account        string   `json:"account"`
+	Epoch          *big.Int `json:"epoch"`          // epoch of ScheduleWithdraw event
+	TxHash         string   `json:"txHash"`         // transaction of ScheduleWithdraw event
+	ScheduledTime  int64    `json:"scheduledTime"`  // unix timestamp of request
+	ExecutableTime int64    `json:"executableTime"` // unix timestamp since when user could force withdraw
+	Amount         *big.Int `json:"amount"`         // account balance when scheduled
+	Warned         bool     `json:"warned"`         // whether alerted before defer window ends
+}
+
+// String implements the fmt.Stringer interface.
+func (w *ForceWithdrawal) String() string {
+	return fmt.Sprintf("{asset = %v, account = %v, amount = %v, scheduled = %v, executable = %v, tx = %v}",
+		w.Asset, w.Account, w.Amount, time.Unix(w.ScheduledTime, 0), time.Unix(w.ExecutableTime, 0), w.TxHash)
+}
+
+func forceWithdrawKey(asset, account string) string {
+	return forceWithdrawPrefix + asset + "/" + strings.ToLower(account)
+}
+
+// loadForceWithdrawals loads the pending forced withdrawals from checkpoint store.
+func (am *AuditManager) loadForceWithdrawals() error {
+	keys, err := am.store.Keys(forceWithdrawPrefix)
+	if err != nil {
+		return errors.WithMessage(err, "failed to list forced withdrawals")
+	}
+
+	for _, key := range keys {
+		var withdrawal ForceWithdrawal
+		if _, err = am.store.Get(key, &withdrawal); err != nil {
+			return errors.WithMessagef(err, "failed to load forced withdrawal %v", key)
+		}
+
+		am.forceWithdrawals[key] = &withdrawal
+	}
+
+	if len(keys) > 0 {
+		logger.WithField("count", len(keys)).Info("pending forced withdrawals loaded")
+	}
+
+	return nil
+}
+
+// putForceWithdrawals adds the forced withdrawals changed since last checkpoint into batch,
+// and the completed ones are removed.
+func (am *AuditManager) putForceWithdrawals(batch *common.StoreBatch) error {
+	for key := range am.forceWithdrawalsChanged {
+		withdrawal, ok := am.forceWithdrawals[key]
+		if !ok {
+			batch.Delete(key)
+			continue
+		}
+
+		if err := batch.Put(key, withdrawal); err != nil {
+			return errors.WithMessagef(err, "failed to save forced withdrawal %v", key)
+		}
+	}
+
+	am.forceWithdrawalsChanged = make(map[string]bool)
+
+	return nil
+}
+
+// trackForceWithdrawals tracks the lifecycle of forced withdrawals in CRCL for the specified epoch:
+// alert when scheduled, alert again before the defer window ends, and check the later withdrawal
+// against the scheduled amount.
+func (am *AuditManager) trackForceWithdrawals(epoch *big.Int, logs []types.Log) error {
+	scheduleWithdrawHash := crclEventDecoder().EventHash("ScheduleWithdraw")
+
+	for i := range logs {
+		log := &logs[i]
+		asset, ok := am.crcl2AssetMap[log.Address.GetHexAddress()]
+		if !ok || len(log.Topics) == 0 {
+			continue
+		}
+
+		switch log.Topics[0] {
+		case scheduleWithdrawHash:
+			if err := am.onScheduleWithdraw(asset, epoch, log); err != nil {
+				return errors.WithMessagef(err, "failed to track scheduled withdrawal, asset = %v", asset)
+			}
+		case common.EventHashWithdraw:
+			if err := am.onWithdraw(asset, epoch, log); err != nil {
+				return errors.WithMessagef(err, "failed to track withdrawal, asset = %v", asset)
+			}
+		}
+	}
+
+	am.warnForceWithdrawals(time.Now())
+
+	return nil
+}
+
+func (am *AuditManager) onScheduleWithdraw(asset string, epoch *big.Int, log *types.Log) error {
+	event, err := crclEventDecoder().Decode(log)
+	if err != nil {
+		return errors.WithMessage(err, "failed to decode ScheduleWithdraw event")
+	}
+
+	crcl := am.auditors[asset].crcl
+	account := event.Address("sender")
+
+	deferTime, err := crcl.DeferTime(types.NewEpochNumberBig(epoch))
+	if err != nil {
+		return errors.WithMessage(err, "failed to get defer time")
+	}
+
+	amount, err := crcl.BalanceOf(account, types.NewEpochNumberBig(epoch))
+	if err != nil {
+		return errors.WithMessagef(err, "failed to get balance of account %v", account)
+	}
+
+	scheduledTime := event.BigInt("time").Int64()
+	withdrawal := ForceWithdrawal{
+		Asset:          asset,
+		Account:        account,
+		Epoch:          epoch,
+		TxHash:         event.TxHash(),
+		ScheduledTime:  scheduledTime,
+		ExecutableTime: scheduledTime + deferTime.Int64(),
+		Amount:         amount,
+	}
+
+	key := forceWithdrawKey(asset, account)
+	am.forceWithdrawals[key] = &withdrawal
+	am.forceWithdrawalsChanged[key] = true
+
+	logger.WithFields(logrus.Fields{
+		"epoch":      epoch,
+		"withdrawal": &withdrawal,
+	}).Warn("forced withdrawal scheduled")
+
+	common.Alertf(module, "Forced withdrawal scheduled, epoch = %v, withdrawal = %v", epoch, &withdrawal)
+
+	return nil
+}
+
+func (am *AuditManager) onWithdraw(asset string, epoch *big.Int, log *types.Log) error {
+	sender, recipient, amount := decodeCrclEvent(log)
+
+	key := forceWithdrawKey(asset, sender)
+	withdrawal, ok := am.forceWithdrawals[key]
+	if !ok {
+		return nil
+	}
+
+	logger := logger.WithFields(logrus.Fields{
+		"epoch":      epoch,
+		"withdrawal": withdrawal,
+		"recipient":  recipient,
+		"amount":     amount,
+		"tx":         log.TransactionHash,
+	})
+
+	if amount.Cmp(withdrawal.Amount) == 0 {
+		logger.Info("forced withdrawal completed")
+		common.Notifyf("Forced withdrawal completed, epoch = %v, recipient = %v, tx = %v, withdrawal = %v", epoch, recipient, log.TransactionHash, withdrawal)
+	} else {
+		logger.Error("withdrawal amount mismatch with scheduled forced withdrawal")
+		common.Alertf(module, "Withdrawal amount mismatch with scheduled forced withdrawal, epoch = %v, amount = %v, recipient = %v, tx = %v, withdrawal = %v",
+			epoch, amount, recipient, log.TransactionHash, withdrawal)
+
+		// partial withdrawal via DEX, and forced withdrawal is still pending
+		balance, err := am.auditors[asset].crcl.BalanceOf(sender, types.NewEpochNumberBig(epoch))
+		if err != nil {
+			return errors.WithMessagef(err, "failed to get balance of account %v", sender)
+		}
+
+		if balance.Sign() > 0 {
+			return nil
+		}
+	}
+
+	delete(am.forceWithdrawals, key)
+	am.forceWithdrawalsChanged[key] = true
+
+	return nil
+}
+
+// warnForceWithdrawals alerts for the pending forced withdrawals that will be executable soon.
+func (am *AuditManager) warnForceWithdrawals(now time.Time) {
+	ahead := time.Duration(config.ForceWithdrawAlertAheadSeconds) * time.Second
+
+	for key, withdrawal := range am.forceWithdrawals {
+		if withdrawal.Warned || now.Add(ahead).Before(time.Unix(withdrawal.ExecutableTime, 0)) {
+			continue
+		}
+
+		logger.WithField("withdrawal", withdrawal).Warn("forced withdrawal will be executable soon")
+		common.Alertf(module, "Forced withdrawal will be executable at %v, withdrawal = %v", time.Unix(withdrawal.ExecutableTime, 0), withdrawal)
+
+		withdrawal.Warned = true
+		am.forceWithdrawalsChanged[key] = true
+	}
+}
@@ -0,0 +1,329 @@
+package boomflow
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Conflux-Chain/go-conflux-sdk/types"
+	"github.com/open-dex/conflux-dex-audit/common"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	marketMakerStateKey = "marketMakers"
+
+	// rolling window to limit the total withdrawal amount of market maker
+	marketMakerWithdrawWindow = 24 * time.Hour
+
+	// interval to send the digest of market maker withdrawals that comply with policies
+	marketMakerDigestInterval = 24 * time.Hour
+)
+
+// Severity is the severity of market maker policy violation.
+type Severity string
+
+// Severities of market maker policy violation.
+const (
+	SeverityMedium Severity = "medium"
+	SeverityHigh   Severity = "high"
+)
+
+// MarketMakerPolicy is the withdrawal policy of a market maker configured in config.json.
+type MarketMakerPolicy struct {
+	Limits            map[string]WithdrawLimit `json:"limits"`            // key is asset name
+	AllowedRecipients []string                 `json:"allowedRecipients"` // empty means any recipient allowed
+}
+
+// WithdrawLimit is the withdrawal limit of market maker for an asset. Amounts are decimal
+// strings in the minimum unit of asset, and empty value means unlimited.
+type WithdrawLimit struct {
+	Single string `json:"single"` // max amount of a single withdrawal
+	Daily  string `json:"daily"`  // max total amount of withdrawals in rolling 24 hours
+}
+
+func parseLimit(limit string) (*big.Int, error) {
+	if len(limit) == 0 {
+		return nil, nil
+	}
+
+	amount, ok := new(big.Int).SetString(limit, 10)
+	if !ok || amount.Sign() < 0 {
+		return nil, fmt.Errorf("invalid withdraw limit %v", limit)
+	}
+
+	return amount, nil
+}
+
+// validate checks the limits and recipients in policy.
+func (policy *MarketMakerPolicy) validate() error {
+	for asset, limit := range policy.Limits {
+		if _, err := parseLimit(limit.Single); err != nil {
+			return errors.WithMessagef(err, "invalid single withdraw limit for asset %v", asset)
+		}
+
+		if _, err := parseLimit(limit.Daily); err != nil {
+			return errors.WithMessagef(err, "invalid daily withdraw limit for asset %v", asset)
+		}
+	}
+
+	for _, recipient := range policy.AllowedRecipients {
+		if !strings.HasPrefix(recipient, "0x") || len(recipient) != len(common.ZeroAddress) {
+			return fmt.Errorf("invalid allowed recipient %v", recipient)
+		}
+	}
+
+	return nil
+}
+
+// MarketMakerWithdrawal represents a withdrawal of market maker in CRCL.
+type MarketMakerWithdrawal struct {
+	MarketMaker string   `json:"marketMaker"`
+	Asset       string   `json:"asset"`
+	Recipient   string   `json:"recipient"`
+	Amount      *big.Int `json:"amount"`
+	Epoch       *big.Int `json:"epoch"`
+	Time        int64    `json:"time"` // unix timestamp of pivot block
+	TxHash      string   `json:"txHash"`
+}
+
+// String implements the fmt.Stringer interface.
+func (w *MarketMakerWithdrawal) String() string {
+	return fmt.Sprintf("{marketMaker = %v, asset = %v, recipient = %v, amount = %v, epoch = %v, time = %v, tx = %v}",
+		w.MarketMaker, w.Asset, w.Recipient, w.Amount, w.Epoch, time.Unix(w.Time, 0), w.TxHash)
+}
+
+// withdrawDigest summarizes the withdrawals of market maker for an asset that comply with policy.
+type withdrawDigest struct {
+	Count  int      `json:"count"`
+	Amount *big.Int `json:"amount"`
+}
+
+// marketMakerMonitor enforces the withdrawal policies of market makers from the CRCL event logs.
+// Withdrawals that comply with policies are summarized in a daily digest, and policy violations
+// are alerted with severity.
+type marketMakerMonitor struct {
+	Epoch       *big.Int                   `json:"epoch"`       // last monitored epoch
+	Withdrawals []MarketMakerWithdrawal    `json:"withdrawals"` // withdrawals in rolling window
+	DigestSince time.Time                  `json:"digestSince"`
+	Digest      map[string]*withdrawDigest `json:"digest"` // key is market maker and asset
+
+	changed bool // changed since last checkpoint
+}
+
+func newMarketMakerMonitor() *marketMakerMonitor {
+	return &marketMakerMonitor{
+		DigestSince: time.Now(),
+		Digest:      make(map[string]*withdrawDigest),
+	}
+}
+
+// getMarketMakerPolicy returns the withdrawal policy of specified account, and false if the
+// account is not a market maker. Market makers configured without policy have no limits.
+func getMarketMakerPolicy(account string) (*MarketMakerPolicy, bool) {
+	if policy, ok := config.MarketMakerPolicies[account]; ok {
+		return &policy, true
+	}
+
+	if config.MarketMakers[account] {
+		return &MarketMakerPolicy{}, true
+	}
+
+	return nil, false
+}
+
+// loadMarketMakerMonitor loads the state of market maker monitor from checkpoint store.
+func (am *AuditManager) loadMarketMakerMonitor() error {
+	monitor := newMarketMakerMonitor()
+
+	if _, err := am.store.Get(marketMakerStateKey, monitor); err != nil {
+		return errors.WithMessage(err, "failed to load market maker monitor")
+	}
+
+	if monitor.Digest == nil {
+		monitor.Digest = make(map[string]*withdrawDigest)
+	}
+
+	am.marketMakers = monitor
+
+	return nil
+}
+
+// putMarketMakerMonitor adds the state of market maker monitor into batch if changed.
+func (am *AuditManager) putMarketMakerMonitor(batch *common.StoreBatch) error {
+	if !am.marketMakers.changed {
+		return nil
+	}
+
+	if err := batch.Put(marketMakerStateKey, am.marketMakers); err != nil {
+		return errors.WithMessage(err, "failed to save market maker monitor")
+	}
+
+	am.marketMakers.changed = false
+
+	return nil
+}
+
+// monitorMarketMakers checks the withdrawals of market makers in the specified epoch against
+// policies, and sends the digest of withdrawals periodically. Epochs already monitored are
+// skipped, so that an epoch could be audited again.
+func (am *AuditManager) monitorMarketMakers(epoch *big.Int, logs []types.Log) error {
+	monitor := am.marketMakers
+
+	if monitor.Epoch == nil || monitor.Epoch.Cmp(epoch) < 0 {
+		var withdrawals []MarketMakerWithdrawal
+		for i := range logs {
+			log := &logs[i]
+			if len(log.Topics) == 0 || log.Topics[0] != common.EventHashWithdraw {
+				continue
+			}
+
+			asset, ok := am.crcl2AssetMap[log.Address.GetHexAddress()]
+			if !ok {
+				continue
+			}
+
+			sender, recipient, amount := decodeCrclEvent(log)
+			if _, ok = getMarketMakerPolicy(sender); !ok {
+				continue
+			}
+
+			withdrawals = append(withdrawals, MarketMakerWithdrawal{
+				MarketMaker: sender,
+				Asset:       asset,
+				Recipient:   recipient,
+				Amount:      amount,
+				Epoch:       epoch,
+				TxHash:      log.TransactionHash.String(),
+			})
+		}
+
+		if len(withdrawals) > 0 {
+			block, err := am.Cfx.GetBlockSummaryByEpoch(types.NewEpochNumberBig(epoch))
+			if err != nil {
+				return errors.WithMessage(err, "failed to get pivot block")
+			}
+
+			for _, withdrawal := range withdrawals {
+				withdrawal.Time = block.Timestamp.ToInt().Int64()
+				monitor.check(&withdrawal)
+			}
+		}
+
+		monitor.Epoch = epoch
+		monitor.changed = true
+	}
+
+	monitor.sendDigest(time.Now())
+
+	return nil
+}
+
+// check checks the withdrawal against the policy of market maker.
+func (monitor *marketMakerMonitor) check(withdrawal *MarketMakerWithdrawal) {
+	policy, _ := getMarketMakerPolicy(withdrawal.MarketMaker)
+	limit := policy.Limits[withdrawal.Asset]
+
+	// limits are validated when config loaded
+	single, _ := parseLimit(limit.Single)
+	daily, _ := parseLimit(limit.Daily)
+
+	// remove withdrawals out of rolling window
+	windowStart := withdrawal.Time - int64(marketMakerWithdrawWindow/time.Second)
+	var inWindow []MarketMakerWithdrawal
+	for _, w := range monitor.Withdrawals {
+		if w.Time > windowStart {
+			inWindow = append(inWindow, w)
+		}
+	}
+	monitor.Withdrawals = append(inWindow, *withdrawal)
+
+	violated := false
+
+	if len(policy.AllowedRecipients) > 0 && !containsString(policy.AllowedRecipients, withdrawal.Recipient) {
+		monitor.alert(SeverityHigh, withdrawal, "recipient not allowed")
+		violated = true
+	}
+
+	if single != nil && withdrawal.Amount.Cmp(single) > 0 {
+		monitor.alert(SeverityMedium, withdrawal, fmt.Sprintf("single withdrawal limit %v exceeded", single))
+		violated = true
+	}
+
+	if daily != nil {
+		total := big.NewInt(0)
+		for _, w := range monitor.Withdrawals {
+			if w.MarketMaker == withdrawal.MarketMaker && w.Asset == withdrawal.Asset {
+				total.Add(total, w.Amount)
+			}
+		}
+
+		if total.Cmp(daily) > 0 {
+			monitor.alert(SeverityHigh, withdrawal, fmt.Sprintf("rolling 24h withdrawal limit %v exceeded, total = %v", daily, total))
+			violated = true
+		}
+	}
+
+	if violated {
+		return
+	}
+
+	logger.WithField("withdrawal", withdrawal).Debug("market maker withdrawal complies with policy")
+
+	key := withdrawal.MarketMaker + "/" + withdrawal.Asset
+	digest, ok := monitor.Digest[key]
+	if !ok {
+		digest = &withdrawDigest{Amount: big.NewInt(0)}
+		monitor.Digest[key] = digest
+	}
+
+	digest.Count++
+	digest.Amount = new(big.Int).Add(digest.Amount, withdrawal.Amount)
+}
+
+func (monitor *marketMakerMonitor) alert(severity Severity, withdrawal *MarketMakerWithdrawal, reason string) {
+	logger.WithFields(logrus.Fields{
+		"severity":   severity,
+		"reason":     reason,
+		"withdrawal": withdrawal,
+	}).Warn("market maker withdrawal violates policy")
+
+	message := fmt.Sprintf("Market maker withdrawal violates policy, severity = %v, reason = %v, withdrawal = %v", severity, reason, withdrawal)
+
+	if severity == SeverityHigh {
+		common.Critical(module, message)
+	} else {
+		common.Alert(module, message)
+	}
+}
+
+// sendDigest sends the digest of market maker withdrawals that comply with policies once per day.
+func (monitor *marketMakerMonitor) sendDigest(now time.Time) {
+	if now.Sub(monitor.DigestSince) < marketMakerDigestInterval {
+		return
+	}
+
+	if len(monitor.Digest) > 0 {
+		var keys []string
+		for key := range monitor.Digest {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		var lines []string
+		for _, key := range keys {
+			digest := monitor.Digest[key]
+			lines = append(lines, fmt.Sprintf("%v: count = %v, amount = %v", key, digest.Count, digest.Amount))
+		}
+
+		logger.WithField("digest", lines).Info("market maker withdrawal digest")
+		common.Notifyf("Market maker withdrawals since %v:\n%v", monitor.DigestSince.Format(time.RFC3339), strings.Join(lines, "\n"))
+	}
+
+	monitor.DigestSince = now
+	monitor.Digest = make(map[string]*withdrawDigest)
+	monitor.changed = true
+}
@@ -0,0 +1,156 @@
+package boomflow
+
+import (
+	"fmt"
+	"math/big"
+
+	ethCommon "github.com/ethereum/go-ethereum/common"
+	"github.com/open-dex/conflux-dex-audit/common"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	merkleRootPrefix = "root/"
+
+	// merkle roots are recorded periodically instead of every epoch, since building
+	// merkle tree requires to hash all accounts
+	merkleRootIntervalEpochs = 100
+)
+
+// MerkleRootRecord represents the merkle root of audited account balances for an asset,
+// which is recorded at baseline epoch and every merkleRootIntervalEpochs epochs if account
+// balances changed since last record.
+type MerkleRootRecord struct {
+	Asset       string         `json:"asset"`
+	Epoch       *big.Int       `json:"epoch"`
+	Root        ethCommon.Hash `json:"root"`
+	TotalSupply *big.Int       `json:"totalSupply"`
+}
+
+func merkleRootKey(asset string, epoch *big.Int) string {
+	return fmt.Sprintf("%v%v/%020d", merkleRootPrefix, asset, epoch)
+}
+
+// merkleRootsToRecord marks the specified assets as changed, and returns all changed assets
+// since last record if merkle roots should be recorded for the last audited epoch.
+func (am *AuditManager) merkleRootsToRecord(changedAssets []string) []string {
+	for _, asset := range changedAssets {
+		am.rootsChanged[asset] = true
+	}
+
+	if new(big.Int).Mod(am.lastAuditEpoch, big.NewInt(merkleRootIntervalEpochs)).Sign() != 0 {
+		return nil
+	}
+
+	var assets []string
+	for asset := range am.rootsChanged {
+		assets = append(assets, asset)
+	}
+
+	return assets
+}
+
+// updateMerkleRoots calculates the merkle root of account balances for specified assets.
+func (am *AuditManager) updateMerkleRoots(assets []string) error {
+	for _, asset := range assets {
+		details := am.lastAuditDetails[asset]
+		tree, err := common.NewMerkleTree(details.AccountBalances)
+		if err != nil {
+			return errors.WithMessagef(err, "failed to build merkle tree of asset %v", asset)
+		}
+		details.MerkleRoot = tree.Root()
+
+		logger.WithFields(logrus.Fields{
+			"asset":    asset,
+			"epoch":    am.lastAuditEpoch,
+			"root":     details.MerkleRoot.Hex(),
+			"accounts": tree.Size(),
+		}).Debug("merkle root of account balances")
+	}
+
+	return nil
+}
+
+// putMerkleRoots adds merkle root records of specified assets for the last audited epoch into batch.
+// Records after the last audited epoch are deleted, since they are from an abandoned pivot chain
+// or replaced by a new baseline.
+func (am *AuditManager) putMerkleRoots(batch *common.StoreBatch, assets []string) error {
+	for _, asset := range assets {
+		key := merkleRootKey(asset, am.lastAuditEpoch)
+
+		stale, err := am.store.KeysAfter(merkleRootPrefix+asset+"/", key)
+		if err != nil {
+			return errors.WithMessagef(err, "failed to get stale merkle roots of asset %v", asset)
+		}
+
+		for _, staleKey := range stale {
+			batch.Delete(staleKey)
+		}
+
+		if len(stale) > 0 {
+			logger.WithFields(logrus.Fields{
+				"asset": asset,
+				"epoch": am.lastAuditEpoch,
+				"count": len(stale),
+			}).Info("delete stale merkle roots")
+		}
+
+		details := am.lastAuditDetails[asset]
+		record := MerkleRootRecord{
+			Asset:       asset,
+			Epoch:       am.lastAuditEpoch,
+			Root:        details.MerkleRoot,
+			TotalSupply: details.TotalSupply,
+		}
+
+		if err := batch.Put(key, &record); err != nil {
+			return errors.WithMessagef(err, "failed to save merkle root of asset %v", asset)
+		}
+	}
+
+	return nil
+}
+
+// GetMerkleRoot returns the latest recorded merkle root of asset until specified epoch. Note,
+// the record may be earlier than the specified epoch, and account balances may have changed
+// since then. Returns nil if checkpoint not opened or no merkle root recorded until the
+// specified epoch.
+func (am *AuditManager) GetMerkleRoot(asset string, epoch *big.Int) (*MerkleRootRecord, error) {
+	if am.store == nil {
+		return nil, nil
+	}
+
+	var record MerkleRootRecord
+	_, found, err := am.store.Floor(merkleRootPrefix+asset+"/", merkleRootKey(asset, epoch), &record)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to get merkle root of asset %v", asset)
+	}
+
+	if !found {
+		return nil, nil
+	}
+
+	return &record, nil
+}
+
+// ProveAccountBalance audits balance for specified asset and epoch, and returns the merkle
+// inclusion proof of specified account.
+func (am *AuditManager) ProveAccountBalance(asset, account string, epoch *big.Int) (*common.MerkleProof, error) {
+	details, err := am.AuditBalanceForAsset(asset, epoch)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to audit balance")
+	}
+
+	tree, err := common.NewMerkleTree(details.AccountBalances)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to build merkle tree")
+	}
+
+	proof, err := tree.Proof(account)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to build merkle proof")
+	}
+
+	return proof, nil
+}
@@ -0,0 +1,26 @@
+package boomflow
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestMerkleRootsToRecord(t *testing.T) {
+	am := AuditManager{rootsChanged: make(map[string]bool)}
+
+	am.lastAuditEpoch = big.NewInt(merkleRootIntervalEpochs + 1)
+	if roots := am.merkleRootsToRecord([]string{"CFX"}); len(roots) != 0 {
+		t.Fatalf("roots = %v", roots)
+	}
+
+	am.lastAuditEpoch = big.NewInt(merkleRootIntervalEpochs + 2)
+	if roots := am.merkleRootsToRecord([]string{"ETH"}); len(roots) != 0 {
+		t.Fatalf("roots = %v", roots)
+	}
+
+	// record all assets changed since last record
+	am.lastAuditEpoch = big.NewInt(2 * merkleRootIntervalEpochs)
+	if roots := am.merkleRootsToRecord(nil); len(roots) != 2 {
+		t.Fatalf("roots = %v", roots)
+	}
+}
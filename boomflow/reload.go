@@ -0,0 +1,240 @@
+package boomflow
+
+import (
+	"os"
+	"time"
+
+	"github.com/open-dex/conflux-dex-audit/common"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ReloadInterval is the interval to poll new assets in matchflow and check changes of config file.
+var ReloadInterval = time.Minute
+
+// maxPendingRetryBackoff is the maximum interval to retry a pending asset that failed to audit balance.
+const maxPendingRetryBackoff = time.Hour
+
+// pendingRetry is the retry state of a pending asset that failed to audit balance as baseline.
+type pendingRetry struct {
+	next    time.Time     // earliest time to retry
+	backoff time.Duration // doubled on each failure
+	parked  bool          // failed with permanent error, retry until config changed
+	alerted bool          // alerted for non-transient error
+}
+
+// fail updates the retry state with the failure, and returns true if it should be alerted.
+func (r *pendingRetry) fail(err error) bool {
+	if r.backoff *= 2; r.backoff == 0 {
+		r.backoff = ReloadInterval
+	}
+
+	if r.backoff > maxPendingRetryBackoff {
+		r.backoff = maxPendingRetryBackoff
+	}
+
+	r.next = time.Now().Add(r.backoff)
+	r.parked = common.IsPermanent(err)
+
+	if common.IsTransient(err) || r.alerted {
+		return false
+	}
+
+	r.alerted = true
+
+	return true
+}
+
+// Reload polls new assets in matchflow and reloads the config file if changed once ReloadInterval
+// elapsed, and then audits balance for the new assets as baseline at the last audited epoch, so
+// that their event logs will be audited along with existing assets since the next epoch.
+//
+// Note, it should be called at epoch boundary in the audit goroutine.
+func (am *AuditManager) Reload() error {
+	if time.Since(am.lastReload) < ReloadInterval {
+		return nil
+	}
+
+	am.lastReload = time.Now()
+
+	am.reloadConfig()
+	am.pollAssets()
+
+	return am.addPendingAssets()
+}
+
+// reloadConfig reloads the config file if modified, and applies it to the existing assets.
+func (am *AuditManager) reloadConfig() {
+	if len(configPath) == 0 {
+		return
+	}
+
+	info, err := os.Stat(configPath)
+	if err != nil {
+		logger.WithError(err).WithField("path", configPath).Warn("failed to check config file")
+		return
+	}
+
+	if !info.ModTime().After(am.configModTime) {
+		return
+	}
+
+	loaded, err := loadConfig(configPath)
+	if err != nil {
+		logger.WithError(err).WithField("path", configPath).Error("failed to reload config")
+		common.Alertf(module, "failed to reload config: %v", err.Error())
+		return
+	}
+
+	am.configModTime = info.ModTime()
+
+	// account source could not be changed without restart
+	if loaded.AccountSource != config.AccountSource || loaded.IndexerPath != config.IndexerPath {
+		logger.WithFields(logrus.Fields{
+			"accountSource": loaded.AccountSource,
+			"indexerPath":   loaded.IndexerPath,
+		}).Warn("account source changed, restart required to take effect")
+
+		loaded.AccountSource = config.AccountSource
+		loaded.IndexerPath = config.IndexerPath
+	}
+
+	boomflowChanged := loaded.BoomflowAddress != config.BoomflowAddress
+
+	config = *loaded
+
+	// retry the pending assets with new config
+	am.pendingRetries = make(map[string]*pendingRetry)
+
+	for name, auditor := range am.auditors {
+		auditor.balanceByTransfer = config.getBalanceByTransfer(name)
+	}
+
+	if boomflowChanged {
+		am.fillAuditor = nil
+		if len(config.BoomflowAddress) > 0 {
+			am.fillAuditor = NewFillAuditor(am.Cfx, config.BoomflowAddress)
+		}
+	}
+
+	logger.WithField("path", configPath).Info("config reloaded")
+	common.Notifyf("Boomflow audit config reloaded")
+}
+
+// pollAssets polls assets in matchflow, and adds the new ones as pending assets.
+func (am *AuditManager) pollAssets() {
+	if len(am.matchflowURL) == 0 {
+		return
+	}
+
+	assets, err := common.NewClient(am.matchflowURL).ListAssets()
+	if err != nil {
+		logger.WithError(err).Warn("failed to poll assets in matchflow")
+		return
+	}
+
+	for _, asset := range assets {
+		if _, ok := am.auditors[asset.Name]; ok {
+			continue
+		}
+
+		if _, ok := am.pendingAssets[asset.Name]; ok {
+			continue
+		}
+
+		logger.WithField("asset", asset.Name).Info("new asset found")
+		am.pendingAssets[asset.Name] = asset
+	}
+}
+
+// addPendingAssets audits balance for pending assets as baseline at the last audited epoch, and
+// adds them to audit event logs since the next epoch. Pending assets that failed to audit balance
+// will be retried later with exponential backoff, or after config changed for permanent errors,
+// without any impact on the existing assets. Non-transient errors are alerted once per asset.
+func (am *AuditManager) addPendingAssets() error {
+	if len(am.pendingAssets) == 0 {
+		return nil
+	}
+
+	var added []string
+	changed := false
+	for name, asset := range am.pendingAssets {
+		retry := am.pendingRetries[name]
+		if retry != nil && (retry.parked || time.Now().Before(retry.next)) {
+			continue
+		}
+
+		auditor := am.newAssetAuditor(asset)
+
+		if am.store != nil {
+			if err := am.loadStrandedBalance(auditor); err != nil {
+				return err
+			}
+		}
+
+		// audit balance along with existing assets in the next balance audit
+		if am.lastAuditEpoch == nil {
+			am.addAssetAuditor(auditor)
+			delete(am.pendingAssets, name)
+			changed = true
+			continue
+		}
+
+		details, err := auditor.AuditBalance(am.lastAuditEpoch)
+		if err != nil {
+			if retry == nil {
+				retry = &pendingRetry{}
+				am.pendingRetries[name] = retry
+			}
+
+			alert := retry.fail(err)
+
+			logger.WithError(err).WithFields(logrus.Fields{
+				"asset":   name,
+				"epoch":   am.lastAuditEpoch,
+				"backoff": retry.backoff,
+				"parked":  retry.parked,
+			}).Warn("failed to audit balance for new asset, retry later")
+
+			if alert {
+				common.Alertf(module, "failed to audit balance for new asset %v: %v", name, err.Error())
+			}
+
+			continue
+		}
+
+		am.addAssetAuditor(auditor)
+		am.lastAuditDetails[name] = details
+		am.accountsChanged[name] = nil
+		delete(am.pendingAssets, name)
+		delete(am.pendingRetries, name)
+		added = append(added, name)
+		changed = true
+
+		logger.WithFields(logrus.Fields{
+			"asset": name,
+			"epoch": am.lastAuditEpoch,
+		}).Info("new asset added")
+
+		common.Notifyf("New asset %v added to Boomflow audit, baseline epoch = %v", name, am.lastAuditEpoch)
+	}
+
+	if !changed {
+		return nil
+	}
+
+	am.logFetcher.SetAddresses(am.pollLogAddresses)
+	if len(added) == 0 {
+		return nil
+	}
+
+	if err := am.updateMerkleRoots(added); err != nil {
+		return err
+	}
+
+	if err := am.saveCheckpoint(added); err != nil {
+		return errors.WithMessage(err, "failed to save checkpoint for new assets")
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package boomflow
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/open-dex/conflux-dex-audit/common"
+)
+
+func TestPendingRetry(t *testing.T) {
+	var retry pendingRetry
+
+	if retry.fail(errors.New("rpc timeout")) || retry.backoff != ReloadInterval || retry.parked {
+		t.Fatalf("transient error: %+v", retry)
+	}
+
+	// alert once for non-transient errors
+	if !retry.fail(common.InconsistencyErrorf("inconsistent")) || retry.backoff != 2*ReloadInterval {
+		t.Fatalf("first inconsistency: %+v", retry)
+	}
+
+	if retry.fail(common.InconsistencyErrorf("inconsistent")) || retry.parked {
+		t.Fatalf("second inconsistency: %+v", retry)
+	}
+
+	if retry.fail(common.PermanentErrorf("not configured")) || !retry.parked {
+		t.Fatalf("permanent error: %+v", retry)
+	}
+
+	for i := 0; i < 10; i++ {
+		retry.fail(errors.New("rpc timeout"))
+	}
+
+	if retry.backoff != maxPendingRetryBackoff {
+		t.Fatalf("backoff = %v", retry.backoff)
+	}
+}
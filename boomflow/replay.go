@@ -0,0 +1,162 @@
+package boomflow
+
+import (
+	"encoding/json"
+	"io"
+	"math/big"
+	"sort"
+
+	"github.com/Conflux-Chain/go-conflux-sdk/types"
+	"github.com/open-dex/conflux-dex-audit/common"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ReplayChange represents the balance change of an account in replayed epoch.
+type ReplayChange struct {
+	Asset      string   `json:"asset"`
+	Account    string   `json:"account"`
+	OldBalance *big.Int `json:"oldBalance"`
+	NewBalance *big.Int `json:"newBalance"` // replayed balance
+	OnChain    *big.Int `json:"onChain"`    // balance in CRCL on chain
+	Matched    bool     `json:"matched"`
+	TxHashes   []string `json:"txHashes"` // transactions that changed the account balance
+}
+
+// ReplayEpoch represents the replay result of an epoch.
+type ReplayEpoch struct {
+	Epoch       *big.Int       `json:"epoch"`
+	Changes     []ReplayChange `json:"changes"`
+	Annotations []Annotation   `json:"annotations,omitempty"` // balance-neutral events
+	Matched     bool           `json:"matched"`               // whether all changed balances matched on chain
+}
+
+// Replay replays the CRCL event logs epoch by epoch from the account balances at epochFrom as
+// baseline until epochTo, and writes the replay result of each epoch as a JSON line. Unlike
+// audit, replay continues if any replayed balance mismatched on chain, and the account balance
+// is corrected to the on chain one, so that each epoch only reflects its own differences.
+func (am *AuditManager) Replay(epochFrom, epochTo *big.Int, w io.Writer) error {
+	baseline := make(map[string]*common.AccountBalances)
+	for asset, auditor := range am.auditors {
+		details, err := auditor.AuditAccountBalance(epochFrom)
+		if err != nil {
+			return errors.WithMessagef(err, "failed to get account balances for asset %v", asset)
+		}
+
+		baseline[asset] = details.AccountBalances
+	}
+
+	logger.WithFields(logrus.Fields{
+		"from": epochFrom,
+		"to":   epochTo,
+	}).Debug("begin to replay event logs")
+
+	encoder := json.NewEncoder(w)
+
+	for epoch := new(big.Int).Add(epochFrom, common.Big1); epoch.Cmp(epochTo) <= 0; epoch = new(big.Int).Add(epoch, common.Big1) {
+		result, err := am.replayEpoch(epoch, baseline)
+		if err != nil {
+			return errors.WithMessagef(err, "failed to replay epoch %v", epoch)
+		}
+
+		if err = encoder.Encode(result); err != nil {
+			return errors.WithMessage(err, "failed to write replay result")
+		}
+
+		if !result.Matched {
+			logger.WithField("epoch", epoch).Warn("replayed balances mismatch on chain")
+		}
+	}
+
+	return nil
+}
+
+func (am *AuditManager) replayEpoch(epoch *big.Int, baseline map[string]*common.AccountBalances) (*ReplayEpoch, error) {
+	logs, err := am.logFetcher.GetLogs(epoch)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to poll event logs")
+	}
+
+	allDetails, err := auditLogs(logs)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to audit event logs")
+	}
+
+	// transactions that changed the account balance, key is asset and account
+	txHashes := make(map[string][]string)
+	for i := range logs {
+		log := &logs[i]
+		asset, ok := am.crcl2AssetMap[log.Address.GetHexAddress()]
+		if !ok || len(log.Topics) == 0 || log.Topics[0] != common.EventHashTransfer {
+			continue
+		}
+
+		sender, recipient, _ := decodeCrclEvent(log)
+		for _, account := range []string{sender, recipient} {
+			key := asset + "/" + account
+			if txHash := log.TransactionHash.String(); !containsString(txHashes[key], txHash) {
+				txHashes[key] = append(txHashes[key], txHash)
+			}
+		}
+	}
+
+	result := ReplayEpoch{
+		Epoch:   epoch,
+		Matched: true,
+	}
+
+	var crcls []string
+	for crcl := range allDetails {
+		crcls = append(crcls, crcl)
+	}
+	sort.Strings(crcls)
+
+	for _, crcl := range crcls {
+		details := allDetails[crcl]
+		asset, ok := am.crcl2AssetMap[crcl]
+		if !ok {
+			return nil, common.InconsistencyErrorf("cannot find asset for CRCL address %v", crcl)
+		}
+
+		result.Annotations = append(result.Annotations, details.Annotations...)
+
+		changed := details.merge(baseline[asset])
+
+		var accounts []string
+		for account := range changed {
+			accounts = append(accounts, account)
+		}
+		sort.Strings(accounts)
+
+		for _, account := range accounts {
+			balance := changed[account]
+
+			onChain, err := am.auditors[asset].crcl.BalanceOf(account, types.NewEpochNumberBig(epoch))
+			if err != nil {
+				return nil, errors.WithMessagef(err, "failed to get balance on chain, asset = %v, account = %v", asset, account)
+			}
+
+			oldBalance := new(big.Int).Sub(balance, details.BalanceIncreased.Get(account))
+			oldBalance.Add(oldBalance, details.BalanceReduced.Get(account))
+
+			change := ReplayChange{
+				Asset:      asset,
+				Account:    account,
+				OldBalance: oldBalance,
+				NewBalance: balance,
+				OnChain:    onChain,
+				Matched:    balance.Cmp(onChain) == 0,
+				TxHashes:   txHashes[asset+"/"+account],
+			}
+
+			if !change.Matched {
+				result.Matched = false
+				baseline[asset].Add(account, new(big.Int).Sub(onChain, balance))
+			}
+
+			result.Changes = append(result.Changes, change)
+		}
+	}
+
+	return &result, nil
+}
@@ -5,8 +5,6 @@ import (
 	"sync"
 	"time"
 
-	sdk "github.com/Conflux-Chain/go-conflux-sdk"
-	"github.com/Conflux-Chain/go-conflux-sdk/types"
 	"github.com/open-dex/conflux-dex-audit/common"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -23,93 +21,174 @@ var logger = common.NewLogger(module)
 //
 // Moreover, the service will audit the account balances periodically, base on which
 // to audit event logs epoch by epoch.
-func StartSince(cfxURL, matchflowURL string, epochSince *big.Int, numEpochsToAuditBalances uint64, wg *sync.WaitGroup) {
+//
+// New assets listed in matchflow and changes of config file are applied without restart,
+// see AuditManager.Reload for details.
+//
+// If checkpoint is configured, the audit state is saved after each audited epoch, and
+// the service resumes from the last verified epoch instead of a new baseline on startup.
+//
+// The service never stops on failure. Transient errors, e.g. RPC error, are retried with
+// backoff. If any inconsistency or permanent error, e.g. undecodable event log, found, the
+// failed epoch is marked, and the service continues with a new baseline at the next epoch.
+// Use GetStatus to check the current state, which is also notified periodically.
+func StartSince(cfxURL, matchflowURL string, epochSince *big.Int, numEpochsToAuditBalances uint64, config *common.BoomflowConfig, wg *sync.WaitGroup) {
 	if numEpochsToAuditBalances == 0 {
 		logger.Fatal("numEpochsToAuditBalances is zero")
 	}
 
+	if StatusInterval > 0 {
+		go notifyStatus(StatusInterval)
+	}
+
 	wg.Add(1)
-	go audit(cfxURL, matchflowURL, epochSince, numEpochsToAuditBalances, wg)
+	go audit(cfxURL, matchflowURL, epochSince, numEpochsToAuditBalances, config, wg)
 }
 
-func audit(cfxURL, matchflowURL string, epoch *big.Int, numEpochsToAuditBalances uint64, wg *sync.WaitGroup) {
+func audit(cfxURL, matchflowURL string, epoch *big.Int, numEpochsToAuditBalances uint64, config *common.BoomflowConfig, wg *sync.WaitGroup) {
 	defer wg.Done()
 
+	if len(config.ConfigPath) > 0 {
+		if err := LoadConfig(config.ConfigPath); err != nil {
+			logger.WithError(err).WithField("path", config.ConfigPath).Fatal("failed to load config")
+		}
+	}
+
 	am := NewAuditManager(cfxURL, matchflowURL)
 	defer am.Close()
 
+	resumed := false
+	if len(config.CheckpointPath) > 0 {
+		if err := am.OpenCheckpoint(config.CheckpointPath, config.Reset); err != nil {
+			logger.WithError(err).Fatal("failed to open checkpoint")
+		}
+
+		if config.Resume {
+			var err error
+			if resumed, err = am.LoadCheckpoint(); err != nil {
+				logger.WithError(err).Fatal("failed to resume from checkpoint")
+			}
+		}
+	}
+
 	logger.WithFields(logrus.Fields{
 		"epoch":                    epoch,
 		"numEpochsToAuditBalances": numEpochsToAuditBalances,
+		"resumed":                  resumed,
 	}).Debug("start to audit Boomflow")
 
 	delta := new(big.Int).SetUint64(numEpochsToAuditBalances - 1)
 	epochFrom := epoch
 
+	// continue the round of checkpoint without balance audit
+	if resumed {
+		epochFrom = am.baselineEpoch
+		logger.WithFields(logrus.Fields{
+			"epochBaseline":  am.baselineEpoch,
+			"epochLastAudit": am.lastAuditEpoch,
+		}).Info("resume from checkpoint")
+	}
+
+	baseline := !resumed
+	backoff := common.MinRetryBackoff
+
 	for {
 		epochTo := new(big.Int).Add(epochFrom, delta)
 
 		logger.WithFields(logrus.Fields{
 			"epochFrom": epochFrom,
 			"epochTo":   epochTo,
+			"baseline":  baseline,
+			"state":     GetStatus().State,
 		}).Info("new round begin")
 
-		if err := auditRound(am, epochFrom, epochTo); err != nil {
-			logger.WithError(err).Error("failed to audit Boomflow")
-			common.Alert(module, err.Error())
-			break
+		err := auditRound(am, epochFrom, epochTo, baseline)
+		if err == nil {
+			// round completed without any failure
+			setState(StateHealthy, nil)
+
+			backoff = common.MinRetryBackoff
+			baseline = true
+			epochFrom = new(big.Int).Add(epochTo, common.Big1)
+			continue
 		}
 
-		epochFrom = new(big.Int).Add(epochTo, common.Big1)
-	}
+		// baseline not audited for this round yet if failed to audit balance
+		baselineFailed := baseline && (am.baselineEpoch == nil || am.baselineEpoch.Cmp(epochFrom) != 0)
+
+		if common.IsTransient(err) {
+			setState(StateRetrying, err)
+			current := GetStatus()
+
+			logger.WithError(err).WithFields(logrus.Fields{
+				"retries": current.Retries,
+				"backoff": backoff,
+			}).Warn("failed to audit Boomflow due to transient error, retry later")
 
-	// TODO notify other auditors;
-	// common.AlertMatchflow()
+			if current.Retries == common.MaxRetriesToAlert {
+				common.Alertf(module, "audit retried %v times due to transient error: %v", current.Retries, err.Error())
+			}
+
+			time.Sleep(backoff)
+			backoff = common.NextBackoff(backoff)
+
+			// continue the round, and audit balance again only if baseline failed
+			baseline = baselineFailed
+			continue
+		}
+
+		// inconsistency or permanent error found, mark the epoch failed and audit balance as new baseline
+		failedEpoch := common.ErrorEpoch(err)
+		if failedEpoch == nil {
+			failedEpoch = new(big.Int).Add(am.lastAuditEpoch, common.Big1)
+		}
+
+		logger.WithError(err).WithField("epoch", failedEpoch).Error("failed to audit Boomflow")
+		common.Alert(module, err.Error())
+		am.markEpochFailed(failedEpoch, err)
+
+		// re-baseline at the next epoch, so that no epoch is skipped without audit
+		epochFrom = new(big.Int).Add(failedEpoch, common.Big1)
+
+		backoff = common.MinRetryBackoff
+		baseline = true
+	}
 }
 
-func auditRound(am *AuditManager, epochFrom, epochTo *big.Int) error {
+func auditRound(am *AuditManager, epochFrom, epochTo *big.Int, baseline bool) error {
 	// audit balance as baseline
-	logger.WithField("epoch", epochFrom).Debug("begin to audit balances")
-	waitForEpochConfirmed(am.Cfx, epochFrom)
-	if err := am.AuditBalance(epochFrom); err != nil {
-		return errors.WithMessagef(err, "failed to audit balances for epoch %v", epochFrom)
-	}
+	if baseline {
+		logger.WithField("epoch", epochFrom).Debug("begin to audit balances")
+		common.WaitForEpochConfirmed(am.Cfx, epochFrom, logger)
+		if err := am.AuditBalance(epochFrom); err != nil {
+			return common.WithEpoch(errors.WithMessagef(err, "failed to audit balances for epoch %v", epochFrom), epochFrom)
+		}
 
-	logger.WithField("epoch", epochFrom).Info("succeed to audit balance and continue to audit event logs")
+		logger.WithField("epoch", epochFrom).Info("succeed to audit balance and continue to audit event logs")
+		recoverFromRetrying()
+	}
 
 	// audit event logs epoch by epoch
 	for am.lastAuditEpoch.Cmp(epochTo) < 0 {
-		logger.WithField("epochBasedOn", am.lastAuditEpoch).Trace("begin to audit event logs")
-		waitForEpochConfirmed(am.Cfx, am.lastAuditEpoch)
-		if err := am.AuditNextEpoch(); err != nil {
-			return errors.WithMessagef(err, "failed to audit event logs, epochBasedOn = %v", am.lastAuditEpoch)
+		// new assets audited at the last audited epoch
+		if err := am.Reload(); err != nil {
+			return common.WithEpoch(err, am.lastAuditEpoch)
 		}
-	}
-
-	return nil
-}
 
-func waitForEpochConfirmed(cfx *sdk.Client, epoch *big.Int) {
-	for {
-		current, err := cfx.GetEpochNumber(types.EpochLatestState)
-		if err != nil {
-			logger.WithError(err).Warn("failed to get epoch number from full node")
-			time.Sleep(time.Second)
-			continue
+		// annotated with the epoch before reorged epochs if reorg detected
+		if err := am.CheckReorg(); err != nil {
+			return err
 		}
 
-		confirmedEpoch := new(big.Int).Sub(current.ToInt(), common.NumEpochsConfirmed)
-
-		if epoch.Cmp(confirmedEpoch) > 0 {
-			logger.WithFields(logrus.Fields{
-				"epochAudit":       epoch,
-				"epochConfirmed":   confirmedEpoch,
-				"epochLatestState": current,
-			}).Trace("audit epoch is not confirmed yet")
-			time.Sleep(time.Second)
-			continue
+		logger.WithField("epochBasedOn", am.lastAuditEpoch).Trace("begin to audit event logs")
+		common.WaitForEpochConfirmed(am.Cfx, am.lastAuditEpoch, logger)
+		epoch := new(big.Int).Add(am.lastAuditEpoch, common.Big1)
+		if err := am.AuditNextEpoch(); err != nil {
+			return common.WithEpoch(errors.WithMessagef(err, "failed to audit event logs, epochBasedOn = %v", am.lastAuditEpoch), epoch)
 		}
 
-		break
+		recoverFromRetrying()
 	}
+
+	return nil
 }
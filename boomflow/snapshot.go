@@ -0,0 +1,143 @@
+package boomflow
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+
+	"github.com/Conflux-Chain/go-conflux-sdk/types"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// SnapshotAccount represents the balance of an account in balance snapshot.
+type SnapshotAccount struct {
+	Account       string          `json:"account"`
+	Balance       *big.Int        `json:"balance"`       // raw balance in CRCL
+	ScaledBalance decimal.Decimal `json:"scaledBalance"` // balance scaled by decimals
+}
+
+// Snapshot represents the balances of all accounts in CRCL at an epoch.
+type Snapshot struct {
+	Asset       string            `json:"asset"`
+	Epoch       *big.Int          `json:"epoch"`
+	PivotHash   types.Hash        `json:"pivotHash"`
+	Decimals    uint8             `json:"decimals"`
+	Accounts    []SnapshotAccount `json:"accounts"` // in ascending order of account
+	Total       *big.Int          `json:"total"`    // sum of account balances
+	ScaledTotal decimal.Decimal   `json:"scaledTotal"`
+	TotalSupply *big.Int          `json:"totalSupply"` // total supply in CRCL
+	Matched     bool              `json:"matched"`     // whether total matches with total supply
+}
+
+// Assets returns the names of audited assets in ascending order.
+func (am *AuditManager) Assets() []string {
+	var assets []string
+	for asset := range am.auditors {
+		assets = append(assets, asset)
+	}
+	sort.Strings(assets)
+
+	return assets
+}
+
+// Snapshot collects the balances of all accounts in CRCL for the specified asset and epoch,
+// and checks the sum of balances against the total supply in CRCL.
+func (am *AuditManager) Snapshot(asset string, epoch *big.Int) (*Snapshot, error) {
+	auditor, ok := am.auditors[asset]
+	if !ok {
+		return nil, fmt.Errorf("invalid asset %v", asset)
+	}
+
+	epochNum := types.NewEpochNumberBig(epoch)
+
+	block, err := am.Cfx.GetBlockSummaryByEpoch(epochNum)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to get pivot block of epoch %v", epoch)
+	}
+
+	decimals, err := auditor.erc777.Decimals(epochNum)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to get decimals in ERC777")
+	}
+
+	totalSupply, err := auditor.crcl.TotalSupply(epochNum)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to get total supply in CRCL")
+	}
+
+	details, err := auditor.AuditAccountBalance(epoch)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to get account balances in CRCL")
+	}
+
+	exp := -int32(decimals)
+	balances := details.AccountBalances.Map()
+
+	var accounts []string
+	for account := range balances {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+
+	snapshot := Snapshot{
+		Asset:       asset,
+		Epoch:       epoch,
+		PivotHash:   block.Hash,
+		Decimals:    decimals,
+		Total:       details.TotalSupply,
+		ScaledTotal: decimal.NewFromBigInt(details.TotalSupply, exp),
+		TotalSupply: totalSupply,
+		Matched:     details.TotalSupply.Cmp(totalSupply) == 0,
+	}
+
+	for _, account := range accounts {
+		snapshot.Accounts = append(snapshot.Accounts, SnapshotAccount{
+			Account:       account,
+			Balance:       balances[account],
+			ScaledBalance: decimal.NewFromBigInt(balances[account], exp),
+		})
+	}
+
+	return &snapshot, nil
+}
+
+// WriteSnapshotsJSON writes the balance snapshots in JSON format.
+func WriteSnapshotsJSON(w io.Writer, snapshots []*Snapshot) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(snapshots)
+}
+
+// WriteSnapshotsCSV writes the balance snapshots in CSV format, and each snapshot ends with
+// a totals row, in which the total supply in CRCL and check result are filled.
+func WriteSnapshotsCSV(w io.Writer, snapshots []*Snapshot) error {
+	writer := csv.NewWriter(w)
+
+	header := []string{"asset", "epoch", "pivot_hash", "account", "balance", "scaled_balance", "total_supply", "matched"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, s := range snapshots {
+		for _, account := range s.Accounts {
+			row := []string{s.Asset, s.Epoch.String(), s.PivotHash.String(), account.Account, account.Balance.String(), account.ScaledBalance.String(), "", ""}
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+
+		totals := []string{s.Asset, s.Epoch.String(), s.PivotHash.String(), "TOTAL", s.Total.String(), s.ScaledTotal.String(), s.TotalSupply.String(), fmt.Sprint(s.Matched)}
+		if err := writer.Write(totals); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
@@ -0,0 +1,164 @@
+package boomflow
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/open-dex/conflux-dex-audit/common"
+	"github.com/sirupsen/logrus"
+)
+
+// State is the state of Boomflow audit service.
+type State string
+
+// States of Boomflow audit service.
+const (
+	// StateHealthy means all epochs audited without any failure since last round.
+	StateHealthy State = "healthy"
+	// StateRetrying means audit failed due to transient error, e.g. RPC error, and is being retried.
+	StateRetrying State = "retrying"
+	// StateDegraded means inconsistency or permanent error found, and audit continues with a new baseline.
+	StateDegraded State = "degraded"
+)
+
+const failedEpochPrefix = "failed/"
+
+// StatusInterval is the interval to notify the status of Boomflow audit service, zero
+// means disabled.
+var StatusInterval = time.Hour
+
+// Status represents the current status of Boomflow audit service.
+type Status struct {
+	State        State     `json:"state"`
+	Since        time.Time `json:"since"`        // time when entered the current state
+	Retries      int       `json:"retries"`      // number of continuous retries for transient error
+	LastError    string    `json:"lastError"`    // last error of audit, empty if no error happened
+	FailedEpochs []string  `json:"failedEpochs"` // epochs that inconsistency or permanent error found
+}
+
+// FailedEpoch represents an epoch that inconsistency found during audit.
+type FailedEpoch struct {
+	Epoch *big.Int  `json:"epoch"`
+	Error string    `json:"error"`
+	Time  time.Time `json:"time"`
+}
+
+var (
+	status      = Status{State: StateHealthy, Since: time.Now()}
+	statusMu    sync.RWMutex
+	retriedFrom State // state before retrying
+)
+
+// GetStatus returns the current status of Boomflow audit service.
+func GetStatus() Status {
+	statusMu.RLock()
+	defer statusMu.RUnlock()
+
+	result := status
+	result.FailedEpochs = append([]string(nil), status.FailedEpochs...)
+
+	return result
+}
+
+// String implements the fmt.Stringer interface.
+func (s Status) String() string {
+	result := fmt.Sprintf("state = %v, since = %v, retries = %v, failed epochs = %v",
+		s.State, s.Since.Format(time.RFC3339), s.Retries, len(s.FailedEpochs))
+
+	if n := len(s.FailedEpochs); n > 0 {
+		result += fmt.Sprintf(" (last %v)", s.FailedEpochs[n-1])
+	}
+
+	if len(s.LastError) > 0 {
+		result += fmt.Sprintf(", last error = %v", s.LastError)
+	}
+
+	return result
+}
+
+// notifyStatus notifies the current status of Boomflow audit service periodically, so that
+// a degraded or retrying service will not be left unnoticed.
+func notifyStatus(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		current := GetStatus()
+		logger.WithField("status", current.String()).Info("report audit status")
+		common.Notifyf("Boomflow audit status: %v", current)
+	}
+}
+
+// setState changes the state of Boomflow audit service, and notifies if state changed.
+func setState(state State, err error) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+
+	if err != nil {
+		status.LastError = err.Error()
+	}
+
+	if state == StateRetrying {
+		status.Retries++
+	} else {
+		status.Retries = 0
+	}
+
+	if status.State == state {
+		return
+	}
+
+	if state == StateRetrying {
+		retriedFrom = status.State
+	}
+
+	logger.WithFields(logrus.Fields{
+		"from":     status.State,
+		"to":       state,
+		"duration": time.Since(status.Since),
+	}).Info("audit state changed")
+
+	if state == StateHealthy {
+		common.Notifyf("Boomflow audit recovered from %v state, duration = %v", status.State, time.Since(status.Since))
+	}
+
+	status.State = state
+	status.Since = time.Now()
+}
+
+// recoverFromRetrying restores the state before retrying once audit progressed.
+func recoverFromRetrying() {
+	statusMu.RLock()
+	state, from := status.State, retriedFrom
+	statusMu.RUnlock()
+
+	if state == StateRetrying {
+		setState(from, nil)
+	}
+}
+
+// markEpochFailed records the epoch that inconsistency found, and persists it in checkpoint
+// store if configured.
+func (am *AuditManager) markEpochFailed(epoch *big.Int, auditErr error) {
+	statusMu.Lock()
+	status.FailedEpochs = append(status.FailedEpochs, epoch.String())
+	statusMu.Unlock()
+
+	setState(StateDegraded, auditErr)
+
+	if am.store == nil {
+		return
+	}
+
+	record := FailedEpoch{
+		Epoch: epoch,
+		Error: auditErr.Error(),
+		Time:  time.Now(),
+	}
+
+	if err := am.store.Put(fmt.Sprintf("%v%020d", failedEpochPrefix, epoch), &record); err != nil {
+		logger.WithError(err).WithField("epoch", epoch).Warn("failed to save failed epoch")
+	}
+}
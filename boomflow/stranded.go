@@ -0,0 +1,259 @@
+package boomflow
+
+import (
+	"math/big"
+	"strings"
+
+	sdk "github.com/Conflux-Chain/go-conflux-sdk"
+	"github.com/Conflux-Chain/go-conflux-sdk/types"
+	"github.com/Conflux-Chain/go-conflux-sdk/types/cfxaddress"
+	"github.com/open-dex/conflux-dex-audit/common"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const strandedPrefix = "stranded/"
+
+// StrandedTransfer represents a transaction that transferred ERC777 tokens to CRCL by the
+// ERC20 compatible transfer method instead of send, so the tokens are not deposited in CRCL.
+type StrandedTransfer struct {
+	Epoch  *big.Int `json:"epoch"`
+	TxHash string   `json:"txHash"`
+	Amount *big.Int `json:"amount"` // amount of Transfer events not covered by Sent/Minted events
+}
+
+// StrandedBalance is the running amount of ERC777 tokens stranded in CRCL by transfer.
+type StrandedBalance struct {
+	Epoch     *big.Int           `json:"epoch"`     // last tracked epoch
+	Amount    *big.Int           `json:"amount"`    // total stranded amount until epoch
+	Transfers []StrandedTransfer `json:"transfers"` // contributing transactions in ascending order of epoch
+}
+
+// amountAt returns the stranded amount until the specified epoch, which should not be later than
+// the last tracked epoch.
+func (b *StrandedBalance) amountAt(epoch *big.Int) *big.Int {
+	if epoch.Cmp(b.Epoch) >= 0 {
+		return b.Amount
+	}
+
+	result := big.NewInt(0)
+	for _, transfer := range b.Transfers {
+		if transfer.Epoch.Cmp(epoch) <= 0 {
+			result.Add(result, transfer.Amount)
+		}
+	}
+
+	return result
+}
+
+// strandedTracker tracks the ERC777 Transfer events against Sent/Minted events into CRCL
+// since the deployment epoch of CRCL, so as to derive the balance of CRCL in ERC777 that
+// is not deposited by send method.
+type strandedTracker struct {
+	asset      string
+	crcl       string // CRCL address in lower case hex
+	decoder    *common.EventDecoder
+	logFetcher *common.EpochLogFetcher
+	balance    *StrandedBalance
+	changed    bool // changed since last checkpoint
+	logger     logrus.FieldLogger
+}
+
+func newStrandedTracker(cfx *sdk.Client, asset common.Asset) *strandedTracker {
+	decoder := common.MustNewEventDecoder(common.Erc777ABI)
+	addresses := []types.Address{cfxaddress.MustNewFromHex(asset.TokenAddress, common.GetNetworkId())}
+
+	// Minted is overloaded in ERC777 ABI, the standard one is Minted0
+	topics := [][]types.Hash{{
+		decoder.EventHash("Transfer"),
+		decoder.EventHash("Sent"),
+		decoder.EventHash("Minted"),
+		decoder.EventHash("Minted0"),
+	}}
+
+	return &strandedTracker{
+		asset:      asset.Name,
+		crcl:       strings.ToLower(asset.ContractAddress),
+		decoder:    decoder,
+		logFetcher: common.NewEpochLogFetcher(cfx, addresses, topics),
+		logger:     logger.WithField("asset", asset.Name),
+	}
+}
+
+// init starts to track from the deployment epoch of CRCL if not loaded from checkpoint. Note,
+// the tracker is only used when deployment epoch configured and balancesByTransfer not.
+func (tracker *strandedTracker) init() error {
+	if tracker.balance != nil {
+		return nil
+	}
+
+	// tracking since genesis is too slow and hides misconfiguration
+	deploymentEpoch := config.DeploymentEpochs[tracker.asset]
+	if deploymentEpoch == 0 {
+		return common.PermanentErrorf("deployment epoch of asset %v not configured to track stranded balance, configure deploymentEpochs or balancesByTransfer", tracker.asset)
+	}
+
+	tracker.balance = &StrandedBalance{
+		Epoch:  new(big.Int).Sub(new(big.Int).SetUint64(deploymentEpoch), common.Big1),
+		Amount: big.NewInt(0),
+	}
+
+	return nil
+}
+
+// StrandedAt returns the amount of ERC777 tokens stranded in CRCL by transfer until the specified epoch.
+func (tracker *strandedTracker) StrandedAt(epoch *big.Int) (*big.Int, error) {
+	if err := tracker.init(); err != nil {
+		return nil, err
+	}
+
+	for tracker.balance.Epoch.Cmp(epoch) < 0 {
+		next := new(big.Int).Add(tracker.balance.Epoch, common.Big1)
+		if err := tracker.track(next); err != nil {
+			return nil, errors.WithMessagef(err, "failed to track stranded balance for epoch %v", next)
+		}
+	}
+
+	return tracker.balance.amountAt(epoch), nil
+}
+
+func (tracker *strandedTracker) track(epoch *big.Int) error {
+	logs, err := tracker.logFetcher.GetLogs(epoch)
+	if err != nil {
+		return errors.WithMessage(err, "failed to poll ERC777 event logs")
+	}
+
+	// amounts of Transfer and Sent/Minted events to CRCL, key is tx hash
+	var txHashes []string
+	transferred := make(map[string]*big.Int)
+	sent := make(map[string]*big.Int)
+
+	for i := range logs {
+		event, err := tracker.decoder.Decode(&logs[i])
+		if err != nil {
+			return errors.WithMessagef(err, "failed to decode ERC777 event, log index = %v", i)
+		}
+
+		var recipient string
+		var amount *big.Int
+		amounts := sent
+
+		switch event.Name {
+		case "Transfer":
+			recipient, amount, amounts = event.Address("to"), event.BigInt("value"), transferred
+		case "Sent", "Minted0":
+			recipient, amount = event.Address("to"), event.BigInt("amount")
+		case "Minted":
+			recipient, amount = event.Address("toAddress"), event.BigInt("amount")
+		}
+
+		if recipient != tracker.crcl || amount == nil {
+			continue
+		}
+
+		txHash := event.TxHash()
+		if _, ok := transferred[txHash]; !ok {
+			txHashes = append(txHashes, txHash)
+			transferred[txHash] = big.NewInt(0)
+			sent[txHash] = big.NewInt(0)
+		}
+
+		amounts[txHash].Add(amounts[txHash], amount)
+	}
+
+	for _, txHash := range txHashes {
+		stranded := new(big.Int).Sub(transferred[txHash], sent[txHash])
+		if stranded.Sign() <= 0 {
+			continue
+		}
+
+		transfer := StrandedTransfer{
+			Epoch:  epoch,
+			TxHash: txHash,
+			Amount: stranded,
+		}
+
+		tracker.balance.Transfers = append(tracker.balance.Transfers, transfer)
+		tracker.balance.Amount = new(big.Int).Add(tracker.balance.Amount, stranded)
+
+		tracker.logger.WithFields(logrus.Fields{
+			"epoch":  epoch,
+			"tx":     txHash,
+			"amount": stranded,
+			"total":  tracker.balance.Amount,
+		}).Warn("ERC777 tokens transferred to CRCL not by send")
+
+		common.Notifyf("ERC777 tokens transferred to CRCL not by send, asset = %v, epoch = %v, tx = %v, amount = %v, total stranded = %v",
+			tracker.asset, epoch, txHash, stranded, tracker.balance.Amount)
+	}
+
+	tracker.balance.Epoch = epoch
+	tracker.changed = true
+
+	return nil
+}
+
+// rewind drops the tracked transfers since the specified epoch, e.g. pivot chain reorg.
+func (tracker *strandedTracker) rewind(epoch *big.Int) {
+	if tracker.balance == nil || tracker.balance.Epoch.Cmp(epoch) < 0 {
+		return
+	}
+
+	var transfers []StrandedTransfer
+	amount := big.NewInt(0)
+	for _, transfer := range tracker.balance.Transfers {
+		if transfer.Epoch.Cmp(epoch) < 0 {
+			transfers = append(transfers, transfer)
+			amount.Add(amount, transfer.Amount)
+		}
+	}
+
+	tracker.balance.Transfers = transfers
+	tracker.balance.Epoch = new(big.Int).Sub(epoch, common.Big1)
+	tracker.balance.Amount = amount
+	tracker.changed = true
+
+	tracker.logFetcher.Reset()
+}
+
+// loadStrandedBalances loads the tracked stranded balances from checkpoint store.
+func (am *AuditManager) loadStrandedBalances() error {
+	for _, auditor := range am.auditors {
+		if err := am.loadStrandedBalance(auditor); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (am *AuditManager) loadStrandedBalance(auditor *AssetAuditor) error {
+	var balance StrandedBalance
+	found, err := am.store.Get(strandedPrefix+auditor.asset.Name, &balance)
+	if err != nil {
+		return errors.WithMessagef(err, "failed to load stranded balance of asset %v", auditor.asset.Name)
+	}
+
+	if found {
+		auditor.stranded.balance = &balance
+	}
+
+	return nil
+}
+
+// putStrandedBalances adds the stranded balances changed since last checkpoint into batch.
+func (am *AuditManager) putStrandedBalances(batch *common.StoreBatch) error {
+	for asset, auditor := range am.auditors {
+		if !auditor.stranded.changed {
+			continue
+		}
+
+		if err := batch.Put(strandedPrefix+asset, auditor.stranded.balance); err != nil {
+			return errors.WithMessagef(err, "failed to save stranded balance of asset %v", asset)
+		}
+
+		auditor.stranded.changed = false
+	}
+
+	return nil
+}
@@ -0,0 +1,256 @@
+package boomflow
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/Conflux-Chain/go-conflux-sdk/types"
+	"github.com/open-dex/conflux-dex-audit/common"
+	"github.com/pkg/errors"
+)
+
+// TxTransfer represents a CRCL transfer in transaction that changed the balance of an account.
+type TxTransfer struct {
+	TxHash    string   `json:"txHash"`
+	Sender    string   `json:"sender"`
+	Recipient string   `json:"recipient"`
+	Amount    *big.Int `json:"amount"`
+	Balance   *big.Int `json:"balance"` // account balance after transfer
+}
+
+// String implements the fmt.Stringer interface.
+func (t TxTransfer) String() string {
+	return fmt.Sprintf("{tx = %v, sender = %v, recipient = %v, amount = %v, balance = %v}", t.TxHash, t.Sender, t.Recipient, t.Amount, t.Balance)
+}
+
+// locatorLimits states the limits of the heuristic to locate inconsistent transactions.
+const locatorLimits = "suspects are located by balance replay of CRCL Transfer events and events of account in CRCL and ERC777 " +
+	"within the epoch only, balance changed without any event of account (e.g. storage written by admin) cannot be located"
+
+var (
+	erc777Decoder     *common.EventDecoder
+	erc777DecoderOnce sync.Once
+)
+
+// erc777EventDecoder returns the event decoder of ERC777 contract.
+func erc777EventDecoder() *common.EventDecoder {
+	erc777DecoderOnce.Do(func() {
+		erc777Decoder = common.MustNewEventDecoder(common.Erc777ABI)
+	})
+
+	return erc777Decoder
+}
+
+// TxEvent represents an event of account in CRCL or ERC777 other than CRCL Transfer.
+type TxEvent struct {
+	TxHash   string `json:"txHash"`
+	Contract string `json:"contract"` // CRCL or ERC777
+	Event    string `json:"event"`
+	Tracked  bool   `json:"tracked"` // whether the transaction has CRCL Transfer of account
+}
+
+// String implements the fmt.Stringer interface.
+func (e TxEvent) String() string {
+	return fmt.Sprintf("{tx = %v, event = %v.%v, tracked = %v}", e.TxHash, e.Contract, e.Event, e.Tracked)
+}
+
+// InconsistencyReport represents the result to locate transactions that caused inconsistent balance.
+type InconsistencyReport struct {
+	Asset              string       `json:"asset"`
+	Account            string       `json:"account"`
+	Epoch              *big.Int     `json:"epoch"`
+	AuditBalanceBefore *big.Int     `json:"auditBalanceBefore"` // audited balance before epoch
+	BalanceBefore      *big.Int     `json:"balanceBefore"`      // on chain balance before epoch
+	ReplayBalance      *big.Int     `json:"replayBalance"`      // balance replayed from on chain balance before epoch
+	ActualBalance      *big.Int     `json:"actualBalance"`      // on chain balance at epoch
+	Transfers          []TxTransfer `json:"transfers"`          // all transfers of account in epoch
+	Suspects           []TxTransfer `json:"suspects"`           // transfers that probably caused inconsistency
+	Events             []TxEvent    `json:"events"`             // other events of account in CRCL and ERC777
+	UntrackedTxs       []string     `json:"untrackedTxs"`       // transactions with events of account but no CRCL Transfer
+	Limits             string       `json:"limits"`             // limits of the heuristic to locate transactions
+}
+
+// String implements the fmt.Stringer interface.
+func (r *InconsistencyReport) String() string {
+	var reasons []string
+
+	if r.AuditBalanceBefore.Cmp(r.BalanceBefore) != 0 {
+		reasons = append(reasons, fmt.Sprintf("balance already inconsistent before epoch, audit = %v, actual = %v", r.AuditBalanceBefore, r.BalanceBefore))
+	}
+
+	if r.ReplayBalance.Cmp(r.ActualBalance) != 0 {
+		reasons = append(reasons, fmt.Sprintf("replayed balance = %v, actual = %v, suspect txs = %v", r.ReplayBalance, r.ActualBalance, r.Suspects))
+	}
+
+	if len(r.UntrackedTxs) > 0 {
+		reasons = append(reasons, fmt.Sprintf("txs without CRCL Transfer of account = %v, events = %v", r.UntrackedTxs, r.Events))
+	}
+
+	if len(reasons) == 0 {
+		reasons = append(reasons, fmt.Sprintf("no transaction located, transfers = %v, events = %v", r.Transfers, r.Events))
+	}
+
+	return fmt.Sprintf("%v (note: %v)", strings.Join(reasons, "; "), r.Limits)
+}
+
+// locateInconsistentTx replays the CRCL transfers of epoch transaction by transaction against the
+// on chain balance before epoch, so as to locate the transactions that caused inconsistent balance.
+func (am *AuditManager) locateInconsistentTx(asset, account string, auditBalanceBefore *big.Int, epoch *big.Int, logs []types.Log) (*InconsistencyReport, error) {
+	crcl := am.auditors[asset].crcl
+
+	balanceBefore, err := crcl.BalanceOf(account, types.NewEpochNumberBig(new(big.Int).Sub(epoch, common.Big1)))
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to get balance before epoch")
+	}
+
+	actualBalance, err := crcl.BalanceOf(account, types.NewEpochNumberBig(epoch))
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to get balance at epoch")
+	}
+
+	report := InconsistencyReport{
+		Asset:              asset,
+		Account:            account,
+		Epoch:              epoch,
+		AuditBalanceBefore: auditBalanceBefore,
+		BalanceBefore:      balanceBefore,
+		ActualBalance:      actualBalance,
+		Limits:             locatorLimits,
+	}
+
+	// replay transfers grouped by transaction in order
+	var txHashes []string
+	txDeltas := make(map[string]*big.Int)
+	balance := balanceBefore
+
+	for i := range logs {
+		log := &logs[i]
+		if am.crcl2AssetMap[log.Address.GetHexAddress()] != asset || len(log.Topics) == 0 || log.Topics[0] != common.EventHashTransfer {
+			continue
+		}
+
+		sender, recipient, amount := decodeCrclEvent(log)
+		delta := new(big.Int)
+		if strings.EqualFold(sender, account) {
+			delta.Sub(delta, amount)
+		}
+
+		if strings.EqualFold(recipient, account) {
+			delta.Add(delta, amount)
+		}
+
+		if delta.Sign() == 0 && !strings.EqualFold(sender, account) && !strings.EqualFold(recipient, account) {
+			continue
+		}
+
+		txHash := log.TransactionHash.String()
+		if _, ok := txDeltas[txHash]; !ok {
+			txHashes = append(txHashes, txHash)
+			txDeltas[txHash] = new(big.Int)
+		}
+
+		txDeltas[txHash].Add(txDeltas[txHash], delta)
+		balance = new(big.Int).Add(balance, delta)
+
+		report.Transfers = append(report.Transfers, TxTransfer{
+			TxHash:    txHash,
+			Sender:    sender,
+			Recipient: recipient,
+			Amount:    amount,
+			Balance:   balance,
+		})
+	}
+
+	report.ReplayBalance = balance
+
+	// transactions missing from CRCL Transfer logs could only be found by other events of account
+	if err = am.locateAccountEvents(&report, txDeltas); err != nil {
+		return nil, err
+	}
+
+	diff := new(big.Int).Sub(actualBalance, balance)
+	if diff.Sign() == 0 {
+		return &report, nil
+	}
+
+	// suspect the transactions whose balance change or transfer amount equals to the difference
+	absDiff := new(big.Int).Abs(diff)
+	for _, transfer := range report.Transfers {
+		if transfer.Amount.Cmp(absDiff) == 0 || new(big.Int).Abs(txDeltas[transfer.TxHash]).Cmp(absDiff) == 0 {
+			report.Suspects = append(report.Suspects, transfer)
+		}
+	}
+
+	if len(report.Suspects) == 0 {
+		report.Suspects = report.Transfers
+	}
+
+	return &report, nil
+}
+
+// locateAccountEvents collects the events of account in CRCL and ERC777 other than CRCL Transfer
+// in epoch, e.g. Deposit, Withdraw, Sent and Minted, and reports the transactions that have no
+// CRCL Transfer of account, which are likely missing from the audited logs.
+func (am *AuditManager) locateAccountEvents(report *InconsistencyReport, txDeltas map[string]*big.Int) error {
+	auditor := am.auditors[report.Asset]
+	contracts := []struct {
+		name     string
+		contract *common.Contract
+		decoder  *common.EventDecoder
+	}{
+		{"CRCL", auditor.crcl, crclEventDecoder()},
+		{"ERC777", auditor.erc777, erc777EventDecoder()},
+	}
+
+	untracked := make(map[string]bool)
+	for _, c := range contracts {
+		logs, err := am.Cfx.GetLogs(types.LogFilter{
+			FromEpoch: types.NewEpochNumberBig(report.Epoch),
+			ToEpoch:   types.NewEpochNumberBig(report.Epoch),
+			Address:   []types.Address{*c.contract.Contract.Address},
+		})
+		if err != nil {
+			return errors.WithMessagef(err, "failed to get event logs of %v", c.name)
+		}
+
+		for i := range logs {
+			if c.name == "CRCL" && len(logs[i].Topics) > 0 && logs[i].Topics[0] == common.EventHashTransfer {
+				continue
+			}
+
+			event, err := c.decoder.Decode(&logs[i])
+			if err != nil || !eventOfAccount(event, report.Account) {
+				continue
+			}
+
+			txHash := logs[i].TransactionHash.String()
+			_, tracked := txDeltas[txHash]
+			report.Events = append(report.Events, TxEvent{
+				TxHash:   txHash,
+				Contract: c.name,
+				Event:    event.Name,
+				Tracked:  tracked,
+			})
+
+			if !tracked && !untracked[txHash] {
+				untracked[txHash] = true
+				report.UntrackedTxs = append(report.UntrackedTxs, txHash)
+			}
+		}
+	}
+
+	return nil
+}
+
+// eventOfAccount returns true if any address argument of event is the specified account.
+func eventOfAccount(event *common.Event, account string) bool {
+	for arg := range event.Args {
+		if strings.EqualFold(event.Address(arg), account) {
+			return true
+		}
+	}
+
+	return false
+}
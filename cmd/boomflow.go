@@ -1,8 +1,12 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"math/big"
+	"os"
 	"sync"
+	"time"
 
 	"github.com/Conflux-Chain/go-conflux-sdk/types"
 	"github.com/Conflux-Chain/go-conflux-sdk/types/cfxaddress"
@@ -14,8 +18,20 @@ import (
 
 var (
 	asset                      string
+	account                    string
 	showDetails                bool
 	balanceAuditIntervalEpochs uint64
+	snapshotFormat             string
+	outputPath                 string
+	replayFrom                 uint64
+	replayTo                   uint64
+
+	boomflowConfig *common.BoomflowConfig = &common.BoomflowConfig{
+		ConfigPath:     "./boomflow/config.json",
+		CheckpointPath: "./leveldb/boomflow/checkpoint",
+		Resume:         true,
+		Reset:          false,
+	}
 )
 
 var boomflowAuditCmd = &cobra.Command{
@@ -29,7 +45,7 @@ var boomflowAuditCmd = &cobra.Command{
 		}).Info("start to audit boomflow")
 
 		wg := sync.WaitGroup{}
-		boomflow.StartSince(cfxURL, common.MatchflowURL, epochNum, balanceAuditIntervalEpochs, &wg)
+		boomflow.StartSince(cfxURL, common.MatchflowURL, epochNum, balanceAuditIntervalEpochs, boomflowConfig, &wg)
 		wg.Wait()
 	},
 }
@@ -38,7 +54,7 @@ var boomflowAuditBalanceCmd = &cobra.Command{
 	Use:   "balance",
 	Short: "Audit balance for specific epoch",
 	Run: func(cmd *cobra.Command, args []string) {
-		am := boomflow.NewAuditManager(cfxURL, common.MatchflowURL)
+		am := mustNewAuditManager()
 		defer am.Close()
 
 		epochNum := mustParseEpoch()
@@ -55,11 +71,172 @@ var boomflowAuditBalanceCmd = &cobra.Command{
 		} else if showDetails {
 			logger.WithField("details", *details).Info("succeed to audit balance")
 		} else {
-			logger.Info("succeed to audit balance")
+			logger.WithField("root", details.MerkleRoot.Hex()).Info("succeed to audit balance")
 		}
 	},
 }
 
+var boomflowProofCmd = &cobra.Command{
+	Use:   "proof",
+	Short: "Print merkle inclusion proof of account balance for specific epoch",
+	Run: func(cmd *cobra.Command, args []string) {
+		am := mustNewAuditManager()
+		defer am.Close()
+
+		epochNum := mustParseEpoch()
+
+		// recorded merkle roots are optional, e.g. checkpoint is locked by audit service
+		if len(boomflowConfig.CheckpointPath) > 0 {
+			if err := am.OpenCheckpoint(boomflowConfig.CheckpointPath, false); err != nil {
+				logger.WithError(err).Warn("failed to open checkpoint, recorded merkle root unavailable")
+			}
+		}
+
+		logger.WithFields(logrus.Fields{
+			"asset":   asset,
+			"account": account,
+			"epoch":   epochNum,
+		}).Info("begin to build merkle proof")
+
+		proof, err := am.ProveAccountBalance(asset, account, epochNum)
+		if err != nil {
+			logger.WithError(err).Fatal("failed to build merkle proof")
+		}
+
+		record, err := am.GetMerkleRoot(asset, epochNum)
+		if err != nil {
+			logger.WithError(err).Warn("failed to get recorded merkle root")
+		}
+
+		output := map[string]interface{}{
+			"asset":    asset,
+			"epoch":    epochNum,
+			"proof":    proof,
+			"verified": proof.Verify(),
+		}
+
+		// merkle root is not recorded at every epoch, and balances may have changed since last record
+		if record != nil {
+			output["recorded"] = record
+
+			if record.Epoch.Cmp(epochNum) == 0 {
+				output["matched"] = record.Root == proof.Root
+			}
+		}
+
+		data, err := json.MarshalIndent(output, "", "  ")
+		if err != nil {
+			logger.WithError(err).Fatal("failed to marshal merkle proof")
+		}
+
+		fmt.Println(string(data))
+	},
+}
+
+var boomflowSnapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Export balances of all accounts in CRCL for specific epoch",
+	Run: func(cmd *cobra.Command, args []string) {
+		if snapshotFormat != "csv" && snapshotFormat != "json" {
+			logger.WithField("format", snapshotFormat).Fatal("invalid snapshot format")
+		}
+
+		am := mustNewAuditManager()
+		defer am.Close()
+
+		epochNum := mustParseEpoch()
+
+		assets := []string{asset}
+		if asset == "all" {
+			assets = am.Assets()
+		}
+
+		logger.WithFields(logrus.Fields{
+			"assets": assets,
+			"epoch":  epochNum,
+		}).Info("begin to export balance snapshot")
+
+		var snapshots []*boomflow.Snapshot
+		for _, name := range assets {
+			snapshot, err := am.Snapshot(name, epochNum)
+			if err != nil {
+				logger.WithError(err).WithField("asset", name).Fatal("failed to get balance snapshot")
+			}
+
+			if !snapshot.Matched {
+				logger.WithFields(logrus.Fields{
+					"asset":       name,
+					"total":       snapshot.Total,
+					"totalSupply": snapshot.TotalSupply,
+				}).Error("sum of balances mismatch with total supply")
+			}
+
+			snapshots = append(snapshots, snapshot)
+		}
+
+		output := os.Stdout
+		if len(outputPath) > 0 {
+			file, err := os.Create(outputPath)
+			if err != nil {
+				logger.WithError(err).Fatal("failed to create output file")
+			}
+			defer file.Close()
+
+			output = file
+		}
+
+		var err error
+		if snapshotFormat == "csv" {
+			err = boomflow.WriteSnapshotsCSV(output, snapshots)
+		} else {
+			err = boomflow.WriteSnapshotsJSON(output, snapshots)
+		}
+
+		if err != nil {
+			logger.WithError(err).Fatal("failed to write balance snapshot")
+		}
+	},
+}
+
+var boomflowReplayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Replay event logs for a range of epochs and output balance changes of each epoch",
+	Run: func(cmd *cobra.Command, args []string) {
+		if replayFrom >= replayTo {
+			logger.WithFields(logrus.Fields{
+				"from": replayFrom,
+				"to":   replayTo,
+			}).Fatal("invalid epoch range to replay")
+		}
+
+		am := mustNewAuditManager()
+		defer am.Close()
+
+		output := os.Stdout
+		if len(outputPath) > 0 {
+			file, err := os.Create(outputPath)
+			if err != nil {
+				logger.WithError(err).Fatal("failed to create output file")
+			}
+			defer file.Close()
+
+			output = file
+		}
+
+		logger.WithFields(logrus.Fields{
+			"from": replayFrom,
+			"to":   replayTo,
+		}).Info("begin to replay event logs")
+
+		from, to := new(big.Int).SetUint64(replayFrom), new(big.Int).SetUint64(replayTo)
+		if err := am.Replay(from, to, output); err != nil {
+			logger.WithError(err).Fatal("failed to replay event logs")
+		}
+
+		logger.Info("succeed to replay event logs")
+	},
+}
+
 var boomflowAuditEventLogsCmd = &cobra.Command{
 	Use:   "event",
 	Short: "Audit event logs for specific epoch",
@@ -93,14 +270,51 @@ var boomflowAuditEventLogsCmd = &cobra.Command{
 	},
 }
 
+// mustNewAuditManager loads the config file specified by --config, and creates an AuditManager.
+func mustNewAuditManager() *boomflow.AuditManager {
+	if len(boomflowConfig.ConfigPath) > 0 {
+		if err := boomflow.LoadConfig(boomflowConfig.ConfigPath); err != nil {
+			logger.WithError(err).WithField("path", boomflowConfig.ConfigPath).Fatal("failed to load config")
+		}
+	}
+
+	return boomflow.NewAuditManager(cfxURL, common.MatchflowURL)
+}
+
 func init() {
+	boomflowAuditCmd.PersistentFlags().IntVar(&boomflow.MaxConcurrentAssets, "concurrency", 4, "Maximum number of assets to audit balance concurrently")
 	boomflowAuditCmd.Flags().Uint64Var(&balanceAuditIntervalEpochs, "interval", 5000, "Number of epochs to audit balance once")
+	boomflowAuditCmd.PersistentFlags().StringVar(&boomflowConfig.ConfigPath, "config", "./boomflow/config.json", "path to config file, which is reloaded once changed, empty value means default config")
+	boomflowAuditCmd.Flags().StringVar(&boomflowConfig.CheckpointPath, "checkpoint", "./leveldb/boomflow/checkpoint", "path to leveldb folder of audit checkpoint, empty value means checkpoint disabled")
+	boomflowAuditCmd.Flags().BoolVar(&boomflowConfig.Resume, "resume", true, "whether resume from the last verified epoch in checkpoint instead of a new balance audit")
+	boomflowAuditCmd.Flags().BoolVar(&boomflowConfig.Reset, "reset", false, "whether remove the saved checkpoint before audit")
+	boomflowAuditCmd.Flags().DurationVar(&boomflow.StatusInterval, "status-interval", time.Hour, "interval to notify audit status, zero means disabled")
 
 	boomflowAuditBalanceCmd.Flags().StringVar(&asset, "asset", "", "Asset to audit")
 	boomflowAuditBalanceCmd.MarkFlagRequired("asset")
 	boomflowAuditBalanceCmd.Flags().BoolVar(&showDetails, "details", false, "Whether to show account balance in details")
 	boomflowAuditCmd.AddCommand(boomflowAuditBalanceCmd)
 
+	boomflowProofCmd.Flags().StringVar(&asset, "asset", "", "Asset to audit")
+	boomflowProofCmd.MarkFlagRequired("asset")
+	boomflowProofCmd.Flags().StringVar(&account, "account", "", "Account to prove balance")
+	boomflowProofCmd.MarkFlagRequired("account")
+	boomflowProofCmd.Flags().StringVar(&boomflowConfig.CheckpointPath, "checkpoint", "./leveldb/boomflow/checkpoint", "path to leveldb folder of audit checkpoint, which records merkle roots")
+	boomflowAuditCmd.AddCommand(boomflowProofCmd)
+
+	boomflowSnapshotCmd.Flags().StringVar(&asset, "asset", "", "Asset to export, or all for all assets")
+	boomflowSnapshotCmd.MarkFlagRequired("asset")
+	boomflowSnapshotCmd.Flags().StringVar(&snapshotFormat, "format", "csv", "Output format, csv or json")
+	boomflowSnapshotCmd.Flags().StringVar(&outputPath, "output", "", "Path to output file, empty value means stdout")
+	boomflowAuditCmd.AddCommand(boomflowSnapshotCmd)
+
+	boomflowReplayCmd.Flags().Uint64Var(&replayFrom, "from", 0, "Epoch of account balances as baseline")
+	boomflowReplayCmd.MarkFlagRequired("from")
+	boomflowReplayCmd.Flags().Uint64Var(&replayTo, "to", 0, "Last epoch to replay")
+	boomflowReplayCmd.MarkFlagRequired("to")
+	boomflowReplayCmd.Flags().StringVar(&outputPath, "output", "", "Path to output file of JSON lines, empty value means stdout")
+	boomflowAuditCmd.AddCommand(boomflowReplayCmd)
+
 	boomflowAuditEventLogsCmd.Flags().BoolVar(&showDetails, "details", false, "Whether to show balance changed accounts in details")
 	boomflowAuditCmd.AddCommand(boomflowAuditEventLogsCmd)
 
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"sync"
+
+	"github.com/open-dex/conflux-dex-audit/fc"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var fcAddress string
+
+var fcAuditCmd = &cobra.Command{
+	Use:   "fc",
+	Short: "Start FC audit service",
+	Run: func(cmd *cobra.Command, args []string) {
+		epochNum := mustParseEpoch()
+		logger.WithFields(logrus.Fields{
+			"address":  fcAddress,
+			"epoch":    epochNum,
+			"interval": balanceAuditIntervalEpochs,
+		}).Info("start to audit FC")
+
+		wg := sync.WaitGroup{}
+		fc.StartSince(cfxURL, fcAddress, epochNum, balanceAuditIntervalEpochs, &wg)
+		wg.Wait()
+	},
+}
+
+var fcAuditBalanceCmd = &cobra.Command{
+	Use:   "balance",
+	Short: "Audit FC balance for specific epoch",
+	Run: func(cmd *cobra.Command, args []string) {
+		auditor := fc.NewAuditor(cfxURL, fcAddress)
+		defer auditor.Close()
+
+		epochNum := mustParseEpoch()
+
+		logger.WithFields(logrus.Fields{
+			"address": fcAddress,
+			"epoch":   epochNum,
+		}).Info("begin to audit FC balance")
+
+		details, err := auditor.AuditBalance(epochNum)
+
+		if err != nil {
+			logger.WithError(err).Error("failed to audit FC balance")
+		} else if showDetails {
+			logger.WithField("details", *details).Info("succeed to audit FC balance")
+		} else {
+			logger.WithFields(logrus.Fields{
+				"totalSupply":       details.TotalSupply,
+				"cap":               details.Cap,
+				"balanceOfContract": details.BalanceOfContract,
+				"circulationRatio":  details.CirculationRatio,
+				"pauseFlags":        details.PauseFlags,
+			}).Info("succeed to audit FC balance")
+		}
+	},
+}
+
+func init() {
+	fcAuditCmd.PersistentFlags().StringVar(&fcAddress, "address", "", "FC contract address")
+	fcAuditCmd.MarkPersistentFlagRequired("address")
+	fcAuditCmd.Flags().Uint64Var(&balanceAuditIntervalEpochs, "interval", 5000, "Number of epochs to audit balance once")
+
+	fcAuditBalanceCmd.Flags().BoolVar(&showDetails, "details", false, "Whether to show account balance in details")
+	fcAuditCmd.AddCommand(fcAuditBalanceCmd)
+
+	rootCmd.AddCommand(fcAuditCmd)
+}
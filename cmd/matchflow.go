@@ -16,6 +16,15 @@ var (
 		Pausable:     false,
 		DexStartTime: "2020-01-01 00:00:00",
 		DbUser:       "admin",
+		DbName:       "conflux_dex",
+		DbBackend:    "mysql",
+
+		CheckpointPath: "./leveldb/matchflow/checkpoint",
+		FromCheckpoint: true,
+		Reset:          false,
+
+		ReportDir: "./reports/matchflow",
+		ReportURL: "",
 	}
 )
 
@@ -47,9 +56,18 @@ func init() {
 	matchflowAuditTradeCmd.Flags().StringVar(&matchflowConfig.DbPass, "dbpass", "", "DEX database password")
 	matchflowAuditTradeCmd.Flags().StringVar(&matchflowConfig.DexStartTime, "dexstart", "2020-01-01 00:00:00", "DEX start time")
 	matchflowAuditTradeCmd.Flags().StringVar(&matchflowConfig.DbUser, "dbuser", "admin", "DEX db user")
+	matchflowAuditTradeCmd.Flags().StringVar(&matchflowConfig.DbName, "dbname", "conflux_dex", "DEX database name")
+	matchflowAuditTradeCmd.Flags().StringVar(&matchflowConfig.DbBackend, "db-backend", "mysql", "backend of DEX data, mysql or memory")
+	matchflowAuditTradeCmd.Flags().StringVar(&matchflowConfig.DbFixture, "db-fixture", "", "path to JSON fixture file of DEX data, used by memory backend")
+	matchflowAuditTradeCmd.Flags().StringVar(&matchflowConfig.CheckpointPath, "checkpoint", "./leveldb/matchflow/checkpoint", "path to leveldb folder of audit cursor, empty value means checkpoint disabled")
+	matchflowAuditTradeCmd.Flags().BoolVar(&matchflowConfig.FromCheckpoint, "from-checkpoint", true, "whether resume from the audit cursor in checkpoint instead of --full and --partial")
+	matchflowAuditTradeCmd.Flags().BoolVar(&matchflowConfig.Reset, "reset", false, "whether remove the saved checkpoint before audit")
+	matchflowAuditTradeCmd.Flags().StringVar(&matchflowConfig.ReportDir, "report-dir", "./reports/matchflow", "folder to write mismatch reports in JSON and CSV, empty value means report disabled")
+	matchflowAuditTradeCmd.Flags().StringVar(&matchflowConfig.ReportURL, "report-url", "", "URL prefix of report folder to link mismatch reports in alerts, empty value means linked by file path")
 	matchflowAuditTradeCmd.Flags().StringVar(&common.DexAdmin, "dexadmin", "", "DEX admin address")
 	matchflowAuditTradeCmd.Flags().BoolVar(&matchflowConfig.InitialAudit, "init", false, "whether check all account balance at beginning. used only when dex is paused")
 	matchflowAuditTradeCmd.Flags().BoolVar(&matchflowConfig.Pausable, "matchflow-pausable", false, "whether pause matchflow when error occurs")
+	matchflowAuditTradeCmd.Flags().StringVar(&matchflowConfig.BoomflowAddress, "boomflow", "", "Boomflow address to confirm paused on chain after matchflow suspended, empty value means not confirmed")
 	matchflowAuditCmd.AddCommand(matchflowAuditTradeCmd)
 	rootCmd.AddCommand(matchflowAuditCmd)
 }
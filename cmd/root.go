@@ -12,6 +12,7 @@ import (
 	"github.com/open-dex/conflux-dex-audit/common"
 	"github.com/open-dex/conflux-dex-audit/matchflow"
 	"github.com/open-dex/conflux-dex-audit/shuttleflow"
+	"github.com/open-dex/conflux-dex-audit/watcher"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -27,6 +28,9 @@ var (
 	epoch          string
 	logLevel       string
 	confirmEpochs  uint64
+	rpcRate        float64
+	rpcBurst       int
+	withWatcher    bool
 
 	rootCmd = &cobra.Command{
 		Use:   "conflux-dex-audit",
@@ -44,15 +48,22 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&epoch, "epoch", "-10", "Epoch to audit, negative value means epoch before latest_state")
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Log level: trace, debug, info, warn and error")
 	rootCmd.PersistentFlags().Uint64Var(&confirmEpochs, "confirm-epochs", 10, "Number of epochs before latest state treated as confirmed")
+	rootCmd.PersistentFlags().Uint64Var(&common.MaxEpochsPerLogQuery, "log-epochs", 100, "Maximum number of epochs to poll event logs in one RPC")
+	rootCmd.PersistentFlags().Float64Var(&rpcRate, "rpc-rate", 0, "Maximum number of RPC requests per second to full node in process, zero means unlimited")
+	rootCmd.PersistentFlags().IntVar(&rpcBurst, "rpc-burst", 10, "Maximum burst of RPC requests to full node")
+	rootCmd.PersistentFlags().IntVar(&common.MaxLogsPerQuery, "log-limit", 10000, "Maximum number of event logs that full node returns in one RPC")
 	rootCmd.PersistentFlags().StringVar(&common.DingDingAccessToken, "access-token", "", "Access token used to send message to alert system")
 	rootCmd.PersistentFlags().StringVar(&common.DexAdminPrivKey, "admin-privkey", "", "Private key of dex admin")
 	rootCmd.PersistentFlags().StringVar(&common.AesSecret, "AesSecret", "", "AesSecret")
 	rootCmd.PersistentFlags().StringVar(&common.CustodianAddress, "custodian-addr", "", "custodian node address")
 
+	rootCmd.Flags().BoolVar(&withWatcher, "with-watcher", false, "whether watch privileged roles and pause state of DEX contracts as well, with config file ./watcher/config.json")
+
 	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
 		setLogLevel()
 
 		common.NumEpochsConfirmed = new(big.Int).SetUint64(confirmEpochs)
+		common.RPCLimiter.SetLimit(rpcRate, rpcBurst)
 	}
 }
 
@@ -69,7 +80,10 @@ func start() {
 	wg := sync.WaitGroup{}
 
 	shuttleflow.Start(cfxURL, shuttleflowURL, assets, shuttleflowConfig, &wg)
-	boomflow.StartSince(cfxURL, common.MatchflowURL, epochNum, periodicalAuditIntervalEpochs, &wg)
+	boomflow.StartSince(cfxURL, common.MatchflowURL, epochNum, periodicalAuditIntervalEpochs, boomflowConfig, &wg)
+	if withWatcher {
+		watcher.Start(cfxURL, assets, epochNum, watcherConfig, &wg)
+	}
 	matchflow.Start(cfxURL, common.MatchflowURL, assets, matchflowConfig)
 
 	wg.Wait()
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"sync"
+
+	"github.com/open-dex/conflux-dex-audit/common"
+	"github.com/open-dex/conflux-dex-audit/watcher"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	watcherConfig *common.WatcherConfig = &common.WatcherConfig{
+		ConfigPath:    "./watcher/config.json",
+		ETHDial:       "",
+		ETHDelayBlock: 100,
+	}
+)
+
+var watcherCmd = &cobra.Command{
+	Use:   "watcher",
+	Short: "Watch privileged role changes of DEX contracts",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Get all currencies from DEX
+		assets := common.GetAssets(common.MatchflowURL)
+
+		epochNum := mustParseEpoch()
+		logger.WithFields(logrus.Fields{
+			"epoch":  epochNum,
+			"assets": len(assets),
+		}).Info("start to watch privileged roles")
+
+		wg := sync.WaitGroup{}
+		watcher.Start(cfxURL, assets, epochNum, watcherConfig, &wg)
+		wg.Wait()
+	},
+}
+
+func init() {
+	watcherCmd.Flags().StringVar(&watcherConfig.ConfigPath, "config", "./watcher/config.json", "path to config file of watched contracts and expected role holders")
+	watcherCmd.Flags().StringVar(&watcherConfig.ETHDial, "ethurl", "", "Ethereum RPC URL, empty value means EthFactory on Ethereum not watched")
+	watcherCmd.Flags().Int64Var(&watcherConfig.ETHDelayBlock, "ethdelay", 100, "Number of Ethereum blocks to wait for confirmation")
+
+	rootCmd.AddCommand(watcherCmd)
+}
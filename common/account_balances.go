@@ -1,6 +1,7 @@
 package common
 
 import (
+	"encoding/json"
 	"fmt"
 	"math/big"
 	"strings"
@@ -63,6 +64,18 @@ func (ab *AccountBalances) Map() map[string]*big.Int {
 	return ab.items
 }
 
+// Copy returns a copy of account balances, so that changes on either one will not
+// affect the other.
+func (ab *AccountBalances) Copy() *AccountBalances {
+	result := NewAccountBalances()
+
+	for account, balance := range ab.items {
+		result.items[account] = new(big.Int).Set(balance)
+	}
+
+	return result
+}
+
 // Sum calculates the sum of balances for all accounts.
 func (ab *AccountBalances) Sum() *big.Int {
 	sum := Big0
@@ -73,3 +86,23 @@ func (ab *AccountBalances) Sum() *big.Int {
 
 	return sum
 }
+
+// MarshalJSON implements the json.Marshaler interface.
+func (ab *AccountBalances) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ab.items)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (ab *AccountBalances) UnmarshalJSON(data []byte) error {
+	items := make(map[string]*big.Int)
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+
+	ab.items = make(map[string]*big.Int)
+	for account, balance := range items {
+		ab.Add(account, balance)
+	}
+
+	return nil
+}
@@ -12,6 +12,7 @@ import (
 	"strings"
 	"time"
 
+	sdk "github.com/Conflux-Chain/go-conflux-sdk"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/go-resty/resty/v2"
@@ -32,6 +33,43 @@ func InPauseTimeRange() bool {
 	return nowTime.Hour() < 7 || nowTime.Hour() > 22
 }
 
+// suspendHooks are invoked once AlertMatchflow requested to suspend matchflow.
+var suspendHooks []func(suspendedAt time.Time)
+
+// OnMatchflowSuspended registers a hook that invoked in goroutine once AlertMatchflow requested
+// to suspend matchflow, e.g. to confirm the pause state on chain.
+func OnMatchflowSuspended(hook func(suspendedAt time.Time)) {
+	suspendHooks = append(suspendHooks, hook)
+}
+
+// SuspendConfirmTimeout is the maximum time to wait for the Boomflow contract paused on chain
+// after matchflow suspended.
+var SuspendConfirmTimeout = 5 * time.Minute
+
+// ConfirmSuspended confirms that the Boomflow contract is paused on chain in time after matchflow
+// suspended at the specified time, and alerts otherwise. It logs with the logger of caller.
+func ConfirmSuspended(cfx *sdk.Client, boomflow string, suspendedAt time.Time, logger logrus.FieldLogger) {
+	contract := GetContract(cfx, BoomflowABI, boomflow)
+	deadline := suspendedAt.Add(SuspendConfirmTimeout)
+
+	for time.Now().Before(deadline) {
+		paused, err := contract.IsPaused("paused")
+		if err != nil {
+			logger.WithError(err).Warn("failed to read pause state of Boomflow")
+		} else if paused {
+			logger.WithField("elapsed", time.Since(suspendedAt)).Info("matchflow suspend confirmed on chain")
+			Notifyf("matchflow suspend confirmed on chain, Boomflow (%v) paused", boomflow)
+			return
+		}
+
+		time.Sleep(10 * time.Second)
+	}
+
+	logger.WithField("suspendedAt", suspendedAt).Error("Boomflow not paused after matchflow suspend")
+	Criticalf("matchflow", "Boomflow (%v) not paused on chain within %v after matchflow suspended at %v",
+		boomflow, SuspendConfirmTimeout, suspendedAt.Format(time.RFC3339))
+}
+
 // AlertMatchflow signal a fatal error to DEX.
 func AlertMatchflow() {
 	if !InPauseTimeRange() {
@@ -69,12 +107,17 @@ func AlertMatchflow() {
 		}
 		mapB, _ := json.Marshal(mapData)
 		fmt.Println(string(mapB))
+		suspendedAt := time.Now()
 		response, err := http.Post(MatchflowURL+"/system/suspend", "application/json", strings.NewReader(string(mapB)))
 		if err != nil {
 			panic(err)
 		}
 		defer response.Body.Close()
 
+		for _, hook := range suspendHooks {
+			go hook(suspendedAt)
+		}
+
 		// read the payload, in this case, Jhon's info
 		body, err := ioutil.ReadAll(response.Body)
 
@@ -113,6 +156,16 @@ func Alertf(module, format string, a ...interface{}) {
 	Alert(module, fmt.Sprintf(format, a...))
 }
 
+// Critical sends a high-priority message of specific module to Dingding.
+func Critical(module, message string) {
+	sendMessageToDingDing("[Critical] [%v] %v", module, message)
+}
+
+// Criticalf sends a high-priority message of specific module to Dingding.
+func Criticalf(module, format string, a ...interface{}) {
+	Critical(module, fmt.Sprintf(format, a...))
+}
+
 // Notify sends a message to Dingding.
 func Notify(message string) {
 	sendMessageToDingDing("[Notify] %v", message)
@@ -10,4 +10,32 @@ type MatchflowConfig struct {
 	Pausable     bool
 	DexStartTime string
 	DbUser       string
+	DbName       string
+
+	DbBackend string // backend of matchflow data, mysql or memory
+	DbFixture string // path to JSON fixture file of memory backend
+
+	CheckpointPath string // path to leveldb folder of audit cursor, empty means disabled
+	FromCheckpoint bool   // resume from the audit cursor in checkpoint instead of epoch offsets
+	Reset          bool   // remove the saved checkpoint before audit
+
+	ReportDir string // folder to write mismatch reports, empty means disabled
+	ReportURL string // URL prefix of report folder to link reports in alerts, file path linked if empty
+
+	BoomflowAddress string // confirm Boomflow paused on chain after matchflow suspended if configured
+}
+
+// BoomflowConfig configuration for boomflow auditor
+type BoomflowConfig struct {
+	ConfigPath     string
+	CheckpointPath string
+	Resume         bool
+	Reset          bool
+}
+
+// WatcherConfig configuration for watcher of privileged roles
+type WatcherConfig struct {
+	ConfigPath    string
+	ETHDial       string
+	ETHDelayBlock int64
 }
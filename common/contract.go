@@ -77,6 +77,18 @@ func (c *Contract) TotalSupply(epoch ...*types.Epoch) (*big.Int, error) {
 	return totalSupply, nil
 }
 
+// Decimals returns the decimals of this ERC777 contract.
+func (c *Contract) Decimals(epoch ...*types.Epoch) (uint8, error) {
+	option := c.buildOption(epoch...)
+	decimals := new(uint8)
+
+	if err := c.Contract.Call(option, &decimals, "decimals"); err != nil {
+		return 0, err
+	}
+
+	return *decimals, nil
+}
+
 // MustGetTotalSupply returns the specified total supply of this contract.
 func (c *Contract) MustGetTotalSupply(epoch ...*types.Epoch) *big.Int {
 	result, err := c.TotalSupply(epoch...)
@@ -196,3 +208,98 @@ func (c *Contract) MintedTxList(index int64, epoch ...*types.Epoch) string {
 
 	return list
 }
+
+// ListCustodians lists all custodians in CustodianCore.
+func (c *Contract) ListCustodians(epoch ...*types.Epoch) ([]string, error) {
+	option := c.buildOption(epoch...)
+	total := new(big.Int)
+
+	if err := c.Contract.Call(option, &total, "custodianCount"); err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for i := int64(0); i < total.Int64(); i++ {
+		var custodian common.Address
+		if err := c.Contract.Call(option, &custodian, "custodians", big.NewInt(i)); err != nil {
+			return nil, err
+		}
+
+		result = append(result, custodian.Hex())
+	}
+
+	return result, nil
+}
+
+// DeferTime returns the defer time in seconds of forced withdrawal in CRCL.
+func (c *Contract) DeferTime(epoch ...*types.Epoch) (*big.Int, error) {
+	option := c.buildOption(epoch...)
+	deferTime := new(big.Int)
+
+	if err := c.Contract.Call(option, &deferTime, "deferTime"); err != nil {
+		return nil, err
+	}
+
+	return deferTime, nil
+}
+
+// Cap returns the cap of total supply in FC.
+func (c *Contract) Cap(epoch ...*types.Epoch) (*big.Int, error) {
+	option := c.buildOption(epoch...)
+	result := new(big.Int)
+
+	if err := c.Contract.Call(option, &result, "cap"); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// StateOf returns the balance in conflux pool, personal pool and locked personal pool
+// of specified account in FC.
+func (c *Contract) StateOf(account string, epoch ...*types.Epoch) (*big.Int, *big.Int, *big.Int, error) {
+	option := c.buildOption(epoch...)
+	var state [3]*big.Int
+
+	if err := c.Contract.Call(option, &state, "stateOf", common.HexToAddress(account)); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return state[0], state[1], state[2], nil
+}
+
+// BalanceOfContract returns the balance of FC contract itself.
+func (c *Contract) BalanceOfContract(epoch ...*types.Epoch) (*big.Int, error) {
+	option := c.buildOption(epoch...)
+	balance := new(big.Int)
+
+	if err := c.Contract.Call(option, &balance, "balanceOfContract"); err != nil {
+		return nil, err
+	}
+
+	return balance, nil
+}
+
+// CirculationRatio returns the circulation ratio in FC.
+func (c *Contract) CirculationRatio(epoch ...*types.Epoch) (*big.Int, error) {
+	option := c.buildOption(epoch...)
+	ratio := new(big.Int)
+
+	if err := c.Contract.Call(option, &ratio, "circulationRatio"); err != nil {
+		return nil, err
+	}
+
+	return ratio, nil
+}
+
+// IsPaused returns the pause flag by specified method, e.g. paused, isTransferPaused.
+func (c *Contract) IsPaused(method string, epoch ...*types.Epoch) (bool, error) {
+	option := c.buildOption(epoch...)
+	paused := new(bool)
+
+	if err := c.Contract.Call(option, &paused, method); err != nil {
+		return false, err
+	}
+
+	return *paused, nil
+}
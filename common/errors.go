@@ -0,0 +1,126 @@
+package common
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Backoff settings to retry audit for transient errors.
+const (
+	MinRetryBackoff   = time.Second
+	MaxRetryBackoff   = 5 * time.Minute
+	MaxRetriesToAlert = 10 // alert if continuously retried for transient error
+)
+
+// InconsistencyError represents an inconsistency found during audit, e.g. balance mismatch,
+// which is distinguished from transient failures such as RPC error.
+type InconsistencyError struct {
+	message string
+}
+
+// Error implements the error interface.
+func (e *InconsistencyError) Error() string {
+	return e.message
+}
+
+// InconsistencyErrorf formats an InconsistencyError.
+func InconsistencyErrorf(format string, a ...interface{}) error {
+	return &InconsistencyError{fmt.Sprintf(format, a...)}
+}
+
+// IsInconsistency returns true if the cause of specified error is an InconsistencyError.
+// Otherwise, the error is treated as transient unless IsPermanent, and audit could be retried.
+func IsInconsistency(err error) bool {
+	_, ok := errors.Cause(err).(*InconsistencyError)
+	return ok
+}
+
+// PermanentError represents a failure that retry never helps, e.g. undecodable event log,
+// which should be handled as an inconsistency instead of being retried forever.
+type PermanentError struct {
+	cause error
+}
+
+// Error implements the error interface.
+func (e *PermanentError) Error() string {
+	return e.cause.Error()
+}
+
+// Permanent marks the specified error as permanent.
+func Permanent(err error) error {
+	return &PermanentError{err}
+}
+
+// PermanentErrorf formats a PermanentError.
+func PermanentErrorf(format string, a ...interface{}) error {
+	return &PermanentError{fmt.Errorf(format, a...)}
+}
+
+// IsPermanent returns true if the cause of specified error is a PermanentError.
+func IsPermanent(err error) bool {
+	_, ok := errors.Cause(err).(*PermanentError)
+	return ok
+}
+
+// IsTransient returns true if the specified error is neither an inconsistency nor permanent,
+// so that audit could be retried.
+func IsTransient(err error) bool {
+	return !IsInconsistency(err) && !IsPermanent(err)
+}
+
+// EpochError annotates an audit error with the epoch that failed to audit, so that the epoch
+// could be marked as failed, and audit continues after it. It is transparent to errors.Cause.
+type EpochError struct {
+	Epoch *big.Int
+	cause error
+}
+
+// Error implements the error interface.
+func (e *EpochError) Error() string {
+	return e.cause.Error()
+}
+
+// Cause returns the underlying error.
+func (e *EpochError) Cause() error {
+	return e.cause
+}
+
+// WithEpoch annotates the specified error with the failed epoch. If err is nil, returns nil.
+func WithEpoch(err error, epoch *big.Int) error {
+	if err == nil {
+		return nil
+	}
+
+	return &EpochError{new(big.Int).Set(epoch), err}
+}
+
+// ErrorEpoch returns the failed epoch annotated by the outermost EpochError of the specified
+// error, or nil if not annotated.
+func ErrorEpoch(err error) *big.Int {
+	for err != nil {
+		if e, ok := err.(*EpochError); ok {
+			return e.Epoch
+		}
+
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+
+		err = cause.Cause()
+	}
+
+	return nil
+}
+
+// NextBackoff doubles the backoff to retry transient errors, up to MaxRetryBackoff.
+func NextBackoff(backoff time.Duration) time.Duration {
+	if backoff *= 2; backoff > MaxRetryBackoff {
+		return MaxRetryBackoff
+	}
+
+	return backoff
+}
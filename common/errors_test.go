@@ -0,0 +1,73 @@
+package common
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+func TestErrorClasses(t *testing.T) {
+	cases := []struct {
+		name          string
+		err           error
+		inconsistency bool
+		permanent     bool
+	}{
+		{"rpc", errors.New("connection refused"), false, false},
+		{"inconsistency", InconsistencyErrorf("inconsistent balance"), true, false},
+		{"wrapped inconsistency", pkgerrors.WithMessage(InconsistencyErrorf("inconsistent balance"), "epoch 1"), true, false},
+		{"permanent", PermanentErrorf("unknown event hash"), false, true},
+		{"wrapped permanent", pkgerrors.WithMessage(Permanent(errors.New("bad data")), "failed to decode"), false, true},
+		{"too many logs", pkgerrors.WithMessagef(ErrTooManyLogs, "epoch %v", 1), false, true},
+	}
+
+	for _, c := range cases {
+		if got := IsInconsistency(c.err); got != c.inconsistency {
+			t.Errorf("%v: IsInconsistency = %v, want %v", c.name, got, c.inconsistency)
+		}
+
+		if got := IsPermanent(c.err); got != c.permanent {
+			t.Errorf("%v: IsPermanent = %v, want %v", c.name, got, c.permanent)
+		}
+
+		if got := IsTransient(c.err); got != (!c.inconsistency && !c.permanent) {
+			t.Errorf("%v: IsTransient = %v", c.name, got)
+		}
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	if got := NextBackoff(MinRetryBackoff); got != 2*MinRetryBackoff {
+		t.Errorf("NextBackoff(%v) = %v", MinRetryBackoff, got)
+	}
+
+	if got := NextBackoff(MaxRetryBackoff); got != MaxRetryBackoff {
+		t.Errorf("NextBackoff(%v) = %v", MaxRetryBackoff, got)
+	}
+}
+
+func TestErrorEpoch(t *testing.T) {
+	if WithEpoch(nil, big.NewInt(1)) != nil {
+		t.Fatal("nil error annotated")
+	}
+
+	if epoch := ErrorEpoch(errors.New("rpc")); epoch != nil {
+		t.Fatalf("epoch = %v", epoch)
+	}
+
+	err := pkgerrors.WithMessage(WithEpoch(InconsistencyErrorf("inconsistent balance"), big.NewInt(10)), "failed to audit")
+	if epoch := ErrorEpoch(err); epoch == nil || epoch.Int64() != 10 {
+		t.Fatalf("epoch = %v", epoch)
+	}
+
+	if !IsInconsistency(err) || err.Error() != "failed to audit: inconsistent balance" {
+		t.Fatalf("cause changed: %v", err)
+	}
+
+	// outermost epoch is preferred
+	if epoch := ErrorEpoch(WithEpoch(err, big.NewInt(9))); epoch.Int64() != 9 {
+		t.Fatalf("epoch = %v", epoch)
+	}
+}
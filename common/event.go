@@ -0,0 +1,151 @@
+package common
+
+import (
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/Conflux-Chain/go-conflux-sdk/types"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	ethTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+)
+
+// EventDecoder decodes event logs according to the contract ABI.
+type EventDecoder struct {
+	abi abi.ABI
+}
+
+// Event represents a decoded event log.
+type Event struct {
+	Name   string                 // event name in ABI
+	Args   map[string]interface{} // event arguments, key is argument name
+	Log    *types.Log             // raw event log on Conflux
+	EthLog *ethTypes.Log          // raw event log on Ethereum
+}
+
+// MustNewEventDecoder creates an instance of EventDecoder with the specified ABI file.
+func MustNewEventDecoder(abiPath string) *EventDecoder {
+	file, err := os.Open(abiPath)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	parsed, err := abi.JSON(file)
+	if err != nil {
+		panic(err)
+	}
+
+	return &EventDecoder{parsed}
+}
+
+// EventHash returns the topic hash of specified event, or empty hash if event not found.
+func (d *EventDecoder) EventHash(name string) types.Hash {
+	event, ok := d.abi.Events[name]
+	if !ok {
+		return ""
+	}
+
+	return types.Hash(event.ID.Hex())
+}
+
+// Decode decodes the event log, and returns a PermanentError if the event is not declared in ABI
+// or malformed.
+func (d *EventDecoder) Decode(log *types.Log) (*Event, error) {
+	topics := make([]common.Hash, 0, len(log.Topics))
+	for _, topic := range log.Topics {
+		topics = append(topics, *topic.ToCommonHash())
+	}
+
+	event, err := d.decode(topics, log.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	event.Log = log
+
+	return event, nil
+}
+
+// DecodeEthLog decodes the event log on Ethereum, and returns error if the event is not declared in ABI.
+func (d *EventDecoder) DecodeEthLog(log *ethTypes.Log) (*Event, error) {
+	event, err := d.decode(log.Topics, log.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	event.EthLog = log
+
+	return event, nil
+}
+
+func (d *EventDecoder) decode(topics []common.Hash, data []byte) (*Event, error) {
+	if len(topics) == 0 {
+		return nil, PermanentErrorf("no topic in event log")
+	}
+
+	event, err := d.abi.EventByID(topics[0])
+	if err != nil {
+		return nil, PermanentErrorf("unknown event hash %v", topics[0].Hex())
+	}
+
+	result := Event{
+		Name: event.Name,
+		Args: make(map[string]interface{}),
+	}
+
+	if nonIndexed := event.Inputs.NonIndexed(); len(nonIndexed) > 0 {
+		if err = nonIndexed.UnpackIntoMap(result.Args, data); err != nil {
+			return nil, errors.WithMessagef(Permanent(err), "failed to unpack data of event %v", event.Name)
+		}
+	}
+
+	var indexed abi.Arguments
+	for _, input := range event.Inputs {
+		if input.Indexed {
+			indexed = append(indexed, input)
+		}
+	}
+
+	if err = abi.ParseTopicsIntoMap(result.Args, indexed, topics[1:]); err != nil {
+		return nil, errors.WithMessagef(Permanent(err), "failed to parse topics of event %v", event.Name)
+	}
+
+	return &result, nil
+}
+
+// Address returns the argument of address type in lower case hex format, or empty string if
+// argument not found.
+func (e *Event) Address(arg string) string {
+	addr, ok := e.Args[arg].(common.Address)
+	if !ok {
+		return ""
+	}
+
+	return strings.ToLower(addr.Hex())
+}
+
+// BigInt returns the argument of uint256 type, or nil if argument not found.
+func (e *Event) BigInt(arg string) *big.Int {
+	value, ok := e.Args[arg].(*big.Int)
+	if !ok {
+		return nil
+	}
+
+	return value
+}
+
+// TxHash returns the transaction hash of event log.
+func (e *Event) TxHash() string {
+	if e.EthLog != nil {
+		return e.EthLog.TxHash.Hex()
+	}
+
+	if e.Log == nil || e.Log.TransactionHash == nil {
+		return ""
+	}
+
+	return e.Log.TransactionHash.String()
+}
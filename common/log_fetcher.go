@@ -0,0 +1,160 @@
+package common
+
+import (
+	"math/big"
+
+	sdk "github.com/Conflux-Chain/go-conflux-sdk"
+	"github.com/Conflux-Chain/go-conflux-sdk/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// MaxEpochsPerLogQuery is the maximum number of epochs to poll event logs in one RPC.
+var MaxEpochsPerLogQuery uint64 = 100
+
+// MaxLogsPerQuery is the maximum number of event logs that full node returns in one RPC.
+var MaxLogsPerQuery = 10000
+
+// ErrTooManyLogs is returned if the number of event logs in a single epoch reaches the limit of
+// full node, in which case the polled logs may be truncated.
+var ErrTooManyLogs = PermanentErrorf("number of event logs reaches the limit of full node")
+
+// EpochLogFetcher polls event logs for a window of epochs in one RPC, and serves them epoch
+// by epoch. The window size adapts to the log limits of full node: it is halved if RPC failed
+// or too many logs returned, and doubled after a successful RPC.
+type EpochLogFetcher struct {
+	cfx       *sdk.Client
+	addresses []types.Address
+	topics    [][]types.Hash
+	window    uint64
+
+	cacheFrom *big.Int               // first epoch of cached logs
+	cacheTo   *big.Int               // last epoch of cached logs
+	cache     map[uint64][]types.Log // key is epoch number
+	logger    logrus.FieldLogger
+}
+
+// NewEpochLogFetcher creates an instance of EpochLogFetcher to poll event logs of specified
+// contract addresses and topics.
+func NewEpochLogFetcher(cfx *sdk.Client, addresses []types.Address, topics [][]types.Hash) *EpochLogFetcher {
+	window := MaxEpochsPerLogQuery
+	if window == 0 {
+		window = 1
+	}
+
+	return &EpochLogFetcher{
+		cfx:       cfx,
+		addresses: addresses,
+		topics:    topics,
+		window:    window,
+		cache:     make(map[uint64][]types.Log),
+		logger:    NewLogger("logfetcher"),
+	}
+}
+
+// SetAddresses changes the contract addresses to poll event logs, and drops the cached logs.
+func (f *EpochLogFetcher) SetAddresses(addresses []types.Address) {
+	f.addresses = addresses
+	f.Reset()
+}
+
+// Reset drops the cached logs, e.g. pivot chain switched.
+func (f *EpochLogFetcher) Reset() {
+	f.cacheFrom = nil
+	f.cacheTo = nil
+	f.cache = make(map[uint64][]types.Log)
+}
+
+// GetLogs returns the event logs of specified epoch. If not cached, it polls event logs
+// since the specified epoch for a window of confirmed epochs.
+func (f *EpochLogFetcher) GetLogs(epoch *big.Int) ([]types.Log, error) {
+	if f.cacheFrom != nil && epoch.Cmp(f.cacheFrom) >= 0 && epoch.Cmp(f.cacheTo) <= 0 {
+		return f.cache[epoch.Uint64()], nil
+	}
+
+	latest, err := f.cfx.GetEpochNumber(types.EpochLatestState)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to get latest state epoch number")
+	}
+
+	// only poll event logs of confirmed epochs
+	maxEpoch := new(big.Int).Sub(latest.ToInt(), NumEpochsConfirmed)
+
+	for {
+		epochTo := new(big.Int).Add(epoch, new(big.Int).SetUint64(f.window-1))
+		if epochTo.Cmp(maxEpoch) > 0 {
+			epochTo = maxEpoch
+		}
+
+		if epochTo.Cmp(epoch) < 0 {
+			epochTo = epoch
+		}
+
+		logs, err := f.cfx.GetLogs(types.LogFilter{
+			FromEpoch: types.NewEpochNumberBig(epoch),
+			ToEpoch:   types.NewEpochNumberBig(epochTo),
+			Address:   f.addresses,
+			Topics:    f.topics,
+		})
+
+		if err == nil && len(logs) < MaxLogsPerQuery {
+			if err = f.fill(epoch, epochTo, logs); err != nil {
+				return nil, err
+			}
+
+			return f.cache[epoch.Uint64()], nil
+		}
+
+		if f.window == 1 {
+			if err != nil {
+				return nil, errors.WithMessagef(err, "failed to poll event logs from full node for epoch %v", epoch)
+			}
+
+			// logs may be truncated by full node, never audit on partial logs
+			return nil, errors.WithMessagef(ErrTooManyLogs, "%v logs polled for epoch %v, raise --log-limit if full node allows", len(logs), epoch)
+		}
+
+		f.window = (f.window + 1) / 2
+
+		f.logger.WithFields(logrus.Fields{
+			"epochFrom": epoch,
+			"epochTo":   epochTo,
+			"logs":      len(logs),
+			"window":    f.window,
+		}).WithError(err).Debug("shrink window to poll event logs")
+	}
+}
+
+// fill splits the polled logs by epoch number into cache, and enlarges the window. Nothing is
+// cached if any log has no epoch number.
+func (f *EpochLogFetcher) fill(epochFrom, epochTo *big.Int, logs []types.Log) error {
+	cache := make(map[uint64][]types.Log)
+	for _, log := range logs {
+		if log.EpochNumber == nil {
+			return errors.Errorf("epoch number of event log is nil, epochFrom = %v, epochTo = %v", epochFrom, epochTo)
+		}
+
+		epoch := log.EpochNumber.ToInt().Uint64()
+		cache[epoch] = append(cache[epoch], log)
+	}
+
+	f.cacheFrom = new(big.Int).Set(epochFrom)
+	f.cacheTo = new(big.Int).Set(epochTo)
+	f.cache = cache
+
+	if f.window *= 2; f.window > MaxEpochsPerLogQuery {
+		f.window = MaxEpochsPerLogQuery
+	}
+
+	if f.window == 0 {
+		f.window = 1
+	}
+
+	f.logger.WithFields(logrus.Fields{
+		"epochFrom": epochFrom,
+		"epochTo":   epochTo,
+		"logs":      len(logs),
+	}).Trace("succeed to poll event logs")
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package common
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/Conflux-Chain/go-conflux-sdk/types"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestEpochLogFetcherFill(t *testing.T) {
+	f := NewEpochLogFetcher(nil, nil, nil)
+
+	logs := []types.Log{
+		{EpochNumber: (*hexutil.Big)(big.NewInt(10))},
+		{EpochNumber: (*hexutil.Big)(big.NewInt(12))},
+		{EpochNumber: (*hexutil.Big)(big.NewInt(12))},
+	}
+
+	if err := f.fill(big.NewInt(10), big.NewInt(12), logs); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.cache[10]) != 1 || len(f.cache[11]) != 0 || len(f.cache[12]) != 2 {
+		t.Fatalf("cache = %v", f.cache)
+	}
+
+	// nothing cached if epoch number missing
+	logs = append(logs, types.Log{})
+	if err := f.fill(big.NewInt(13), big.NewInt(20), logs); err == nil {
+		t.Fatal("error expected")
+	}
+
+	if f.cacheFrom.Int64() != 10 || f.cacheTo.Int64() != 12 || len(f.cache[12]) != 2 {
+		t.Fatalf("cache changed, from = %v, to = %v", f.cacheFrom, f.cacheTo)
+	}
+}
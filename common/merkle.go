@@ -0,0 +1,162 @@
+package common
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Prefixes to distinguish leaf and internal nodes in merkle tree.
+var (
+	merkleLeafPrefix = []byte{0}
+	merkleNodePrefix = []byte{1}
+)
+
+// MerkleTree is a binary merkle tree over the (account, balance) pairs sorted by account.
+// Accounts with zero balance are ignored, so that the root only depends on the balances.
+//
+// Leaf node is keccak256(0x00 || address || uint256(balance)), and internal node is
+// keccak256(0x01 || left || right). The last node of a level with odd number of nodes
+// is promoted to the upper level directly.
+type MerkleTree struct {
+	accounts []string
+	balances []*big.Int
+	levels   [][]common.Hash // levels[0] are leaves, and the last level is root
+}
+
+// MerkleProofNode is a sibling node in merkle proof.
+type MerkleProofNode struct {
+	Hash common.Hash `json:"hash"`
+	Left bool        `json:"left"` // whether the sibling is on the left side
+}
+
+// MerkleProof proves that an account with balance is included in merkle tree.
+type MerkleProof struct {
+	Account  string            `json:"account"`
+	Balance  *big.Int          `json:"balance"`
+	Siblings []MerkleProofNode `json:"siblings"`
+	Root     common.Hash       `json:"root"`
+}
+
+// NewMerkleTree builds a merkle tree for the specified account balances. Returns error if any
+// balance is negative or overflows uint256, which cannot be encoded in leaf node.
+func NewMerkleTree(ab *AccountBalances) (*MerkleTree, error) {
+	tree := MerkleTree{}
+
+	for account, balance := range ab.Map() {
+		if !isUint256(balance) {
+			return nil, fmt.Errorf("invalid balance %v of account %v", balance, account)
+		}
+
+		if balance.Sign() != 0 {
+			tree.accounts = append(tree.accounts, strings.ToLower(account))
+		}
+	}
+
+	sort.Strings(tree.accounts)
+
+	leaves := make([]common.Hash, 0, len(tree.accounts))
+	for _, account := range tree.accounts {
+		balance := ab.Get(account)
+		tree.balances = append(tree.balances, balance)
+		leaves = append(leaves, merkleLeaf(account, balance))
+	}
+
+	tree.levels = append(tree.levels, leaves)
+
+	for level := leaves; len(level) > 1; {
+		var upper []common.Hash
+
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				upper = append(upper, merkleNode(level[i], level[i+1]))
+			} else {
+				upper = append(upper, level[i])
+			}
+		}
+
+		tree.levels = append(tree.levels, upper)
+		level = upper
+	}
+
+	return &tree, nil
+}
+
+// Root returns the merkle root, or zero hash if there is no account.
+func (tree *MerkleTree) Root() common.Hash {
+	top := tree.levels[len(tree.levels)-1]
+	if len(top) == 0 {
+		return common.Hash{}
+	}
+
+	return top[0]
+}
+
+// Size returns the number of accounts in merkle tree.
+func (tree *MerkleTree) Size() int {
+	return len(tree.accounts)
+}
+
+// Proof returns the inclusion proof of specified account.
+func (tree *MerkleTree) Proof(account string) (*MerkleProof, error) {
+	account = strings.ToLower(account)
+
+	index := sort.SearchStrings(tree.accounts, account)
+	if index >= len(tree.accounts) || tree.accounts[index] != account {
+		return nil, fmt.Errorf("account %v not found in merkle tree", account)
+	}
+
+	proof := MerkleProof{
+		Account: account,
+		Balance: tree.balances[index],
+		Root:    tree.Root(),
+	}
+
+	for _, level := range tree.levels[:len(tree.levels)-1] {
+		if index%2 == 1 {
+			proof.Siblings = append(proof.Siblings, MerkleProofNode{level[index-1], true})
+		} else if index+1 < len(level) {
+			proof.Siblings = append(proof.Siblings, MerkleProofNode{level[index+1], false})
+		}
+
+		index /= 2
+	}
+
+	return &proof, nil
+}
+
+// Verify checks whether the proof matches the merkle root in proof.
+func (proof *MerkleProof) Verify() bool {
+	if proof.Balance == nil || !isUint256(proof.Balance) {
+		return false
+	}
+
+	hash := merkleLeaf(proof.Account, proof.Balance)
+
+	for _, sibling := range proof.Siblings {
+		if sibling.Left {
+			hash = merkleNode(sibling.Hash, hash)
+		} else {
+			hash = merkleNode(hash, sibling.Hash)
+		}
+	}
+
+	return hash == proof.Root
+}
+
+func isUint256(value *big.Int) bool {
+	return value.Sign() >= 0 && value.BitLen() <= 256
+}
+
+func merkleLeaf(account string, balance *big.Int) common.Hash {
+	return crypto.Keccak256Hash(merkleLeafPrefix, common.HexToAddress(account).Bytes(), math.U256Bytes(new(big.Int).Set(balance)))
+}
+
+func merkleNode(left, right common.Hash) common.Hash {
+	return crypto.Keccak256Hash(merkleNodePrefix, left.Bytes(), right.Bytes())
+}
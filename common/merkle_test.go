@@ -0,0 +1,103 @@
+package common
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	ethCommon "github.com/ethereum/go-ethereum/common"
+)
+
+func newTestBalances(n int) *AccountBalances {
+	ab := NewAccountBalances()
+	for i := 1; i <= n; i++ {
+		ab.Add(fmt.Sprintf("0x%040x", i), big.NewInt(int64(i*100)))
+	}
+	return ab
+}
+
+func TestMerkleProofRoundTrip(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 4, 5, 7, 8, 9, 16, 17} {
+		ab := newTestBalances(n)
+		tree, err := NewMerkleTree(ab)
+		if err != nil {
+			t.Fatalf("n = %v: %v", n, err)
+		}
+
+		if tree.Size() != n {
+			t.Fatalf("n = %v: size = %v", n, tree.Size())
+		}
+
+		for account, balance := range ab.Map() {
+			proof, err := tree.Proof(account)
+			if err != nil {
+				t.Fatalf("n = %v: failed to prove %v: %v", n, account, err)
+			}
+
+			if proof.Root != tree.Root() || proof.Balance.Cmp(balance) != 0 {
+				t.Fatalf("n = %v: invalid proof of %v", n, account)
+			}
+
+			if !proof.Verify() {
+				t.Fatalf("n = %v: failed to verify proof of %v", n, account)
+			}
+
+			proof.Balance = new(big.Int).Add(balance, Big1)
+			if proof.Verify() {
+				t.Fatalf("n = %v: tampered proof of %v verified", n, account)
+			}
+		}
+	}
+}
+
+func TestMerkleRoot(t *testing.T) {
+	empty, err := NewMerkleTree(NewAccountBalances())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if empty.Size() != 0 || empty.Root() != (ethCommon.Hash{}) {
+		t.Fatal("empty tree should have zero root")
+	}
+
+	// zero balances are ignored
+	ab := newTestBalances(3)
+	withZero := newTestBalances(3)
+	withZero.Add("0x00000000000000000000000000000000000000ff", big.NewInt(0))
+
+	tree1, _ := NewMerkleTree(ab)
+	tree2, _ := NewMerkleTree(withZero)
+	if tree1.Root() != tree2.Root() {
+		t.Fatal("root changed by zero balance")
+	}
+
+	// root depends on balances
+	ab.Add("0x0000000000000000000000000000000000000001", big.NewInt(1))
+	tree3, _ := NewMerkleTree(ab)
+	if tree1.Root() == tree3.Root() {
+		t.Fatal("root not changed by balance")
+	}
+
+	if _, err = tree1.Proof("0x00000000000000000000000000000000000000ff"); err == nil {
+		t.Fatal("proof of account not in tree")
+	}
+}
+
+func TestMerkleTreeInvalidBalance(t *testing.T) {
+	negative := newTestBalances(2)
+	negative.Add("0x0000000000000000000000000000000000000001", big.NewInt(-1000))
+	if _, err := NewMerkleTree(negative); err == nil {
+		t.Fatal("negative balance accepted")
+	}
+
+	overflow := newTestBalances(2)
+	overflow.Add("0x0000000000000000000000000000000000000001", new(big.Int).Lsh(Big1, 256))
+	if _, err := NewMerkleTree(overflow); err == nil {
+		t.Fatal("balance overflows uint256 accepted")
+	}
+
+	proof := MerkleProof{Account: "0x0000000000000000000000000000000000000001", Balance: big.NewInt(-1)}
+	if proof.Verify() {
+		t.Fatal("proof with negative balance verified")
+	}
+}
@@ -0,0 +1,195 @@
+package common
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	sdk "github.com/Conflux-Chain/go-conflux-sdk"
+	"github.com/Conflux-Chain/go-conflux-sdk/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// PivotCheckInterval is the minimum interval to check the recorded pivot blocks against pivot chain.
+var PivotCheckInterval = 30 * time.Second
+
+// Reorg represents a pivot chain reorg that changed the pivot blocks of audited epochs.
+type Reorg struct {
+	Epoch *big.Int // the earliest audited epoch whose pivot block changed
+	Depth uint64   // number of audited epochs whose pivot block changed
+}
+
+// String implements the fmt.Stringer interface.
+func (r *Reorg) String() string {
+	return fmt.Sprintf("reorg detected at epoch %v, depth %v", r.Epoch, r.Depth)
+}
+
+// PivotTracker records the pivot block hash of audited epochs, and checks them against the pivot
+// chain periodically, so as to detect the reorg of epochs that treated as confirmed.
+//
+// Note, the pivot block of an epoch changes if and only if the pivot blocks of all later epochs
+// change, so only the latest recorded epoch is checked generally.
+type PivotTracker struct {
+	cfx       *sdk.Client
+	epochs    []uint64              // recorded epochs in ascending order
+	hashes    map[uint64]types.Hash // key is epoch number
+	lastCheck time.Time
+	logger    logrus.FieldLogger
+
+	getPivotHash func(epoch uint64) (types.Hash, error) // replaceable in test
+}
+
+// NewPivotTracker creates an instance of PivotTracker for the specified module.
+func NewPivotTracker(cfx *sdk.Client, module string) *PivotTracker {
+	t := PivotTracker{
+		cfx:    cfx,
+		hashes: make(map[uint64]types.Hash),
+		logger: NewLogger("pivot", map[string]interface{}{"auditor": module}),
+	}
+
+	t.getPivotHash = t.queryPivotHash
+
+	return &t
+}
+
+// queryPivotHash queries the pivot block hash of epoch from full node.
+func (t *PivotTracker) queryPivotHash(epoch uint64) (types.Hash, error) {
+	block, err := t.cfx.GetBlockSummaryByEpoch(types.NewEpochNumberUint64(epoch))
+	if err != nil {
+		return "", errors.WithMessagef(err, "failed to get pivot block of epoch %v", epoch)
+	}
+
+	return block.Hash, nil
+}
+
+// Record records the pivot block hash of audited epoch.
+func (t *PivotTracker) Record(epoch *big.Int) error {
+	epochNum := epoch.Uint64()
+
+	hash, err := t.getPivotHash(epochNum)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := t.hashes[epochNum]; !ok {
+		t.epochs = append(t.epochs, epochNum)
+		if n := len(t.epochs); n > 1 && t.epochs[n-2] > epochNum {
+			sort.Slice(t.epochs, func(i, j int) bool { return t.epochs[i] < t.epochs[j] })
+		}
+	}
+
+	t.hashes[epochNum] = hash
+
+	return nil
+}
+
+// Check checks the recorded pivot blocks against pivot chain if PivotCheckInterval elapsed
+// since last check, and returns the reorg if any recorded pivot block changed. The records
+// of reorged epochs are removed, so they should be audited and recorded again.
+func (t *PivotTracker) Check() (*Reorg, error) {
+	if len(t.epochs) == 0 || time.Since(t.lastCheck) < PivotCheckInterval {
+		return nil, nil
+	}
+
+	// Records are pruned by the latest checkpoint instead of latest_confirmed, because epochs
+	// are audited once confirmed, and the reorg of confirmed epochs is exactly what to detect.
+	// Only epochs before the latest checkpoint will never be reverted.
+	checkpoint, err := t.cfx.GetEpochNumber(types.EpochLatestCheckpoint)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to get latest checkpoint epoch number")
+	}
+
+	changed, err := t.changed(len(t.epochs) - 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var reorg *Reorg
+	if changed {
+		// binary search the earliest epoch whose pivot block changed
+		index, err := t.searchEarliestChanged()
+		if err != nil {
+			return nil, err
+		}
+
+		// pivot chain switched back during search
+		if index == len(t.epochs) {
+			return nil, nil
+		}
+
+		reorg = &Reorg{
+			Epoch: new(big.Int).SetUint64(t.epochs[index]),
+			Depth: t.epochs[len(t.epochs)-1] - t.epochs[index] + 1,
+		}
+
+		for _, epoch := range t.epochs[index:] {
+			delete(t.hashes, epoch)
+		}
+
+		t.epochs = t.epochs[:index]
+
+		t.logger.WithFields(logrus.Fields{
+			"epoch": reorg.Epoch,
+			"depth": reorg.Depth,
+		}).Warn("pivot chain reorg detected")
+	}
+
+	t.prune(checkpoint.ToInt().Uint64())
+	t.lastCheck = time.Now()
+
+	return reorg, nil
+}
+
+// changed checks whether the pivot block of recorded epoch at the specified index changed.
+func (t *PivotTracker) changed(index int) (bool, error) {
+	epoch := t.epochs[index]
+
+	hash, err := t.getPivotHash(epoch)
+	if err != nil {
+		return false, err
+	}
+
+	return hash != t.hashes[epoch], nil
+}
+
+// searchEarliestChanged binary searches the index of earliest recorded epoch whose pivot block
+// changed, or returns the number of recorded epochs if none changed.
+func (t *PivotTracker) searchEarliestChanged() (int, error) {
+	var searchErr error
+
+	index := sort.Search(len(t.epochs), func(i int) bool {
+		if searchErr != nil {
+			return true
+		}
+
+		changed, err := t.changed(i)
+		if err != nil {
+			searchErr = err
+			return true
+		}
+
+		return changed
+	})
+
+	return index, searchErr
+}
+
+// prune removes the records of epochs before the specified finalized epoch, except the latest one.
+func (t *PivotTracker) prune(finalized uint64) {
+	if len(t.epochs) == 0 {
+		return
+	}
+
+	index := sort.Search(len(t.epochs), func(i int) bool { return t.epochs[i] >= finalized })
+	if index >= len(t.epochs) {
+		index = len(t.epochs) - 1
+	}
+
+	for _, epoch := range t.epochs[:index] {
+		delete(t.hashes, epoch)
+	}
+
+	t.epochs = t.epochs[index:]
+}
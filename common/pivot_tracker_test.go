@@ -0,0 +1,126 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/Conflux-Chain/go-conflux-sdk/types"
+)
+
+// fakePivotChain serves pivot block hashes, which change since the reorg epoch.
+type fakePivotChain struct {
+	reorgEpoch uint64 // zero means no reorg
+	failEpoch  uint64 // zero means never fail
+	queried    int
+}
+
+func (c *fakePivotChain) getPivotHash(epoch uint64) (types.Hash, error) {
+	c.queried++
+
+	if c.failEpoch != 0 && epoch == c.failEpoch {
+		return "", errors.New("rpc error")
+	}
+
+	if c.reorgEpoch != 0 && epoch >= c.reorgEpoch {
+		return types.Hash(fmt.Sprintf("0xreorged%v", epoch)), nil
+	}
+
+	return types.Hash(fmt.Sprintf("0x%v", epoch)), nil
+}
+
+func newTestPivotTracker(t *testing.T, chain *fakePivotChain, epochs ...uint64) *PivotTracker {
+	tracker := NewPivotTracker(nil, "test")
+	tracker.getPivotHash = chain.getPivotHash
+
+	for _, epoch := range epochs {
+		if err := tracker.Record(new(big.Int).SetUint64(epoch)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	chain.queried = 0
+
+	return tracker
+}
+
+func TestPivotTrackerSearchEarliestChanged(t *testing.T) {
+	epochs := []uint64{10, 20, 30, 40, 50, 60, 70, 80}
+
+	for _, c := range []struct {
+		reorgEpoch uint64
+		index      int
+	}{
+		{0, 8},   // no reorg
+		{10, 0},  // all recorded epochs reorged
+		{5, 0},   // reorg before recorded epochs
+		{45, 4},  // reorg between recorded epochs
+		{50, 4},  // reorg at recorded epoch
+		{80, 7},  // only the latest reorged
+		{90, 8},  // reorg after recorded epochs
+		{11, 1},  // reorg right after the earliest
+		{79, 7},  // reorg right before the latest
+		{31, 3},  // reorg in the middle
+		{100, 8}, // no recorded epoch reorged
+	} {
+		chain := &fakePivotChain{}
+		tracker := newTestPivotTracker(t, chain, epochs...)
+		chain.reorgEpoch = c.reorgEpoch
+
+		index, err := tracker.searchEarliestChanged()
+		if err != nil {
+			t.Fatalf("reorg at %v: %v", c.reorgEpoch, err)
+		}
+
+		if index != c.index {
+			t.Errorf("reorg at %v: index = %v, want %v", c.reorgEpoch, index, c.index)
+		}
+
+		// binary search queries at most log2(n) + 1 epochs
+		if chain.queried > 4 {
+			t.Errorf("reorg at %v: queried %v epochs", c.reorgEpoch, chain.queried)
+		}
+	}
+}
+
+func TestPivotTrackerSearchEarliestChangedError(t *testing.T) {
+	chain := &fakePivotChain{}
+	tracker := newTestPivotTracker(t, chain, 10, 20, 30, 40)
+	chain.reorgEpoch = 30
+	chain.failEpoch = 30
+
+	if _, err := tracker.searchEarliestChanged(); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestPivotTrackerRecordOutOfOrder(t *testing.T) {
+	chain := &fakePivotChain{}
+	tracker := newTestPivotTracker(t, chain, 30, 10, 20, 10)
+
+	if fmt.Sprint(tracker.epochs) != "[10 20 30]" {
+		t.Errorf("epochs = %v", tracker.epochs)
+	}
+
+	chain.reorgEpoch = 20
+	if index, err := tracker.searchEarliestChanged(); err != nil || index != 1 {
+		t.Errorf("index = %v, err = %v", index, err)
+	}
+}
+
+func TestPivotTrackerPrune(t *testing.T) {
+	chain := &fakePivotChain{}
+	tracker := newTestPivotTracker(t, chain, 10, 20, 30)
+
+	tracker.prune(25)
+	if fmt.Sprint(tracker.epochs) != "[30]" || len(tracker.hashes) != 1 {
+		t.Errorf("epochs = %v, hashes = %v", tracker.epochs, tracker.hashes)
+	}
+
+	// the latest one is always kept
+	tracker.prune(100)
+	if fmt.Sprint(tracker.epochs) != "[30]" {
+		t.Errorf("epochs = %v", tracker.epochs)
+	}
+}
@@ -0,0 +1,135 @@
+package common
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Conflux-Chain/go-conflux-sdk/rpc"
+	"github.com/Conflux-Chain/go-conflux-sdk/utils"
+	"github.com/pkg/errors"
+)
+
+// RateLimiter is a token bucket limiter, which allows events up to rate per second with
+// bursts of at most burst events.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second, zero means unlimited
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates an instance of RateLimiter. Zero rate means unlimited.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	limiter := RateLimiter{}
+	limiter.SetLimit(rate, burst)
+	return &limiter
+}
+
+// SetLimit changes the rate and burst of limiter.
+func (l *RateLimiter) SetLimit(rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	l.rate = rate
+	l.burst = float64(burst)
+	l.tokens = l.burst
+	l.last = time.Now()
+}
+
+// Wait blocks until n tokens are available.
+func (l *RateLimiter) Wait(n int) {
+	for {
+		delay := l.reserve(float64(n))
+		if delay == 0 {
+			return
+		}
+
+		time.Sleep(delay)
+	}
+}
+
+// reserve takes n tokens if available, otherwise returns the duration to wait.
+func (l *RateLimiter) reserve(n float64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	l.last = now
+
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+
+	// allow to take more tokens than burst once the bucket is full
+	if l.tokens >= n || l.tokens >= l.burst {
+		l.tokens -= n
+		return 0
+	}
+
+	return time.Duration((n - l.tokens) / l.rate * float64(time.Second))
+}
+
+// RPCLimiter limits the RPC requests to full node of all CFX clients in process, which is
+// unlimited by default.
+var RPCLimiter = NewRateLimiter(0, 1)
+
+// rateLimitedRequester sends RPC requests to full node once allowed by RPCLimiter, and retries
+// for non JSON-RPC errors.
+type rateLimitedRequester struct {
+	inner      *rpc.Client
+	retryCount int
+	interval   time.Duration
+}
+
+func (r *rateLimitedRequester) Call(resultPtr interface{}, method string, args ...interface{}) error {
+	for attempts := 1; ; attempts++ {
+		RPCLimiter.Wait(1)
+
+		err := r.inner.Call(resultPtr, method, args...)
+		if err == nil || utils.IsRPCJSONError(err) {
+			return err
+		}
+
+		if attempts >= r.retryCount {
+			return errors.WithMessage(err, "rpc call timeout")
+		}
+
+		time.Sleep(r.interval)
+	}
+}
+
+func (r *rateLimitedRequester) BatchCall(b []rpc.BatchElem) error {
+	for attempts := 1; ; attempts++ {
+		RPCLimiter.Wait(len(b))
+
+		err := r.inner.BatchCall(b)
+		if err == nil {
+			return nil
+		}
+
+		if attempts >= r.retryCount {
+			return errors.WithMessage(err, "batch rpc call timeout")
+		}
+
+		time.Sleep(r.interval)
+	}
+}
+
+func (r *rateLimitedRequester) Subscribe(ctx context.Context, namespace string, channel interface{}, args ...interface{}) (*rpc.ClientSubscription, error) {
+	return r.inner.Subscribe(ctx, namespace, channel, args...)
+}
+
+func (r *rateLimitedRequester) Close() {
+	r.inner.Close()
+}
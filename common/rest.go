@@ -3,6 +3,7 @@ package common
 import (
 	//"fmt"
 	"encoding/json"
+	"errors"
 	"strconv"
 
 	"github.com/go-resty/resty/v2"
@@ -65,22 +66,32 @@ func NewClient(url string) *Client {
 	}
 }
 
-// GetAssets gets the all available assets.
+// GetAssets gets the all available assets, and panics if any error occurred.
 func (c *Client) GetAssets() []Asset {
+	assets, err := c.ListAssets()
+	if err != nil {
+		panic(err)
+	}
+
+	return assets
+}
+
+// ListAssets gets the all available assets.
+func (c *Client) ListAssets() ([]Asset, error) {
 	resp, err := c.client.R().Get(c.url + "/currencies/?secretkey=ConfluxFDSiof0j20fJFDHbSkgnkl5gkGDSKL")
 	//resp, err := c.client.R().Get(c.url + "/currencies")
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	if !resp.IsSuccess() {
-		panic("Status Code: " + strconv.Itoa(resp.StatusCode()))
+		return nil, errors.New("Status Code: " + strconv.Itoa(resp.StatusCode()))
 	}
 
 	var r = AssetResponse{}
 	err = json.Unmarshal(resp.Body(), &r)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	var validAssets []Asset
@@ -91,7 +102,7 @@ func (c *Client) GetAssets() []Asset {
 		}
 	}
 
-	return validAssets
+	return validAssets, nil
 }
 
 // GetBalance get the balance of the specified account.
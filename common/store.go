@@ -0,0 +1,166 @@
+package common
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Store is a key-value store based on leveldb, which persists values in JSON format.
+type Store struct {
+	db *leveldb.DB
+}
+
+// StoreBatch collects a set of updates to write into store atomically.
+type StoreBatch struct {
+	batch leveldb.Batch
+}
+
+// OpenStore opens or creates a store at the specified path.
+func OpenStore(path string) (*Store, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to open leveldb at %v", path)
+	}
+
+	return &Store{db}, nil
+}
+
+// Close closes the underlying leveldb.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Get unmarshals the value of specified key into value, and returns false if key not found.
+func (s *Store) Get(key string, value interface{}) (bool, error) {
+	data, err := s.db.Get([]byte(key), nil)
+	if err == leveldb.ErrNotFound {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, errors.WithMessagef(err, "failed to get value of key %v", key)
+	}
+
+	if err = json.Unmarshal(data, value); err != nil {
+		return false, errors.WithMessagef(err, "failed to unmarshal value of key %v", key)
+	}
+
+	return true, nil
+}
+
+// Put marshals the value in JSON format and saves it with specified key.
+func (s *Store) Put(key string, value interface{}) error {
+	batch := NewStoreBatch()
+	if err := batch.Put(key, value); err != nil {
+		return err
+	}
+
+	return s.Write(batch)
+}
+
+// Delete removes the specified key.
+func (s *Store) Delete(key string) error {
+	return s.db.Delete([]byte(key), nil)
+}
+
+// Keys returns all keys with the specified prefix.
+func (s *Store) Keys(prefix string) ([]string, error) {
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+
+	var keys []string
+	for iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+
+	return keys, iter.Error()
+}
+
+// KeysAfter returns all keys with the specified prefix that are greater than the specified key.
+func (s *Store) KeysAfter(prefix, key string) ([]string, error) {
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+
+	var keys []string
+	for ok := iter.Seek([]byte(key)); ok; ok = iter.Next() {
+		if string(iter.Key()) != key {
+			keys = append(keys, string(iter.Key()))
+		}
+	}
+
+	return keys, iter.Error()
+}
+
+// Floor unmarshals the value of the greatest key with specified prefix that is less than
+// or equal to the specified key, and returns the found key.
+func (s *Store) Floor(prefix, key string, value interface{}) (string, bool, error) {
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+
+	var found bool
+	if iter.Seek([]byte(key)) {
+		found = string(iter.Key()) == key || iter.Prev()
+	} else {
+		found = iter.Last()
+	}
+
+	if err := iter.Error(); err != nil {
+		return "", false, errors.WithMessagef(err, "failed to seek key %v", key)
+	}
+
+	if !found {
+		return "", false, nil
+	}
+
+	floorKey := string(iter.Key())
+	if err := json.Unmarshal(iter.Value(), value); err != nil {
+		return "", false, errors.WithMessagef(err, "failed to unmarshal value of key %v", floorKey)
+	}
+
+	return floorKey, true, nil
+}
+
+// Reset removes all keys with the specified prefix.
+func (s *Store) Reset(prefix string) error {
+	keys, err := s.Keys(prefix)
+	if err != nil {
+		return errors.WithMessage(err, "failed to iterate keys")
+	}
+
+	batch := NewStoreBatch()
+	for _, key := range keys {
+		batch.Delete(key)
+	}
+
+	return s.Write(batch)
+}
+
+// Write writes all updates in batch atomically.
+func (s *Store) Write(batch *StoreBatch) error {
+	return s.db.Write(&batch.batch, nil)
+}
+
+// NewStoreBatch creates an empty batch.
+func NewStoreBatch() *StoreBatch {
+	return &StoreBatch{}
+}
+
+// Put marshals the value in JSON format and adds it into batch.
+func (b *StoreBatch) Put(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return errors.WithMessagef(err, "failed to marshal value of key %v", key)
+	}
+
+	b.batch.Put([]byte(key), data)
+
+	return nil
+}
+
+// Delete adds a deletion of key into batch.
+func (b *StoreBatch) Delete(key string) {
+	b.batch.Delete([]byte(key))
+}
@@ -0,0 +1,47 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestStoreKeysAfter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, key := range []string{"root/BTC/10", "root/BTC/20", "root/BTC/30", "root/CFX/15"} {
+		if err = store.Put(key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range []struct {
+		key      string
+		expected []string
+	}{
+		{"root/BTC/10", []string{"root/BTC/20", "root/BTC/30"}},
+		{"root/BTC/15", []string{"root/BTC/20", "root/BTC/30"}},
+		{"root/BTC/30", nil},
+		{"root/BTC/05", []string{"root/BTC/10", "root/BTC/20", "root/BTC/30"}},
+	} {
+		keys, err := store.KeysAfter("root/BTC/", c.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(keys, c.expected) {
+			t.Fatalf("key = %v, expected = %v, actual = %v", c.key, c.expected, keys)
+		}
+	}
+}
@@ -2,22 +2,35 @@ package common
 
 import (
 	sdk "github.com/Conflux-Chain/go-conflux-sdk"
+	"github.com/Conflux-Chain/go-conflux-sdk/rpc"
+	"github.com/Conflux-Chain/go-conflux-sdk/types"
 	"github.com/open-dex/conflux-dex-audit/log"
 	"github.com/shopspring/decimal"
 	"github.com/sirupsen/logrus"
+	"math/big"
 	"time"
 )
 
-// MustNewCfx must creates an instance of CFX client.
+// MustNewCfx must creates an instance of CFX client, whose RPC requests are limited by RPCLimiter.
 func MustNewCfx(cfxURL string) *sdk.Client {
-	cfx, err := sdk.NewClient(cfxURL, sdk.ClientOption{
-		RetryCount:    20,
-		RetryInterval: 2 * time.Second,
+	rpcClient, err := rpc.Dial(cfxURL)
+	if err != nil {
+		log.Fatal("failed to dial to full node: %v", err)
+	}
+
+	cfx, err := sdk.NewClientWithRPCRequester(&rateLimitedRequester{
+		inner:      rpcClient,
+		retryCount: 20,
+		interval:   2 * time.Second,
 	})
 	if err != nil {
 		log.Fatal("failed to create CFX client: %v", err)
 	}
 
+	if _, err = cfx.GetNetworkID(); err != nil {
+		log.Fatal("failed to get network id: %v", err)
+	}
+
 	return cfx
 }
 
@@ -42,3 +55,28 @@ func NewLogger(module string, optionalFields ...map[string]interface{}) logrus.F
 func Mul(x, y decimal.Decimal) decimal.Decimal {
 	return x.Mul(y).Truncate(18)
 }
+
+// WaitForEpochConfirmed blocks until the specified epoch is confirmed, i.e. not later than
+// the latest state epoch minus NumEpochsConfirmed, and logs with the logger of caller.
+func WaitForEpochConfirmed(cfx *sdk.Client, epoch *big.Int, logger logrus.FieldLogger) {
+	for {
+		current, err := cfx.GetEpochNumber(types.EpochLatestState)
+		if err != nil {
+			logger.WithError(err).Warn("failed to get epoch number from full node")
+			time.Sleep(time.Second)
+			continue
+		}
+
+		confirmedEpoch := new(big.Int).Sub(current.ToInt(), NumEpochsConfirmed)
+		if epoch.Cmp(confirmedEpoch) <= 0 {
+			return
+		}
+
+		logger.WithFields(logrus.Fields{
+			"epoch":            epoch,
+			"epochConfirmed":   confirmedEpoch,
+			"epochLatestState": current,
+		}).Trace("epoch is not confirmed yet")
+		time.Sleep(time.Second)
+	}
+}
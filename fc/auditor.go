@@ -0,0 +1,340 @@
+package fc
+
+import (
+	"math/big"
+	"strings"
+
+	sdk "github.com/Conflux-Chain/go-conflux-sdk"
+	"github.com/Conflux-Chain/go-conflux-sdk/types"
+	"github.com/Conflux-Chain/go-conflux-sdk/types/cfxaddress"
+	"github.com/open-dex/conflux-dex-audit/common"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// pauseFlagMethods maps the pause flag to method in FC.
+var pauseFlagMethods = map[string]string{
+	"Transfer": "isTransferPaused",
+	"Burn":     "isBurnPaused",
+	"Migrate":  "isMigratePaused",
+}
+
+// BalanceAuditDetails represents the details of audit against FC balance.
+type BalanceAuditDetails struct {
+	TotalSupply       *big.Int                // total supply in FC
+	Cap               *big.Int                // cap of total supply in FC
+	BalanceOfContract *big.Int                // balance of FC contract itself
+	CirculationRatio  *big.Int                // circulation ratio in FC
+	AccountBalances   *common.AccountBalances // account balances in FC
+	LockedBalances    *common.AccountBalances // locked balances in personal pool
+	PauseFlags        map[string]bool         // key is Transfer, Burn or Migrate
+}
+
+// Auditor audits the FC contract.
+type Auditor struct {
+	Cfx *sdk.Client
+
+	fc         *common.Contract
+	decoder    *common.EventDecoder
+	logFetcher *common.EpochLogFetcher
+
+	lastAuditEpoch   *big.Int
+	lastAuditDetails *BalanceAuditDetails
+}
+
+// NewAuditor creates an instance of Auditor for FC contract at specified address.
+func NewAuditor(cfxURL, address string) *Auditor {
+	cfx := common.MustNewCfx(cfxURL)
+	addresses := []types.Address{cfxaddress.MustNewFromHex(address, common.GetNetworkId())}
+
+	return &Auditor{
+		Cfx:        cfx,
+		fc:         common.GetContract(cfx, common.FcABI, address),
+		decoder:    common.MustNewEventDecoder(common.FcABI),
+		logFetcher: common.NewEpochLogFetcher(cfx, addresses, nil),
+	}
+}
+
+// Close releases resources hold by FC auditor.
+func (auditor *Auditor) Close() {
+	auditor.Cfx.Close()
+}
+
+// AuditBalance audits the account balances of FC for the specified epoch, including:
+// 1) Sum(FC.balanceOf(account)) == FC.totalSupply()
+// 2) FC.totalSupply() <= FC.cap()
+// Besides, the pause flags are checked against the last audit.
+func (auditor *Auditor) AuditBalance(epochNum *big.Int) (*BalanceAuditDetails, error) {
+	logger := logger.WithField("epoch", epochNum)
+	epoch := types.NewEpochNumberBig(epochNum)
+
+	details, err := auditor.getContractState(epoch)
+	if err != nil {
+		return nil, err
+	}
+
+	details.AccountBalances = common.NewAccountBalances()
+	details.LockedBalances = common.NewAccountBalances()
+
+	total, err := auditor.fc.TotalAccount(epoch)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to get number of accounts in FC")
+	}
+
+	logger.WithField("total", total).Debug("succeed to query total accounts")
+
+	for offset := big.NewInt(0); offset.Cmp(total) < 0; {
+		accounts, err := auditor.fc.ListAccounts(offset, epoch)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "failed to list accounts since offset %v", offset)
+		}
+
+		for _, account := range accounts {
+			balance, locked, err := auditor.getAccountState(account, epoch)
+			if err != nil {
+				return nil, err
+			}
+
+			details.AccountBalances.Add(account, balance)
+			details.LockedBalances.Add(account, locked)
+		}
+
+		offset = new(big.Int).Add(offset, big.NewInt(int64(len(accounts))))
+	}
+
+	if err = details.check(); err != nil {
+		return nil, err
+	}
+
+	if auditor.lastAuditDetails != nil {
+		auditor.checkPauseFlags(epochNum, auditor.lastAuditDetails.PauseFlags, details.PauseFlags)
+	}
+
+	auditor.lastAuditEpoch = epochNum
+	auditor.lastAuditDetails = details
+
+	return details, nil
+}
+
+// AuditNextEpoch replays the Transfer and Lock events of FC for the next epoch of last
+// balance audit details, and checks the changed accounts on chain. Events are replayed
+// into a copy of the last audit details, which is updated only if all checks passed.
+func (auditor *Auditor) AuditNextEpoch() error {
+	if auditor.lastAuditDetails == nil {
+		return common.PermanentErrorf("no baseline yet")
+	}
+
+	epochNum := new(big.Int).Add(auditor.lastAuditEpoch, common.Big1)
+	epoch := types.NewEpochNumberBig(epochNum)
+
+	logs, err := auditor.logFetcher.GetLogs(epochNum)
+	if err != nil {
+		return errors.WithMessagef(err, "failed to poll event logs for epoch %v", epochNum)
+	}
+
+	details := &BalanceAuditDetails{
+		PauseFlags:      auditor.lastAuditDetails.PauseFlags,
+		AccountBalances: auditor.lastAuditDetails.AccountBalances.Copy(),
+		LockedBalances:  auditor.lastAuditDetails.LockedBalances.Copy(),
+	}
+	changed := make(map[string]bool)
+	locked := make(map[string]bool)
+	written := make(map[string]bool)
+
+	for i := range logs {
+		event, err := auditor.decoder.Decode(&logs[i])
+		if err != nil {
+			return errors.WithMessagef(err, "failed to decode event log, epoch = %v, log index = %v", epochNum, i)
+		}
+
+		if err = auditor.replay(epochNum, event, details, changed, locked, written); err != nil {
+			return errors.WithMessagef(err, "failed to replay event log, epoch = %v, log index = %v", epochNum, i)
+		}
+	}
+
+	// state written by admin during migration, so resync account from chain
+	for account := range written {
+		balance, lockedBalance, err := auditor.getAccountState(account, epoch)
+		if err != nil {
+			return err
+		}
+
+		details.AccountBalances.Add(account, new(big.Int).Sub(balance, details.AccountBalances.Get(account)))
+		details.LockedBalances.Add(account, new(big.Int).Sub(lockedBalance, details.LockedBalances.Get(account)))
+	}
+
+	state, err := auditor.getContractState(epoch)
+	if err != nil {
+		return err
+	}
+
+	auditor.checkPauseFlags(epochNum, details.PauseFlags, state.PauseFlags)
+
+	state.AccountBalances = details.AccountBalances
+	state.LockedBalances = details.LockedBalances
+	if err = state.check(); err != nil {
+		return errors.WithMessagef(err, "failed to audit FC for epoch %v", epochNum)
+	}
+
+	// check changed accounts on chain
+	for account := range changed {
+		balance, lockedBalance, err := auditor.getAccountState(account, epoch)
+		if err != nil {
+			return err
+		}
+
+		if audit := details.AccountBalances.Get(account); audit.Cmp(balance) != 0 {
+			return common.InconsistencyErrorf("inconsistent balance, audit = %v, actual = %v, epoch = %v, account = %v", audit, balance, epochNum, account)
+		}
+
+		if !locked[account] {
+			continue
+		}
+
+		if audit := details.LockedBalances.Get(account); audit.Cmp(lockedBalance) != 0 {
+			return common.InconsistencyErrorf("inconsistent locked balance, audit = %v, actual = %v, epoch = %v, account = %v", audit, lockedBalance, epochNum, account)
+		}
+	}
+
+	auditor.lastAuditEpoch = epochNum
+	auditor.lastAuditDetails = state
+
+	return nil
+}
+
+// replay updates the audit details with FC event, and collects accounts whose balance
+// or locked balance changed, and accounts whose state written directly.
+func (auditor *Auditor) replay(epochNum *big.Int, event *common.Event, details *BalanceAuditDetails, changed, locked, written map[string]bool) error {
+	switch event.Name {
+	case "Transfer":
+		from, to, value := event.Address("from"), event.Address("to"), event.BigInt("value")
+		if value == nil {
+			return common.InconsistencyErrorf("value not found in Transfer event, tx = %v", event.TxHash())
+		}
+
+		// transfer or burn
+		if from != common.ZeroAddress {
+			details.AccountBalances.Add(from, new(big.Int).Neg(value))
+			changed[from] = true
+		}
+
+		// transfer or mint
+		if to != common.ZeroAddress {
+			details.AccountBalances.Add(to, value)
+			changed[to] = true
+		}
+	case "Lock":
+		account, value := event.Address("account"), event.BigInt("value")
+		if value == nil {
+			return common.InconsistencyErrorf("value not found in Lock event, tx = %v", event.TxHash())
+		}
+
+		details.LockedBalances.Add(account, value)
+		changed[account] = true
+		locked[account] = true
+	case "Write":
+		written[event.Address("account")] = true
+
+		logger.WithFields(logrus.Fields{
+			"epoch": epochNum,
+			"args":  event.Args,
+			"tx":    event.TxHash(),
+		}).Info("account state written in FC")
+	case "TransferPaused", "TransferUnpaused", "BurnPaused", "BurnUnpaused", "MigratePaused", "MigrateUnpaused":
+		logger.WithFields(logrus.Fields{
+			"epoch":   epochNum,
+			"event":   event.Name,
+			"account": event.Address("account"),
+			"tx":      event.TxHash(),
+		}).Warn("pause flag changed in FC")
+	default:
+		// balance-neutral events, e.g. role changes
+		logger.WithFields(logrus.Fields{
+			"epoch": epochNum,
+			"event": event.Name,
+			"args":  event.Args,
+			"tx":    event.TxHash(),
+		}).Debug("balance-neutral event found in FC")
+	}
+
+	return nil
+}
+
+// checkPauseFlags alerts if any pause flag changed.
+func (auditor *Auditor) checkPauseFlags(epochNum *big.Int, before, after map[string]bool) {
+	for flag, paused := range after {
+		if before[flag] == paused {
+			continue
+		}
+
+		logger.WithFields(logrus.Fields{
+			"epoch":  epochNum,
+			"flag":   flag,
+			"paused": paused,
+		}).Warn("pause flag changed")
+
+		common.Alertf(module, "%v pause flag changed from %v to %v, epoch = %v", flag, before[flag], paused, epochNum)
+	}
+}
+
+// getContractState returns the total supply, cap and pause flags of FC.
+func (auditor *Auditor) getContractState(epoch *types.Epoch) (*BalanceAuditDetails, error) {
+	var details BalanceAuditDetails
+	var err error
+
+	if details.TotalSupply, err = auditor.fc.TotalSupply(epoch); err != nil {
+		return nil, errors.WithMessage(err, "failed to get total supply in FC")
+	}
+
+	if details.Cap, err = auditor.fc.Cap(epoch); err != nil {
+		return nil, errors.WithMessage(err, "failed to get cap in FC")
+	}
+
+	if details.BalanceOfContract, err = auditor.fc.BalanceOfContract(epoch); err != nil {
+		return nil, errors.WithMessage(err, "failed to get balance of contract in FC")
+	}
+
+	if details.CirculationRatio, err = auditor.fc.CirculationRatio(epoch); err != nil {
+		return nil, errors.WithMessage(err, "failed to get circulation ratio in FC")
+	}
+
+	details.PauseFlags = make(map[string]bool)
+	for flag, method := range pauseFlagMethods {
+		if details.PauseFlags[flag], err = auditor.fc.IsPaused(method, epoch); err != nil {
+			return nil, errors.WithMessagef(err, "failed to get %v pause flag in FC", flag)
+		}
+	}
+
+	return &details, nil
+}
+
+// getAccountState returns the balance and locked balance of account in FC.
+func (auditor *Auditor) getAccountState(account string, epoch *types.Epoch) (*big.Int, *big.Int, error) {
+	account = strings.ToLower(account)
+
+	balance, err := auditor.fc.BalanceOf(account, epoch)
+	if err != nil {
+		return nil, nil, errors.WithMessagef(err, "failed to get balance of account %v", account)
+	}
+
+	_, _, locked, err := auditor.fc.StateOf(account, epoch)
+	if err != nil {
+		return nil, nil, errors.WithMessagef(err, "failed to get state of account %v", account)
+	}
+
+	return balance, locked, nil
+}
+
+// check checks that Sum(balances) == totalSupply <= cap.
+func (details *BalanceAuditDetails) check() error {
+	if sum := details.AccountBalances.Sum(); sum.Cmp(details.TotalSupply) != 0 {
+		return common.InconsistencyErrorf("inconsistent balance, FC.totalSupply = %v, Sum(FC.balanceOf(account)) = %v, Diff = %v",
+			details.TotalSupply, sum, new(big.Int).Sub(details.TotalSupply, sum))
+	}
+
+	if details.TotalSupply.Cmp(details.Cap) > 0 {
+		return common.InconsistencyErrorf("total supply exceeds cap, FC.totalSupply = %v, FC.cap = %v", details.TotalSupply, details.Cap)
+	}
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package fc
+
+import (
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/open-dex/conflux-dex-audit/common"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const module = "fc"
+
+// logger is the global logger of FC module.
+var logger = common.NewLogger(module)
+
+// StartSince starts a goroutine to audit FC contract since specified epoch continously.
+// Firstly, audit the account balances at specific epoch as a baseline. Then, replay the
+// event logs epoch by epoch, and audit the account balances again periodically.
+//
+// The service never stops on failure. Transient errors, e.g. RPC error, are retried with
+// backoff. If any inconsistency or permanent error found, the service alerts and continues
+// with a new baseline at the next epoch.
+func StartSince(cfxURL, address string, epochSince *big.Int, numEpochsToAuditBalances uint64, wg *sync.WaitGroup) {
+	if numEpochsToAuditBalances == 0 {
+		logger.Fatal("numEpochsToAuditBalances is zero")
+	}
+
+	wg.Add(1)
+	go audit(cfxURL, address, epochSince, numEpochsToAuditBalances, wg)
+}
+
+func audit(cfxURL, address string, epoch *big.Int, numEpochsToAuditBalances uint64, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	auditor := NewAuditor(cfxURL, address)
+	defer auditor.Close()
+
+	logger.WithFields(logrus.Fields{
+		"address":                  address,
+		"epoch":                    epoch,
+		"numEpochsToAuditBalances": numEpochsToAuditBalances,
+	}).Debug("start to audit FC")
+
+	delta := new(big.Int).SetUint64(numEpochsToAuditBalances - 1)
+	baseline := true
+	backoff := common.MinRetryBackoff
+	retries := 0
+
+	for epochFrom := epoch; ; {
+		epochTo := new(big.Int).Add(epochFrom, delta)
+
+		logger.WithFields(logrus.Fields{
+			"epochFrom": epochFrom,
+			"epochTo":   epochTo,
+			"baseline":  baseline,
+		}).Info("new round begin")
+
+		err := auditRound(auditor, epochFrom, epochTo, baseline)
+		if err == nil {
+			if retries >= common.MaxRetriesToAlert {
+				common.Notifyf("FC audit recovered after %v retries", retries)
+			}
+
+			backoff = common.MinRetryBackoff
+			retries = 0
+			baseline = true
+			epochFrom = new(big.Int).Add(epochTo, common.Big1)
+			continue
+		}
+
+		// baseline not audited for this round yet if failed to audit balance
+		baselineFailed := auditor.lastAuditEpoch == nil || (baseline && auditor.lastAuditEpoch.Cmp(epochFrom) < 0)
+
+		if common.IsTransient(err) {
+			retries++
+
+			logger.WithError(err).WithFields(logrus.Fields{
+				"retries": retries,
+				"backoff": backoff,
+			}).Warn("failed to audit FC due to transient error, retry later")
+
+			if retries == common.MaxRetriesToAlert {
+				common.Alertf(module, "audit retried %v times due to transient error: %v", retries, err.Error())
+			}
+
+			time.Sleep(backoff)
+			backoff = common.NextBackoff(backoff)
+
+			// continue the round, and audit balance again only if baseline failed
+			baseline = baselineFailed
+			continue
+		}
+
+		// inconsistency or permanent error found, audit balance as new baseline
+		failedEpoch := new(big.Int).Add(auditor.lastAuditEpoch, common.Big1)
+		if baselineFailed {
+			failedEpoch = epochFrom
+		}
+
+		logger.WithError(err).WithField("epoch", failedEpoch).Error("failed to audit FC")
+		common.Alert(module, err.Error())
+
+		// re-baseline at the next epoch, or next round if balance audit failed
+		if baselineFailed {
+			epochFrom = new(big.Int).Add(epochTo, common.Big1)
+		} else {
+			epochFrom = new(big.Int).Add(failedEpoch, common.Big1)
+		}
+
+		backoff = common.MinRetryBackoff
+		retries = 0
+		baseline = true
+	}
+}
+
+func auditRound(auditor *Auditor, epochFrom, epochTo *big.Int, baseline bool) error {
+	// audit balance as baseline
+	if baseline {
+		common.WaitForEpochConfirmed(auditor.Cfx, epochFrom, logger)
+		details, err := auditor.AuditBalance(epochFrom)
+		if err != nil {
+			return errors.WithMessagef(err, "failed to audit balances for epoch %v", epochFrom)
+		}
+
+		logger.WithFields(logrus.Fields{
+			"epoch":       epochFrom,
+			"totalSupply": details.TotalSupply,
+			"cap":         details.Cap,
+		}).Info("succeed to audit balance and continue to audit event logs")
+	}
+
+	// audit event logs epoch by epoch
+	for auditor.lastAuditEpoch.Cmp(epochTo) < 0 {
+		common.WaitForEpochConfirmed(auditor.Cfx, new(big.Int).Add(auditor.lastAuditEpoch, common.Big1), logger)
+		if err := auditor.AuditNextEpoch(); err != nil {
+			return errors.WithMessagef(err, "failed to audit event logs, epochBasedOn = %v", auditor.lastAuditEpoch)
+		}
+	}
+
+	return nil
+}
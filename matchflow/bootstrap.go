@@ -1,6 +1,8 @@
 package matchflow
 
 import (
+	"time"
+
 	"github.com/open-dex/conflux-dex-audit/common"
 )
 
@@ -16,10 +18,24 @@ func Start(cfxURL string, matchURL string, assets []common.Asset, config *common
 	httpClient := common.NewClient(matchURL)
 
 	assetsMap := make(map[string]*common.Contract)
+	decimals := make(map[string]int32)
 	for _, asset := range assets {
 		assetsMap[asset.Name] = common.GetContract(cfxClient, common.CrclABI, asset.ContractAddress)
+		decimals[asset.Name] = int32(asset.DecimalDigits)
+	}
+
+	if config.Pausable && len(config.BoomflowAddress) > 0 {
+		common.OnMatchflowSuspended(func(suspendedAt time.Time) {
+			common.ConfirmSuspended(cfxClient, config.BoomflowAddress, suspendedAt, logger)
+		})
 	}
-	worker := NewWorker(httpClient, cfxClient, assetsMap, config)
+
+	db, err := NewDataManager(config, cfxClient)
+	if err != nil {
+		logger.Fatalf("failed to create data manager: %v", err)
+	}
+
+	worker := NewWorker(httpClient, cfxClient, assetsMap, decimals, db, config)
 	worker.Start(config)
 
 	logger.Info("matchflow auditor started")
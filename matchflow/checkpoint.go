@@ -0,0 +1,138 @@
+package matchflow
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/Conflux-Chain/go-conflux-sdk/types"
+	"github.com/Conflux-Chain/go-conflux-sdk/types/cfxaddress"
+	"github.com/open-dex/conflux-dex-audit/common"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const checkpointKey = "checkpoint"
+
+// checkpoint represents the audit cursor of Worker, so that the audit could be resumed
+// exactly where it stopped after restart.
+type checkpoint struct {
+	FullEpoch    *big.Int `json:"fullEpoch"`    // epoch that next full audit starts from
+	PartialEpoch *big.Int `json:"partialEpoch"` // next epoch to do partial audit
+	Nonce        *big.Int `json:"nonce"`        // next nonce of DEX admin before partialEpoch
+}
+
+// OpenCheckpoint opens the checkpoint store at the specified path, so that the audit cursor
+// will be saved after each audited window. If reset is true, any saved checkpoint is removed.
+func (w *Worker) OpenCheckpoint(path string, reset bool) error {
+	store, err := common.OpenStore(path)
+	if err != nil {
+		return errors.WithMessage(err, "failed to open checkpoint store")
+	}
+
+	if reset {
+		if err = store.Reset(""); err != nil {
+			store.Close()
+			return errors.WithMessage(err, "failed to reset checkpoint store")
+		}
+
+		logger.WithField("path", path).Info("checkpoint reset")
+	}
+
+	w.store = store
+
+	return nil
+}
+
+// queryAdminNonce queries the next nonce of DEX admin at the specified epoch from full node.
+func (w *Worker) queryAdminNonce(epoch *big.Int) (*big.Int, error) {
+	nonce, err := w.cfxClient.GetNextNonce(cfxaddress.MustNewFromHex(common.DexAdmin, common.GetNetworkId()), types.NewEpochNumberBig(epoch))
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to get nonce of DEX admin at epoch %s", epoch)
+	}
+	return nonce.ToInt(), nil
+}
+
+// loadCheckpoint loads the audit cursor from checkpoint, and returns false if there is no
+// checkpoint. If the nonce of DEX admin reached is changed on chain, e.g. pivot chain reorged
+// during downtime, the partial audit is rewound to the epoch that next full audit starts from.
+func (w *Worker) loadCheckpoint() (bool, error) {
+	if w.store == nil {
+		return false, nil
+	}
+
+	var cp checkpoint
+	found, err := w.store.Get(checkpointKey, &cp)
+	if err != nil {
+		return false, errors.WithMessage(err, "failed to load checkpoint")
+	}
+
+	if !found {
+		return false, nil
+	}
+
+	if cp.FullEpoch == nil || cp.PartialEpoch == nil || cp.PartialEpoch.Sign() <= 0 {
+		return false, fmt.Errorf("invalid checkpoint, fullEpoch = %v, partialEpoch = %v, run with --reset to remove it",
+			cp.FullEpoch, cp.PartialEpoch)
+	}
+
+	nonce, err := w.adminNonce(new(big.Int).Sub(cp.PartialEpoch, common.Big1))
+	if err != nil {
+		return false, err
+	}
+
+	if cp.Nonce != nil && nonce.Cmp(cp.Nonce) != 0 {
+		logger.WithFields(logrus.Fields{
+			"partialEpoch": cp.PartialEpoch,
+			"fullEpoch":    cp.FullEpoch,
+			"savedNonce":   cp.Nonce,
+			"nonce":        nonce,
+		}).Warn("nonce of DEX admin changed since checkpoint saved, rewind partial audit")
+		common.Alertf(module, "nonce of DEX admin before epoch %s changed from %s to %s since checkpoint saved, rewind partial audit to epoch %s",
+			cp.PartialEpoch, cp.Nonce, nonce, cp.FullEpoch)
+
+		if cp.FullEpoch.Cmp(cp.PartialEpoch) < 0 {
+			cp.PartialEpoch = cp.FullEpoch
+		}
+	}
+
+	w.lastFullAuditEpoch = cp.FullEpoch
+	w.lastPartialAuditEpoch = cp.PartialEpoch
+
+	logger.WithFields(logrus.Fields{
+		"fullEpoch":    cp.FullEpoch,
+		"partialEpoch": cp.PartialEpoch,
+		"nonce":        cp.Nonce,
+	}).Info("succeed to load checkpoint")
+
+	return true, nil
+}
+
+// saveCheckpoint saves the audit cursor along with the nonce of DEX admin reached, which is
+// reused from the last replayed window if possible. Failure is only logged, since the cursor
+// will be saved again after next audited window.
+func (w *Worker) saveCheckpoint() {
+	if w.store == nil {
+		return
+	}
+
+	cp := checkpoint{
+		FullEpoch:    w.lastFullAuditEpoch,
+		PartialEpoch: w.lastPartialAuditEpoch,
+	}
+
+	// nonce is known if the last replayed window ends right before the partial epoch
+	epoch := new(big.Int).Sub(cp.PartialEpoch, common.Big1)
+	cp.Nonce = w.lastNonce
+	if w.lastNonceEpoch == nil || w.lastNonceEpoch.Cmp(epoch) != 0 {
+		nonce, err := w.adminNonce(epoch)
+		if err != nil {
+			logger.Warnf("failed to save checkpoint: %v", err)
+			return
+		}
+		cp.Nonce = nonce
+	}
+
+	if err := w.store.Put(checkpointKey, &cp); err != nil {
+		logger.Warnf("failed to save checkpoint: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package matchflow
+
+import (
+	"context"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+)
+
+func newTestCheckpoint(t *testing.T, w *Worker) func() {
+	dir, err := ioutil.TempDir("", "matchflow")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = w.OpenCheckpoint(dir, false); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return func() {
+		w.store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestSaveCheckpointReuseNonce(t *testing.T) {
+	nonces := map[uint64]uint64{9: 100, 10: 101}
+	w := newTestWorker(t, nonces)
+	defer newTestCheckpoint(t, w)()
+
+	if _, _, err := w.offchainReplay(context.Background(), big.NewInt(10), big.NewInt(10)); err != nil {
+		t.Fatal(err)
+	}
+
+	// nonce of epoch 10 is known from the replayed window
+	delete(nonces, 10)
+	w.lastFullAuditEpoch = big.NewInt(5)
+	w.lastPartialAuditEpoch = big.NewInt(11)
+	w.saveCheckpoint()
+
+	var cp checkpoint
+	if found, err := w.store.Get(checkpointKey, &cp); err != nil || !found {
+		t.Fatalf("checkpoint not saved, found = %v, err = %v", found, err)
+	}
+
+	if cp.Nonce == nil || cp.Nonce.Int64() != 101 || cp.PartialEpoch.Int64() != 11 {
+		t.Fatalf("checkpoint = %+v", cp)
+	}
+
+	// loaded with the nonce on chain
+	nonces[10] = 101
+	loaded := newTestWorker(t, nonces)
+	loaded.store = w.store
+	if found, err := loaded.loadCheckpoint(); err != nil || !found {
+		t.Fatalf("checkpoint not loaded, found = %v, err = %v", found, err)
+	}
+
+	if loaded.lastPartialAuditEpoch.Int64() != 11 || loaded.lastFullAuditEpoch.Int64() != 5 {
+		t.Fatalf("partial = %v, full = %v", loaded.lastPartialAuditEpoch, loaded.lastFullAuditEpoch)
+	}
+}
+
+func TestLoadInvalidCheckpoint(t *testing.T) {
+	w := newTestWorker(t, map[uint64]uint64{})
+	defer newTestCheckpoint(t, w)()
+
+	for _, cp := range []checkpoint{
+		{},
+		{FullEpoch: big.NewInt(5)},
+		{FullEpoch: big.NewInt(5), PartialEpoch: big.NewInt(0)},
+	} {
+		if err := w.store.Put(checkpointKey, &cp); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := w.loadCheckpoint(); err == nil || !strings.Contains(err.Error(), "--reset") {
+			t.Errorf("checkpoint %+v: err = %v", cp, err)
+		}
+	}
+}
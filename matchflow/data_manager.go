@@ -1,21 +1,66 @@
 package matchflow
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"io"
 	"math/big"
+	"net"
 	"sync"
+	"time"
 
 	conflux "github.com/Conflux-Chain/go-conflux-sdk"
 	"github.com/Conflux-Chain/go-conflux-sdk/types"
-	_ "github.com/go-sql-driver/mysql" // mysql driver
+	"github.com/go-sql-driver/mysql"
 	"github.com/open-dex/conflux-dex-audit/common"
+	"github.com/pkg/errors"
 	"github.com/shopspring/decimal"
 )
 
-// DataManager access matchflow database
-type DataManager struct {
+// DataManager provides the off-chain data of matchflow to replay balance changes.
+type DataManager interface {
+	GetUserByName(ctx context.Context, name string) (*User, error)
+	GetAccountIDByUserID(ctx context.Context, userID uint64, currency string) (uint64, error)
+	GetAccountID(ctx context.Context, userName string, currency string) (uint64, error)
+	GetCurrencyName(ctx context.Context, id uint64) (string, error)
+	GetProduct(ctx context.Context, id uint64) (*Product, error)
+	GetOrder(ctx context.Context, id uint64) (*Order, error)
+
+	// records settled on chain with tx nonce of DEX admin in range [fromNonce, toNonce]
+	GetTrades(ctx context.Context, fromNonce, toNonce *big.Int) ([]*Trade, error)
+	GetWithdraws(ctx context.Context, fromNonce, toNonce *big.Int) ([]*Withdraw, error)
+	GetTransfers(ctx context.Context, fromNonce, toNonce *big.Int) ([]*Transfer, error)
+
+	// deposits with transaction executed in epoch range [fromEpoch, toEpoch]
+	GetDeposits(ctx context.Context, fromEpoch, toEpoch *big.Int) ([]*Deposit, error)
+
+	CleanCache()
+}
+
+// Backends of DataManager.
+const (
+	DataBackendMySQL  = "mysql"
+	DataBackendMemory = "memory"
+)
+
+// NewDataManager creates a DataManager of the backend in config.
+func NewDataManager(config *common.MatchflowConfig, client *conflux.Client) (DataManager, error) {
+	switch config.DbBackend {
+	case "", DataBackendMySQL:
+		return NewMySQLDataManager(config.DbUser, config.DbAddress, config.DbPass, config.DbName, config.DexStartTime, client)
+	case DataBackendMemory:
+		return NewMemoryDataManager(config.DbFixture)
+	default:
+		return nil, fmt.Errorf("invalid data backend %s", config.DbBackend)
+	}
+}
+
+// MySQLDataManager access matchflow database
+type MySQLDataManager struct {
 	db             *sql.DB
+	cfx            *conflux.Client // to locate deposits by epoch
 	userName       sync.Map
 	userNameCnt    int
 	userAccount    sync.Map
@@ -35,14 +80,17 @@ type User struct {
 
 // Trade struct for t_trade table
 type Trade struct {
+	id, txNonce                             uint64
 	productID, takerOrderID, makerOrderID   uint64
 	price, amount, side, takerFee, makerFee string
 }
 
 // TradeDetail struct for transaction replay
 type TradeDetail struct {
-	userID uint64
-	amount decimal.Decimal
+	userID   uint64
+	currency string // currency name, which determines the decimals on chain
+	amount   decimal.Decimal
+	source   *recordSource // off-chain record that the balance change comes from
 }
 
 // Product struct for t_product table
@@ -59,16 +107,19 @@ type Order struct {
 
 // Withdraw struct for t_withdraw table
 type Withdraw struct {
+	id, txNonce                   uint64
 	userAddress, currency, amount string
 }
 
 // Deposit struct for t_deposit table
 type Deposit struct {
+	id                                    uint64
 	userAddress, currency, amount, txHash string
 }
 
 // Transfer struct for t_transfer table
 type Transfer struct {
+	id, txNonce                       uint64
 	userAddress, currency, recipients string
 }
 
@@ -82,400 +133,504 @@ type Account struct {
 const (
 	maxCacheSize       = 1000000
 	maxRecordsPerQuery = 100
+	maxQueryAttempts   = 5               // max attempts of a query on transient database errors
+	queryRetryInterval = 2 * time.Second // interval to retry a query
 )
 
-// MustGetUserByName get user by conflux address
-func (m *DataManager) MustGetUserByName(name string) *User {
-	if ret, ok := m.userName.Load(name); ok {
-		return ret.(*User)
+// isTransientError returns true if the database error is transient, e.g. dropped connection
+// or deadlock, so that the query could be retried.
+func isTransientError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
 	}
-	rows, err := m.db.Query(`SELECT * FROM t_user WHERE NAME = ?`, name)
-	if err != nil {
-		panic(err)
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
 	}
-	defer rows.Close()
 
-	user := User{}
-	if rows.Next() {
-		if err := rows.Scan(&user.id, &user.name); err != nil {
-			panic(err)
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case 1040, 1205, 1213: // too many connections, lock wait timeout, deadlock
+			return true
 		}
-	} else {
-		panic(fmt.Errorf("user %s not found", name))
 	}
+
+	return false
+}
+
+// retry runs the query until succeeded, or non-transient error occurred, or max attempts
+// reached, or context done.
+func retry(ctx context.Context, query func() error) error {
+	for attempts := 1; ; attempts++ {
+		err := query()
+		if err == nil || !isTransientError(err) || attempts >= maxQueryAttempts {
+			return err
+		}
+
+		logger.Warnf("transient database error, attempts = %d: %v", attempts, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(queryRetryInterval):
+		}
+	}
+}
+
+// GetUserByName get user by conflux address
+func (m *MySQLDataManager) GetUserByName(ctx context.Context, name string) (*User, error) {
+	if ret, ok := m.userName.Load(name); ok {
+		return ret.(*User), nil
+	}
+
+	user := User{}
+	err := retry(ctx, func() error {
+		return m.db.QueryRowContext(ctx, `SELECT * FROM t_user WHERE NAME = ?`, name).Scan(&user.id, &user.name)
+	})
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("user %s not found", name)
+	}
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to get user %s", name)
+	}
+
 	m.userName.Store(name, &user)
 	m.userNameCnt++
-	return &user
+	return &user, nil
 }
 
-// MustGetAccountIDByUserID get user account id of specific currency by user id
-func (m *DataManager) MustGetAccountIDByUserID(userID uint64, currency string) uint64 {
+// GetAccountIDByUserID get user account id of specific currency by user id
+func (m *MySQLDataManager) GetAccountIDByUserID(ctx context.Context, userID uint64, currency string) (uint64, error) {
 	var currencyAccountMap *sync.Map
 	if ret, ok := m.userAccount.Load(userID); ok {
 		currencyAccountMap = ret.(*sync.Map)
 		if ret, ok := currencyAccountMap.Load(currency); ok {
-			return ret.(uint64)
+			return ret.(uint64), nil
 		}
 	} else {
 		currencyAccountMap = &sync.Map{}
 		m.userAccount.Store(userID, currencyAccountMap)
 		m.userAccountCnt++
 	}
-	rows, err := m.db.Query(`SELECT id FROM t_account WHERE user_id = ? AND currency = ?`, userID, currency)
-	if err != nil {
-		panic(err)
-	}
-	defer rows.Close()
 
 	var accountID uint64
-	if rows.Next() {
-		if err := rows.Scan(&accountID); err != nil {
-			panic(err)
-		}
-	} else {
-		panic(fmt.Errorf("user %d account for %s not found", userID, currency))
+	err := retry(ctx, func() error {
+		return m.db.QueryRowContext(ctx, `SELECT id FROM t_account WHERE user_id = ? AND currency = ?`, userID, currency).Scan(&accountID)
+	})
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("user %d account for %s not found", userID, currency)
 	}
+	if err != nil {
+		return 0, errors.WithMessagef(err, "failed to get user %d account for %s", userID, currency)
+	}
+
 	currencyAccountMap.Store(currency, accountID)
-	return accountID
+	return accountID, nil
 }
 
-// MustGetAccountID get user account id of specific currency by conflux address
-func (m *DataManager) MustGetAccountID(userName string, currency string) uint64 {
-	user := m.MustGetUserByName(userName)
-	return m.MustGetAccountIDByUserID(user.id, currency)
+// GetAccountID get user account id of specific currency by conflux address
+func (m *MySQLDataManager) GetAccountID(ctx context.Context, userName string, currency string) (uint64, error) {
+	user, err := m.GetUserByName(ctx, userName)
+	if err != nil {
+		return 0, err
+	}
+	return m.GetAccountIDByUserID(ctx, user.id, currency)
 }
 
 // getTrades get trade with tx_nonce between fromNonce and toNonce with offset
-func (m *DataManager) getTrades(fromNonce *big.Int, toNonce *big.Int, offset uint64) []*Trade {
-	rows, err := m.db.Query(`
-	SELECT product_id, 
-		taker_order_id, 
-		maker_order_id, 
-		price, 
-		amount, 
-		side, 
-		taker_fee,
-		maker_fee 
-	FROM	t_trade 
-	WHERE	status IN ( "onchainsettled", "onchainconfirmed" ) 
-			AND tx_nonce BETWEEN ? AND ? 
-			AND create_time > ?
-	ORDER BY id
-	LIMIT  ?, ? `, fromNonce.Int64(), toNonce.Int64(), m.dexStartTime, offset, maxRecordsPerQuery)
-
-	if err != nil {
-		panic(err)
-	}
-	defer rows.Close()
+func (m *MySQLDataManager) getTrades(ctx context.Context, fromNonce *big.Int, toNonce *big.Int, offset uint64) ([]*Trade, error) {
+	var trades []*Trade
+	err := retry(ctx, func() error {
+		rows, err := m.db.QueryContext(ctx, `
+		SELECT id,
+			tx_nonce,
+			product_id, 
+			taker_order_id, 
+			maker_order_id, 
+			price, 
+			amount, 
+			side, 
+			taker_fee,
+			maker_fee 
+		FROM	t_trade 
+		WHERE	status IN ( "onchainsettled", "onchainconfirmed" ) 
+				AND tx_nonce BETWEEN ? AND ? 
+				AND create_time > ?
+		ORDER BY id
+		LIMIT  ?, ? `, fromNonce.Int64(), toNonce.Int64(), m.dexStartTime, offset, maxRecordsPerQuery)
 
-	trades := []*Trade{}
-	for rows.Next() {
-		trade := Trade{}
-		if err := rows.Scan(&trade.productID,
-			&trade.takerOrderID,
-			&trade.makerOrderID,
-			&trade.price,
-			&trade.amount,
-			&trade.side,
-			&trade.takerFee,
-			&trade.makerFee); err != nil {
-			panic(err)
+		if err != nil {
+			return err
 		}
-		trades = append(trades, &trade)
+		defer rows.Close()
+
+		trades = []*Trade{}
+		for rows.Next() {
+			trade := Trade{}
+			if err := rows.Scan(&trade.id,
+				&trade.txNonce,
+				&trade.productID,
+				&trade.takerOrderID,
+				&trade.makerOrderID,
+				&trade.price,
+				&trade.amount,
+				&trade.side,
+				&trade.takerFee,
+				&trade.makerFee); err != nil {
+				return err
+			}
+			trades = append(trades, &trade)
+		}
+		return rows.Err()
+	})
+
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to get trades since offset %d", offset)
 	}
-	return trades
+	return trades, nil
 }
 
 // GetTrades get trade with tx_nonce between fromNonce and toNonce
-func (m *DataManager) GetTrades(fromNonce *big.Int, toNonce *big.Int) []*Trade {
+func (m *MySQLDataManager) GetTrades(ctx context.Context, fromNonce *big.Int, toNonce *big.Int) ([]*Trade, error) {
 	offset := uint64(0)
 	trades := []*Trade{}
 	for {
-		ret := m.getTrades(fromNonce, toNonce, offset)
+		ret, err := m.getTrades(ctx, fromNonce, toNonce, offset)
+		if err != nil {
+			return nil, err
+		}
 		trades = append(trades, ret...)
 		offset = offset + uint64(len(ret))
 		if len(ret) < maxRecordsPerQuery {
 			break
 		}
 	}
-	return trades
+	return trades, nil
 }
 
 // getWithdraws get withdraw records with tx_nonce between fromNonce and toNonce with offset
-func (m *DataManager) getWithdraws(fromNonce *big.Int, toNonce *big.Int, offset uint64) []*Withdraw {
-	rows, err := m.db.Query(`
-	SELECT user_address, 
-	   currency,
-       amount
-	FROM   t_withdraw
-	WHERE  status IN ( "onchainsettled", "onchainconfirmed" ) 
-		AND tx_nonce BETWEEN ? AND ? 
-		AND create_time > ?
-	ORDER BY id
-	LIMIT  ?, ? `, fromNonce.Int64(), toNonce.Int64(), m.dexStartTime, offset, maxRecordsPerQuery)
-
-	if err != nil {
-		panic(err)
-	}
-	defer rows.Close()
+func (m *MySQLDataManager) getWithdraws(ctx context.Context, fromNonce *big.Int, toNonce *big.Int, offset uint64) ([]*Withdraw, error) {
+	var withdraws []*Withdraw
+	err := retry(ctx, func() error {
+		rows, err := m.db.QueryContext(ctx, `
+		SELECT id,
+		   tx_nonce,
+		   user_address, 
+		   currency,
+		   amount
+		FROM   t_withdraw
+		WHERE  status IN ( "onchainsettled", "onchainconfirmed" ) 
+			AND tx_nonce BETWEEN ? AND ? 
+			AND create_time > ?
+		ORDER BY id
+		LIMIT  ?, ? `, fromNonce.Int64(), toNonce.Int64(), m.dexStartTime, offset, maxRecordsPerQuery)
 
-	withdraws := []*Withdraw{}
-	for rows.Next() {
-		withdraw := Withdraw{}
-		if err := rows.Scan(&withdraw.userAddress,
-			&withdraw.currency,
-			&withdraw.amount); err != nil {
-			panic(err)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		withdraws = []*Withdraw{}
+		for rows.Next() {
+			withdraw := Withdraw{}
+			if err := rows.Scan(&withdraw.id,
+				&withdraw.txNonce,
+				&withdraw.userAddress,
+				&withdraw.currency,
+				&withdraw.amount); err != nil {
+				return err
+			}
+			withdraws = append(withdraws, &withdraw)
 		}
-		withdraws = append(withdraws, &withdraw)
+		return rows.Err()
+	})
+
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to get withdraws since offset %d", offset)
 	}
-	return withdraws
+	return withdraws, nil
 }
 
 // GetWithdraws get withdraw record with tx_nonce between fromNonce and toNonce
-func (m *DataManager) GetWithdraws(fromNonce *big.Int, toNonce *big.Int) []*Withdraw {
+func (m *MySQLDataManager) GetWithdraws(ctx context.Context, fromNonce *big.Int, toNonce *big.Int) ([]*Withdraw, error) {
 	offset := uint64(0)
 	withdraws := []*Withdraw{}
 	for {
-		ret := m.getWithdraws(fromNonce, toNonce, offset)
+		ret, err := m.getWithdraws(ctx, fromNonce, toNonce, offset)
+		if err != nil {
+			return nil, err
+		}
 		withdraws = append(withdraws, ret...)
 		offset = offset + uint64(len(ret))
 		if len(ret) < maxRecordsPerQuery {
 			break
 		}
 	}
-	return withdraws
+	return withdraws, nil
 }
 
 // GetCurrencyName get currency name by currency id
-func (m *DataManager) GetCurrencyName(id uint64) string {
+func (m *MySQLDataManager) GetCurrencyName(ctx context.Context, id uint64) (string, error) {
 	if ret, ok := m.currency.Load(id); ok {
-		return ret.(string)
-	}
-	rows, err := m.db.Query(`SELECT name FROM t_currency WHERE id = ?`, id)
-	if err != nil {
-		panic(err)
+		return ret.(string), nil
 	}
-	defer rows.Close()
 
 	var name string
-	if rows.Next() {
-		if err := rows.Scan(&name); err != nil {
-			panic(err)
-		}
-	} else {
-		panic(fmt.Errorf("currency %d not found", id))
+	err := retry(ctx, func() error {
+		return m.db.QueryRowContext(ctx, `SELECT name FROM t_currency WHERE id = ?`, id).Scan(&name)
+	})
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("currency %d not found", id)
+	}
+	if err != nil {
+		return "", errors.WithMessagef(err, "failed to get currency %d", id)
 	}
+
 	m.currency.Store(id, name)
-	return name
+	return name, nil
 }
 
 // GetProduct get product by id
-func (m *DataManager) GetProduct(id uint64) *Product {
+func (m *MySQLDataManager) GetProduct(ctx context.Context, id uint64) (*Product, error) {
 	if ret, ok := m.product.Load(id); ok {
-		return ret.(*Product)
+		return ret.(*Product), nil
 	}
-	rows, err := m.db.Query(`
-	SELECT base_currency_id,
-		quote_currency_id 
-	FROM t_product
-	WHERE id = ?`, id)
 
+	product := Product{}
+	err := retry(ctx, func() error {
+		return m.db.QueryRowContext(ctx, `
+		SELECT base_currency_id,
+			quote_currency_id 
+		FROM t_product
+		WHERE id = ?`, id).Scan(&product.baseCurrencyID, &product.quoteCurrencyID)
+	})
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("product %d not found", id)
+	}
 	if err != nil {
-		panic(err)
+		return nil, errors.WithMessagef(err, "failed to get product %d", id)
 	}
-	defer rows.Close()
 
-	product := Product{}
-	if rows.Next() {
-		if err := rows.Scan(&product.baseCurrencyID, &product.quoteCurrencyID); err != nil {
-			panic(err)
-		}
-	} else {
-		panic(fmt.Errorf("product %d not found", id))
-	}
 	m.product.Store(id, &product)
-	return &product
+	return &product, nil
 }
 
 // GetOrder get order by id
-func (m *DataManager) GetOrder(id uint64) *Order {
+func (m *MySQLDataManager) GetOrder(ctx context.Context, id uint64) (*Order, error) {
 	if ret, ok := m.order.Load(id); ok {
-		return ret.(*Order)
+		// copy, since account ids are filled by caller
+		order := *ret.(*Order)
+		return &order, nil
 	}
-	rows, err := m.db.Query(`
-	SELECT user_id,
-		fee_address,
-		type,
-		price
-	FROM t_order
-	WHERE id = ?`, id)
 
+	order := Order{}
+	err := retry(ctx, func() error {
+		return m.db.QueryRowContext(ctx, `
+		SELECT user_id,
+			fee_address,
+			type,
+			price
+		FROM t_order
+		WHERE id = ?`, id).Scan(&order.userID, &order.feeAddress, &order.orderType, &order.price)
+	})
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("order %d not found", id)
+	}
 	if err != nil {
-		panic(err)
+		return nil, errors.WithMessagef(err, "failed to get order %d", id)
 	}
-	defer rows.Close()
 
-	order := Order{}
-	if rows.Next() {
-		if err := rows.Scan(&order.userID, &order.feeAddress, &order.orderType, &order.price); err != nil {
-			panic(err)
-		}
-	} else {
-		panic(fmt.Errorf("order %d not found", id))
-	}
-	m.product.Store(id, &order)
+	cached := order
+	m.order.Store(id, &cached)
 	m.orderCnt++
-	return &order
+	return &order, nil
 }
 
-func (m *DataManager) getDepositCnt() uint64 {
-	rows, err := m.db.Query(`
-		SELECT count(*) 
-		FROM t_deposit 
-		WHERE create_time > ?`, m.dexStartTime)
+func (m *MySQLDataManager) getDepositCnt(ctx context.Context) (uint64, error) {
+	var total uint64
+	err := retry(ctx, func() error {
+		return m.db.QueryRowContext(ctx, `
+			SELECT count(*) 
+			FROM t_deposit 
+			WHERE create_time > ?`, m.dexStartTime).Scan(&total)
+	})
 	if err != nil {
-		panic(err)
+		return 0, errors.WithMessage(err, "failed to get count of t_deposit")
 	}
-	defer rows.Close()
+	return total, nil
+}
 
-	var total uint64
-	if rows.Next() {
-		if err := rows.Scan(&total); err != nil {
-			panic(err)
+func (m *MySQLDataManager) getDeposits(ctx context.Context, offset uint64, cnt int) ([]*Deposit, error) {
+	var deposits []*Deposit
+	err := retry(ctx, func() error {
+		rows, err := m.db.QueryContext(ctx, `
+		SELECT id,
+			user_address,
+			currency,
+			amount,
+			tx_hash
+		FROM t_deposit
+		WHERE create_time > ?
+		ORDER BY id
+		LIMIT ?, ?`, m.dexStartTime, offset, cnt)
+		if err != nil {
+			return err
 		}
-	} else {
-		panic(fmt.Errorf("failed to get count of t_deposit"))
-	}
-	return total
-}
+		defer rows.Close()
+
+		deposits = []*Deposit{}
+		for rows.Next() {
+			deposit := Deposit{}
+			if err := rows.Scan(&deposit.id,
+				&deposit.userAddress,
+				&deposit.currency,
+				&deposit.amount,
+				&deposit.txHash); err != nil {
+				return err
+			}
+			deposits = append(deposits, &deposit)
+		}
+		return rows.Err()
+	})
 
-func (m *DataManager) getDeposits(offset uint64, cnt int) []*Deposit {
-	rows, err := m.db.Query(`
-	SELECT user_address,
-		currency,
-		amount,
-		tx_hash
-	FROM t_deposit
-	WHERE create_time > ?
-	ORDER BY id
-	LIMIT ?, ?`, m.dexStartTime, offset, cnt)
 	if err != nil {
-		panic(err)
-	}
-	defer rows.Close()
-
-	deposits := []*Deposit{}
-	for rows.Next() {
-		deposit := Deposit{}
-		if err := rows.Scan(&deposit.userAddress,
-			&deposit.currency,
-			&deposit.amount,
-			&deposit.txHash); err != nil {
-			panic(err)
-		}
-		deposits = append(deposits, &deposit)
+		return nil, errors.WithMessagef(err, "failed to get deposits since offset %d", offset)
 	}
-	return deposits
+	return deposits, nil
 }
 
-func (m *DataManager) getDepositOffset(epoch, total uint64, client *conflux.Client) uint64 {
+func (m *MySQLDataManager) getDepositOffset(ctx context.Context, epoch, total uint64) (uint64, error) {
 	// get the minimal offset index which tx epoch number >= given epoch
-	l, r := uint64(0), total-1
-	for l <= r {
+	l, r := uint64(0), total
+	for l < r {
 		mid := (l + r) >> 1
-		deposit := (m.getDeposits(mid, 1))[0]
-		tx, err := client.GetTransactionByHash(types.Hash(deposit.txHash))
+		deposits, err := m.getDeposits(ctx, mid, 1)
 		if err != nil {
-			panic(err)
+			return 0, err
 		}
-		block, err := client.GetBlockByHash(*tx.BlockHash)
+		if len(deposits) == 0 {
+			return 0, fmt.Errorf("deposit at offset %d not found", mid)
+		}
+		deposit := deposits[0]
+		tx, err := m.cfx.GetTransactionByHash(types.Hash(deposit.txHash))
 		if err != nil {
-			panic(err)
+			return 0, errors.WithMessagef(err, "failed to get deposit transaction %s", deposit.txHash)
+		}
+		block, err := m.cfx.GetBlockByHash(*tx.BlockHash)
+		if err != nil {
+			return 0, errors.WithMessagef(err, "failed to get block of deposit transaction %s", deposit.txHash)
 		}
 		midEpoch := block.EpochNumber.ToInt().Uint64()
 		if midEpoch < epoch {
 			l = mid + 1
 		} else {
-			r = mid - 1
+			r = mid
 		}
 	}
-	return r + 1
+	return l, nil
 }
 
 // GetDeposits get deposit records between fromEpoch and toEpoch
-func (m *DataManager) GetDeposits(fromEpoch, toEpoch *big.Int, client *conflux.Client) []*Deposit {
+func (m *MySQLDataManager) GetDeposits(ctx context.Context, fromEpoch, toEpoch *big.Int) ([]*Deposit, error) {
 	deposits := []*Deposit{}
-	total := m.getDepositCnt()
-	if total == 0 {
-		return deposits
+	total, err := m.getDepositCnt(ctx)
+	if err != nil || total == 0 {
+		return deposits, err
+	}
+	startOffset, err := m.getDepositOffset(ctx, fromEpoch.Uint64(), total)
+	if err != nil {
+		return nil, err
 	}
-	startOffset, endOffset := m.getDepositOffset(fromEpoch.Uint64(), total, client), m.getDepositOffset(toEpoch.Uint64()+uint64(1), total, client)
-	endOffset--
-	if startOffset > endOffset {
-		return deposits
+	endOffset, err := m.getDepositOffset(ctx, toEpoch.Uint64()+uint64(1), total)
+	if err != nil {
+		return nil, err
 	}
+	// deposits in range [startOffset, endOffset)
 	i := startOffset
-	for i <= endOffset {
+	for i < endOffset {
 		cnt := maxRecordsPerQuery
-		if int(endOffset-i+1) < cnt {
-			cnt = int(endOffset - i + 1)
+		if int(endOffset-i) < cnt {
+			cnt = int(endOffset - i)
+		}
+		ret, err := m.getDeposits(ctx, i, cnt)
+		if err != nil {
+			return nil, err
+		}
+		if len(ret) == 0 {
+			break
 		}
-		ret := m.getDeposits(i, cnt)
 		deposits = append(deposits, ret...)
 		i = i + uint64(len(ret))
 	}
-	return deposits
+	return deposits, nil
 }
 
 // getTransfers get transfer records with tx_nonce between fromNonce and toNonce with offset
-func (m *DataManager) getTransfers(fromNonce *big.Int, toNonce *big.Int, offset uint64) []*Transfer {
-	rows, err := m.db.Query(`
-	SELECT user_address, 
-	   currency,
-       recipients
-	FROM   t_transfer
-	WHERE  status IN ( "onchainsettled", "onchainconfirmed" ) 
-		AND tx_nonce BETWEEN ? AND ? 
-		AND create_time > ?
-	ORDER BY id
-	LIMIT  ?, ? `, fromNonce.Int64(), toNonce.Int64(), m.dexStartTime, offset, maxRecordsPerQuery)
-
-	if err != nil {
-		panic(err)
-	}
-	defer rows.Close()
+func (m *MySQLDataManager) getTransfers(ctx context.Context, fromNonce *big.Int, toNonce *big.Int, offset uint64) ([]*Transfer, error) {
+	var transfers []*Transfer
+	err := retry(ctx, func() error {
+		rows, err := m.db.QueryContext(ctx, `
+		SELECT id,
+		   tx_nonce,
+		   user_address, 
+		   currency,
+		   recipients
+		FROM   t_transfer
+		WHERE  status IN ( "onchainsettled", "onchainconfirmed" ) 
+			AND tx_nonce BETWEEN ? AND ? 
+			AND create_time > ?
+		ORDER BY id
+		LIMIT  ?, ? `, fromNonce.Int64(), toNonce.Int64(), m.dexStartTime, offset, maxRecordsPerQuery)
 
-	transfers := []*Transfer{}
-	for rows.Next() {
-		transfer := Transfer{}
-		if err := rows.Scan(&transfer.userAddress,
-			&transfer.currency,
-			&transfer.recipients); err != nil {
-			panic(err)
+		if err != nil {
+			return err
 		}
-		transfers = append(transfers, &transfer)
+		defer rows.Close()
+
+		transfers = []*Transfer{}
+		for rows.Next() {
+			transfer := Transfer{}
+			if err := rows.Scan(&transfer.id,
+				&transfer.txNonce,
+				&transfer.userAddress,
+				&transfer.currency,
+				&transfer.recipients); err != nil {
+				return err
+			}
+			transfers = append(transfers, &transfer)
+		}
+		return rows.Err()
+	})
+
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to get transfers since offset %d", offset)
 	}
-	return transfers
+	return transfers, nil
 }
 
 // GetTransfers get transfer records between fromNonce and toNonce
-func (m *DataManager) GetTransfers(fromNonce, toNonce *big.Int) []*Transfer {
+func (m *MySQLDataManager) GetTransfers(ctx context.Context, fromNonce, toNonce *big.Int) ([]*Transfer, error) {
 	offset := uint64(0)
 	transfers := []*Transfer{}
 	for {
-		ret := m.getTransfers(fromNonce, toNonce, offset)
+		ret, err := m.getTransfers(ctx, fromNonce, toNonce, offset)
+		if err != nil {
+			return nil, err
+		}
 		transfers = append(transfers, ret...)
 		offset = offset + uint64(len(ret))
 		if len(ret) < maxRecordsPerQuery {
 			break
 		}
 	}
-	return transfers
+	return transfers, nil
 }
 
 // CleanCache clean the cache map if it size exceeds a constant
-func (m *DataManager) CleanCache() {
+func (m *MySQLDataManager) CleanCache() {
 	if m.userNameCnt > maxCacheSize {
 		m.userName = sync.Map{}
 		m.userNameCnt = 0
@@ -490,18 +645,21 @@ func (m *DataManager) CleanCache() {
 	}
 }
 
-// NewDataManager create new datamanager instance
-func NewDataManager(dexDbUser, dbAddress, dbPass string, dexStartTime string) *DataManager {
+// NewMySQLDataManager create new datamanager instance on MySQL database
+func NewMySQLDataManager(dexDbUser, dbAddress, dbPass, dbName, dexStartTime string, client *conflux.Client) (*MySQLDataManager, error) {
 	dbDriver := "mysql"
 	dbUser := dexDbUser
-	dbName := "conflux_dex"
+	if len(dbName) == 0 {
+		dbName = "conflux_dex"
+	}
 	decrypted := common.AesDecrypt(dbPass, common.AesSecret)
 	db, err := sql.Open(dbDriver, dbUser+":"+decrypted+"@"+dbAddress+"/"+dbName)
 	if err != nil {
-		panic(err.Error())
+		return nil, errors.WithMessage(err, "failed to open database")
 	}
-	return &DataManager{
+	return &MySQLDataManager{
 		db:             db,
+		cfx:            client,
 		userName:       sync.Map{},
 		userNameCnt:    0,
 		userAccount:    sync.Map{},
@@ -511,5 +669,5 @@ func NewDataManager(dexDbUser, dbAddress, dbPass string, dexStartTime string) *D
 		order:          sync.Map{},
 		orderCnt:       0,
 		dexStartTime:   dexStartTime,
-	}
+	}, nil
 }
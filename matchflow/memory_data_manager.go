@@ -0,0 +1,316 @@
+package matchflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// Fixture is the matchflow data of memory backend, which is usually loaded from a JSON file.
+// Records of trades, withdraws and transfers are those settled on chain, in the order of id.
+type Fixture struct {
+	Users      []FixtureUser     `json:"users"`
+	Accounts   []FixtureAccount  `json:"accounts"`
+	Currencies []FixtureCurrency `json:"currencies"`
+	Products   []FixtureProduct  `json:"products"`
+	Orders     []FixtureOrder    `json:"orders"`
+	Trades     []FixtureTrade    `json:"trades"`
+	Withdraws  []FixtureWithdraw `json:"withdraws"`
+	Deposits   []FixtureDeposit  `json:"deposits"`
+	Transfers  []FixtureTransfer `json:"transfers"`
+}
+
+// FixtureUser is a record of t_user table.
+type FixtureUser struct {
+	ID   uint64 `json:"id"`
+	Name string `json:"name"`
+}
+
+// FixtureAccount is a record of t_account table.
+type FixtureAccount struct {
+	ID       uint64 `json:"id"`
+	UserID   uint64 `json:"userId"`
+	Currency string `json:"currency"`
+}
+
+// FixtureCurrency is a record of t_currency table.
+type FixtureCurrency struct {
+	ID   uint64 `json:"id"`
+	Name string `json:"name"`
+}
+
+// FixtureProduct is a record of t_product table.
+type FixtureProduct struct {
+	ID              uint64 `json:"id"`
+	BaseCurrencyID  uint64 `json:"baseCurrencyId"`
+	QuoteCurrencyID uint64 `json:"quoteCurrencyId"`
+}
+
+// FixtureOrder is a record of t_order table.
+type FixtureOrder struct {
+	ID         uint64 `json:"id"`
+	UserID     uint64 `json:"userId"`
+	FeeAddress string `json:"feeAddress"`
+	Type       string `json:"type"`
+	Price      string `json:"price"`
+}
+
+// FixtureTrade is a record of t_trade table.
+type FixtureTrade struct {
+	ID           uint64 `json:"id"`
+	TxNonce      uint64 `json:"txNonce"`
+	ProductID    uint64 `json:"productId"`
+	TakerOrderID uint64 `json:"takerOrderId"`
+	MakerOrderID uint64 `json:"makerOrderId"`
+	Price        string `json:"price"`
+	Amount       string `json:"amount"`
+	Side         string `json:"side"`
+	TakerFee     string `json:"takerFee"`
+	MakerFee     string `json:"makerFee"`
+}
+
+// FixtureWithdraw is a record of t_withdraw table.
+type FixtureWithdraw struct {
+	ID          uint64 `json:"id"`
+	TxNonce     uint64 `json:"txNonce"`
+	UserAddress string `json:"userAddress"`
+	Currency    string `json:"currency"`
+	Amount      string `json:"amount"`
+}
+
+// FixtureDeposit is a record of t_deposit table, along with the epoch of deposit transaction.
+type FixtureDeposit struct {
+	ID          uint64 `json:"id"`
+	Epoch       uint64 `json:"epoch"`
+	UserAddress string `json:"userAddress"`
+	Currency    string `json:"currency"`
+	Amount      string `json:"amount"`
+	TxHash      string `json:"txHash"`
+}
+
+// FixtureTransfer is a record of t_transfer table.
+type FixtureTransfer struct {
+	ID          uint64 `json:"id"`
+	TxNonce     uint64 `json:"txNonce"`
+	UserAddress string `json:"userAddress"`
+	Currency    string `json:"currency"`
+	Recipients  string `json:"recipients"` // JSON object of recipient address and amount
+}
+
+// MemoryDataManager serves matchflow data from memory, so that the replay could run without
+// the DEX database, e.g. in tests.
+type MemoryDataManager struct {
+	fixture    *Fixture
+	users      map[string]*User             // key is user name
+	accounts   map[uint64]map[string]uint64 // key is user id and currency
+	currencies map[uint64]string
+	products   map[uint64]*Product
+	orders     map[uint64]*Order
+}
+
+// NewMemoryDataManager creates a MemoryDataManager with fixture loaded from the JSON file at
+// specified path.
+func NewMemoryDataManager(path string) (*MemoryDataManager, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to read fixture file")
+	}
+
+	var fixture Fixture
+	if err = json.Unmarshal(data, &fixture); err != nil {
+		return nil, errors.WithMessage(err, "failed to unmarshal fixture")
+	}
+
+	return NewMemoryDataManagerFromFixture(&fixture), nil
+}
+
+// NewMemoryDataManagerFromFixture creates a MemoryDataManager with specified fixture.
+func NewMemoryDataManagerFromFixture(fixture *Fixture) *MemoryDataManager {
+	m := MemoryDataManager{
+		fixture:    fixture,
+		users:      make(map[string]*User),
+		accounts:   make(map[uint64]map[string]uint64),
+		currencies: make(map[uint64]string),
+		products:   make(map[uint64]*Product),
+		orders:     make(map[uint64]*Order),
+	}
+
+	for _, user := range fixture.Users {
+		m.users[user.Name] = &User{user.ID, user.Name}
+	}
+
+	for _, account := range fixture.Accounts {
+		if m.accounts[account.UserID] == nil {
+			m.accounts[account.UserID] = make(map[string]uint64)
+		}
+		m.accounts[account.UserID][account.Currency] = account.ID
+	}
+
+	for _, currency := range fixture.Currencies {
+		m.currencies[currency.ID] = currency.Name
+	}
+
+	for _, product := range fixture.Products {
+		m.products[product.ID] = &Product{
+			baseCurrencyID:  product.BaseCurrencyID,
+			quoteCurrencyID: product.QuoteCurrencyID,
+		}
+	}
+
+	for _, order := range fixture.Orders {
+		m.orders[order.ID] = &Order{
+			userID:     order.UserID,
+			feeAddress: order.FeeAddress,
+			orderType:  order.Type,
+			price:      order.Price,
+		}
+	}
+
+	return &m
+}
+
+// GetUserByName get user by conflux address
+func (m *MemoryDataManager) GetUserByName(ctx context.Context, name string) (*User, error) {
+	user, ok := m.users[name]
+	if !ok {
+		return nil, fmt.Errorf("user %s not found", name)
+	}
+	return user, nil
+}
+
+// GetAccountIDByUserID get user account id of specific currency by user id
+func (m *MemoryDataManager) GetAccountIDByUserID(ctx context.Context, userID uint64, currency string) (uint64, error) {
+	accountID, ok := m.accounts[userID][currency]
+	if !ok {
+		return 0, fmt.Errorf("user %d account for %s not found", userID, currency)
+	}
+	return accountID, nil
+}
+
+// GetAccountID get user account id of specific currency by conflux address
+func (m *MemoryDataManager) GetAccountID(ctx context.Context, userName string, currency string) (uint64, error) {
+	user, err := m.GetUserByName(ctx, userName)
+	if err != nil {
+		return 0, err
+	}
+	return m.GetAccountIDByUserID(ctx, user.id, currency)
+}
+
+// GetCurrencyName get currency name by currency id
+func (m *MemoryDataManager) GetCurrencyName(ctx context.Context, id uint64) (string, error) {
+	name, ok := m.currencies[id]
+	if !ok {
+		return "", fmt.Errorf("currency %d not found", id)
+	}
+	return name, nil
+}
+
+// GetProduct get product by id
+func (m *MemoryDataManager) GetProduct(ctx context.Context, id uint64) (*Product, error) {
+	product, ok := m.products[id]
+	if !ok {
+		return nil, fmt.Errorf("product %d not found", id)
+	}
+	return product, nil
+}
+
+// GetOrder get order by id
+func (m *MemoryDataManager) GetOrder(ctx context.Context, id uint64) (*Order, error) {
+	order, ok := m.orders[id]
+	if !ok {
+		return nil, fmt.Errorf("order %d not found", id)
+	}
+	// copy, since account ids are filled by caller
+	result := *order
+	return &result, nil
+}
+
+func inNonceRange(nonce uint64, fromNonce, toNonce *big.Int) bool {
+	n := new(big.Int).SetUint64(nonce)
+	return n.Cmp(fromNonce) >= 0 && n.Cmp(toNonce) <= 0
+}
+
+// GetTrades get trade with tx_nonce between fromNonce and toNonce
+func (m *MemoryDataManager) GetTrades(ctx context.Context, fromNonce *big.Int, toNonce *big.Int) ([]*Trade, error) {
+	trades := []*Trade{}
+	for _, trade := range m.fixture.Trades {
+		if !inNonceRange(trade.TxNonce, fromNonce, toNonce) {
+			continue
+		}
+		trades = append(trades, &Trade{
+			id:           trade.ID,
+			txNonce:      trade.TxNonce,
+			productID:    trade.ProductID,
+			takerOrderID: trade.TakerOrderID,
+			makerOrderID: trade.MakerOrderID,
+			price:        trade.Price,
+			amount:       trade.Amount,
+			side:         trade.Side,
+			takerFee:     trade.TakerFee,
+			makerFee:     trade.MakerFee,
+		})
+	}
+	return trades, nil
+}
+
+// GetWithdraws get withdraw record with tx_nonce between fromNonce and toNonce
+func (m *MemoryDataManager) GetWithdraws(ctx context.Context, fromNonce *big.Int, toNonce *big.Int) ([]*Withdraw, error) {
+	withdraws := []*Withdraw{}
+	for _, withdraw := range m.fixture.Withdraws {
+		if !inNonceRange(withdraw.TxNonce, fromNonce, toNonce) {
+			continue
+		}
+		withdraws = append(withdraws, &Withdraw{
+			id:          withdraw.ID,
+			txNonce:     withdraw.TxNonce,
+			userAddress: withdraw.UserAddress,
+			currency:    withdraw.Currency,
+			amount:      withdraw.Amount,
+		})
+	}
+	return withdraws, nil
+}
+
+// GetTransfers get transfer records between fromNonce and toNonce
+func (m *MemoryDataManager) GetTransfers(ctx context.Context, fromNonce, toNonce *big.Int) ([]*Transfer, error) {
+	transfers := []*Transfer{}
+	for _, transfer := range m.fixture.Transfers {
+		if !inNonceRange(transfer.TxNonce, fromNonce, toNonce) {
+			continue
+		}
+		transfers = append(transfers, &Transfer{
+			id:          transfer.ID,
+			txNonce:     transfer.TxNonce,
+			userAddress: transfer.UserAddress,
+			currency:    transfer.Currency,
+			recipients:  transfer.Recipients,
+		})
+	}
+	return transfers, nil
+}
+
+// GetDeposits get deposit records between fromEpoch and toEpoch
+func (m *MemoryDataManager) GetDeposits(ctx context.Context, fromEpoch, toEpoch *big.Int) ([]*Deposit, error) {
+	deposits := []*Deposit{}
+	for _, deposit := range m.fixture.Deposits {
+		epoch := new(big.Int).SetUint64(deposit.Epoch)
+		if epoch.Cmp(fromEpoch) < 0 || epoch.Cmp(toEpoch) > 0 {
+			continue
+		}
+		deposits = append(deposits, &Deposit{
+			id:          deposit.ID,
+			userAddress: deposit.UserAddress,
+			currency:    deposit.Currency,
+			amount:      deposit.Amount,
+			txHash:      deposit.TxHash,
+		})
+	}
+	return deposits, nil
+}
+
+// CleanCache does nothing, since all data is in memory.
+func (m *MemoryDataManager) CleanCache() {}
@@ -0,0 +1,307 @@
+package matchflow
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Conflux-Chain/go-conflux-sdk/types"
+	"github.com/open-dex/conflux-dex-audit/common"
+	"github.com/pkg/errors"
+)
+
+// Kinds of off-chain records.
+const (
+	recordTrade    = "trade"
+	recordWithdraw = "withdraw"
+	recordDeposit  = "deposit"
+	recordTransfer = "transfer"
+)
+
+// recordSource identifies the off-chain record that a balance change comes from.
+type recordSource struct {
+	kind    string
+	id      uint64
+	txNonce uint64 // nonce of DEX admin to settle the record, zero for deposit
+	txHash  string // transaction hash of deposit
+}
+
+func (t *Trade) source() *recordSource {
+	return &recordSource{kind: recordTrade, id: t.id, txNonce: t.txNonce}
+}
+
+func (w *Withdraw) source() *recordSource {
+	return &recordSource{kind: recordWithdraw, id: w.id, txNonce: w.txNonce}
+}
+
+func (d *Deposit) source() *recordSource {
+	return &recordSource{kind: recordDeposit, id: d.id, txHash: d.txHash}
+}
+
+func (t *Transfer) source() *recordSource {
+	return &recordSource{kind: recordTransfer, id: t.id, txNonce: t.txNonce}
+}
+
+// mismatchReport is the per-account breakdown of mismatched balance changes in an audit window.
+type mismatchReport struct {
+	FromEpoch *big.Int         `json:"fromEpoch"`
+	ToEpoch   *big.Int         `json:"toEpoch"`
+	IsFull    bool             `json:"isFull"`
+	Time      string           `json:"time"`
+	Accounts  []*accountReport `json:"accounts"`
+}
+
+// accountReport is the off-chain records and on-chain CRCL Transfer events of a mismatched account.
+type accountReport struct {
+	AccountID uint64          `json:"accountId"`
+	Address   string          `json:"address,omitempty"` // empty if balance not changed on chain
+	Currency  string          `json:"currency,omitempty"`
+	Onchain   *big.Int        `json:"onchain"`  // balance change on chain
+	Offchain  *big.Int        `json:"offchain"` // balance change replayed off chain
+	Records   []*reportRecord `json:"records"`
+	Events    []*reportEvent  `json:"events"`
+}
+
+// reportRecord is an off-chain record that contributes to the balance change of account.
+type reportRecord struct {
+	Kind    string   `json:"kind"`
+	ID      uint64   `json:"id"`
+	TxNonce uint64   `json:"txNonce,omitempty"`
+	TxHash  string   `json:"txHash,omitempty"`
+	Amount  string   `json:"amount"` // signed off-chain amount
+	Change  *big.Int `json:"change"` // signed amount in minimum unit on chain
+}
+
+// reportEvent is a CRCL Transfer event of account on chain.
+type reportEvent struct {
+	Epoch     *big.Int `json:"epoch"`
+	TxHash    string   `json:"txHash"`
+	Sender    string   `json:"sender"`
+	Recipient string   `json:"recipient"`
+	Amount    *big.Int `json:"amount"`
+	Change    *big.Int `json:"change"` // signed amount for account
+}
+
+// findMismatches returns the sorted ids of accounts whose balance change differs between
+// onchain and offchain.
+func findMismatches(onchain, offchain map[uint64]*big.Int) []uint64 {
+	var result []uint64
+	for accountID, onchainAmount := range onchain {
+		if offchainAmount, ok := offchain[accountID]; !ok || onchainAmount.Cmp(offchainAmount) != 0 {
+			result = append(result, accountID)
+		}
+	}
+	for accountID := range offchain {
+		if _, ok := onchain[accountID]; !ok {
+			result = append(result, accountID)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+// buildMismatchReport builds the per-account breakdown of mismatched accounts.
+func (w *Worker) buildMismatchReport(ctx context.Context, fromEpoch, toEpoch *big.Int, isFull bool,
+	mismatches []uint64, onchain map[uint64]*BalanceChange, offchain map[uint64]*big.Int, details []*TradeDetail) (*mismatchReport, error) {
+	report := mismatchReport{
+		FromEpoch: fromEpoch,
+		ToEpoch:   toEpoch,
+		IsFull:    isFull,
+		Time:      time.Now().Format(time.RFC3339),
+	}
+
+	accounts := make(map[uint64]*accountReport)
+	for _, accountID := range mismatches {
+		account := accountReport{
+			AccountID: accountID,
+			Onchain:   big.NewInt(0),
+			Offchain:  big.NewInt(0),
+			Records:   []*reportRecord{},
+			Events:    []*reportEvent{},
+		}
+		if change, ok := onchain[accountID]; ok {
+			account.Address = change.address
+			account.Currency = change.currency
+			account.Onchain = change.amount
+		}
+		if amount, ok := offchain[accountID]; ok {
+			account.Offchain = amount
+		}
+		accounts[accountID] = &account
+		report.Accounts = append(report.Accounts, &account)
+	}
+
+	for _, detail := range details {
+		account, ok := accounts[detail.userID]
+		if !ok {
+			continue
+		}
+		account.Currency = detail.currency
+		record := reportRecord{
+			Amount: detail.amount.String(),
+			Change: w.toMinUnit(detail.amount, detail.currency),
+		}
+		if detail.source != nil {
+			record.Kind = detail.source.kind
+			record.ID = detail.source.id
+			record.TxNonce = detail.source.txNonce
+			record.TxHash = detail.source.txHash
+		}
+		account.Records = append(account.Records, &record)
+	}
+
+	for _, account := range report.Accounts {
+		if len(account.Address) == 0 {
+			continue
+		}
+		events, err := w.getTransferEvents(ctx, account.Currency, account.Address, fromEpoch, toEpoch)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "failed to get transfer events of account %d", account.AccountID)
+		}
+		account.Events = events
+	}
+
+	return &report, nil
+}
+
+// addressToTopic converts a hex address to topic of event log.
+func addressToTopic(address string) types.Hash {
+	return types.Hash("0x000000000000000000000000" + strings.TrimPrefix(strings.ToLower(address), "0x"))
+}
+
+// getTransferEvents returns the CRCL Transfer events sent or received by the address between
+// fromEpoch and toEpoch. Event logs are polled by the shared fetcher, so that the number of
+// epochs and logs per query are limited, and audit fails instead of on truncated logs.
+func (w *Worker) getTransferEvents(ctx context.Context, asset, address string, fromEpoch, toEpoch *big.Int) ([]*reportEvent, error) {
+	contract, ok := w.assetsMap[asset]
+	if !ok {
+		return nil, fmt.Errorf("asset %s not found", asset)
+	}
+
+	topic := addressToTopic(address)
+	addresses := []types.Address{*contract.Contract.Address}
+	fetchers := []*common.EpochLogFetcher{
+		common.NewEpochLogFetcher(w.cfxClient, addresses, [][]types.Hash{{common.EventHashTransfer}, {topic}}),
+		common.NewEpochLogFetcher(w.cfxClient, addresses, [][]types.Hash{{common.EventHashTransfer}, nil, {topic}}),
+	}
+
+	events := []*reportEvent{}
+	for epoch := new(big.Int).Set(fromEpoch); epoch.Cmp(toEpoch) <= 0; epoch.Add(epoch, common.Big1) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		for i, fetcher := range fetchers {
+			logs, err := fetcher.GetLogs(epoch)
+			if err != nil {
+				return nil, errors.WithMessagef(err, "failed to get event logs of %s at epoch %s", asset, epoch)
+			}
+
+			for _, log := range logs {
+				sender, recipient := DataToAddress(log.Topics[1].String()), DataToAddress(log.Topics[2].String())
+				// transfer to self is already got by sender
+				if i > 0 && sender == recipient {
+					continue
+				}
+
+				amount, ok := new(big.Int).SetString(strings.TrimPrefix(log.Data.String(), "0x"), 16)
+				if !ok {
+					return nil, fmt.Errorf("invalid data of Transfer event %s", log.Data)
+				}
+
+				change := new(big.Int)
+				if recipient == strings.ToLower(address) {
+					change.Add(change, amount)
+				}
+				if sender == strings.ToLower(address) {
+					change.Sub(change, amount)
+				}
+
+				events = append(events, &reportEvent{
+					Epoch:     new(big.Int).Set(epoch),
+					TxHash:    log.TransactionHash.String(),
+					Sender:    sender,
+					Recipient: recipient,
+					Amount:    amount,
+					Change:    change,
+				})
+			}
+		}
+	}
+
+	return events, nil
+}
+
+// writeMismatchReport writes the report into JSON and CSV files in report folder, and returns
+// the link of JSON file to be referenced in alerts.
+func (w *Worker) writeMismatchReport(report *mismatchReport) (string, error) {
+	if err := os.MkdirAll(w.reportDir, 0755); err != nil {
+		return "", errors.WithMessage(err, "failed to create report folder")
+	}
+
+	name := fmt.Sprintf("mismatch-%s-%s-%d", report.FromEpoch, report.ToEpoch, time.Now().Unix())
+
+	data, err := json.MarshalIndent(report, "", "    ")
+	if err != nil {
+		return "", errors.WithMessage(err, "failed to marshal report")
+	}
+
+	jsonPath := filepath.Join(w.reportDir, name+".json")
+	if err = ioutil.WriteFile(jsonPath, data, 0644); err != nil {
+		return "", errors.WithMessage(err, "failed to write JSON report")
+	}
+
+	if err = writeMismatchCSV(filepath.Join(w.reportDir, name+".csv"), report); err != nil {
+		return "", err
+	}
+
+	if len(w.reportURL) > 0 {
+		return strings.TrimRight(w.reportURL, "/") + "/" + name + ".json", nil
+	}
+
+	return jsonPath, nil
+}
+
+// writeMismatchCSV writes the report with a row for each off-chain record or on-chain event.
+func writeMismatchCSV(path string, report *mismatchReport) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return errors.WithMessage(err, "failed to create CSV report")
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"accountId", "address", "currency", "onchain", "offchain",
+		"side", "kind", "id", "txNonce", "txHash", "epoch", "sender", "recipient", "amount", "change"})
+
+	for _, account := range report.Accounts {
+		prefix := []string{strconv.FormatUint(account.AccountID, 10), account.Address, account.Currency,
+			account.Onchain.String(), account.Offchain.String()}
+
+		for _, record := range account.Records {
+			writer.Write(append(prefix, "offchain", record.Kind, strconv.FormatUint(record.ID, 10),
+				strconv.FormatUint(record.TxNonce, 10), record.TxHash, "", "", "", record.Amount, record.Change.String()))
+		}
+
+		for _, event := range account.Events {
+			writer.Write(append(prefix, "onchain", "Transfer", "", "", event.TxHash, event.Epoch.String(),
+				event.Sender, event.Recipient, event.Amount.String(), event.Change.String()))
+		}
+	}
+
+	writer.Flush()
+	if err = writer.Error(); err != nil {
+		return errors.WithMessage(err, "failed to write CSV report")
+	}
+
+	return nil
+}
@@ -1,24 +1,29 @@
 package matchflow
 
 import (
+	"context"
 	"encoding/csv"
 	"fmt"
 	"math/big"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	conflux "github.com/Conflux-Chain/go-conflux-sdk"
 	"github.com/Conflux-Chain/go-conflux-sdk/types"
-	"github.com/Conflux-Chain/go-conflux-sdk/types/cfxaddress"
 	"github.com/open-dex/conflux-dex-audit/common"
+	"github.com/pkg/errors"
 	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
 )
 
 const (
-	ten18           = "1000000000000000000" // 1e18
-	module          = "matchflow"           // module name
-	maxGoroutineNum = 5                     // max goroutine number
+	module          = "matchflow" // module name
+	maxGoroutineNum = 5           // max goroutine number
+	defaultDecimals = 18          // decimals of currency not listed in assets
+
+	maxWindowFailures = 10 // alert once an audit window failed consecutively
 )
 
 // Worker auditor
@@ -27,14 +32,32 @@ type Worker struct {
 	lastPartialAuditEpoch *big.Int
 	matchflowClient       *common.Client
 	cfxClient             *conflux.Client
-	db                    *DataManager
+	db                    DataManager
 	assetsMap             map[string]*common.Contract
+	decimals              map[string]int32 // decimals of currency, key is currency name
 	pausable              bool
+	pivots                *common.PivotTracker
+	auditedWindows        uint64        // number of audited windows
+	windowFailures        int           // number of consecutive failures of current window
+	store                 *common.Store // checkpoint store of audit cursor, nil if disabled
+	reportDir             string        // folder to write mismatch reports
+	reportURL             string        // URL prefix to link mismatch reports in alerts
+	precision             precisionViolations
+
+	// adminNonce returns the next nonce of DEX admin at epoch, which determines the off-chain
+	// records settled in a window of epochs. It queries full node unless replaced in test.
+	adminNonce func(epoch *big.Int) (*big.Int, error)
+
+	// next nonce of DEX admin at the end epoch of last replayed window, reused to save checkpoint
+	lastNonceEpoch *big.Int
+	lastNonce      *big.Int
 }
 
 // BalanceChange user with `accountID` has `amount` change of balance
 type BalanceChange struct {
 	accountID uint64
+	address   string
+	currency  string
 	amount    *big.Int
 }
 
@@ -44,28 +67,96 @@ func DataToAddress(data string) string {
 }
 
 // NewWorker create a new worker
-func NewWorker(matchflowClient *common.Client, cfxClient *conflux.Client, assetsMap map[string]*common.Contract, config *common.MatchflowConfig) *Worker {
+func NewWorker(matchflowClient *common.Client, cfxClient *conflux.Client, assetsMap map[string]*common.Contract, decimals map[string]int32, db DataManager, config *common.MatchflowConfig) *Worker {
 	w := &Worker{
 		matchflowClient: matchflowClient,
 		cfxClient:       cfxClient,
-		db:              NewDataManager(config.DbUser, config.DbAddress, config.DbPass, config.DexStartTime),
+		db:              db,
 		assetsMap:       assetsMap,
+		decimals:        decimals,
 		pausable:        config.Pausable,
+		pivots:          common.NewPivotTracker(cfxClient, module),
+		reportDir:       config.ReportDir,
+		reportURL:       config.ReportURL,
 	}
+	w.adminNonce = w.queryAdminNonce
 	return w
 }
 
-func (w *Worker) getOnchainBalanceChange(userAddress, currency string, fromEpoch, toEpoch *big.Int, ch chan *BalanceChange) {
-	accountID := w.db.MustGetAccountID(userAddress, currency)
-	fromBalance := w.assetsMap[currency].MustGetBalanceOf(userAddress, types.NewEpochNumberBig(big.NewInt(0).Sub(fromEpoch, big.NewInt(1))))
-	toBalance := w.assetsMap[currency].MustGetBalanceOf(userAddress, types.NewEpochNumberBig(toEpoch))
-	ch <- &BalanceChange{
+func (w *Worker) getOnchainBalanceChange(ctx context.Context, userAddress, currency string, fromEpoch, toEpoch *big.Int) (*BalanceChange, error) {
+	accountID, err := w.db.GetAccountID(ctx, userAddress, currency)
+	if err != nil {
+		return nil, err
+	}
+	fromBalance, err := w.assetsMap[currency].BalanceOf(userAddress, types.NewEpochNumberBig(big.NewInt(0).Sub(fromEpoch, big.NewInt(1))))
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to get %s balance of %s before epoch %s", currency, userAddress, fromEpoch)
+	}
+	toBalance, err := w.assetsMap[currency].BalanceOf(userAddress, types.NewEpochNumberBig(toEpoch))
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to get %s balance of %s at epoch %s", currency, userAddress, toEpoch)
+	}
+	return &BalanceChange{
 		accountID: accountID,
+		address:   userAddress,
+		currency:  currency,
 		amount:    big.NewInt(0).Sub(toBalance, fromBalance),
+	}, nil
+}
+
+// runInBatch runs n tasks in goroutines, at most maxGoroutineNum at the same time. Once any
+// task failed, the context of other tasks is cancelled and the error is returned.
+func runInBatch(ctx context.Context, n int, task func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sem := make(chan struct{}, maxGoroutineNum)
+	errCh := make(chan error, 1)
+	var wg sync.WaitGroup
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := task(ctx, i); err != nil {
+				select {
+				case errCh <- err:
+				default:
+				}
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	select {
+	case err := <-errCh:
+		return err
+	default:
+		return ctx.Err()
 	}
 }
 
-func (w *Worker) assetSync(asset string, fromEpoch *big.Int, toEpoch *big.Int, ch chan map[uint64]*big.Int, isFull bool) {
+// listAccounts lists all accounts in CRCL at the specified epoch.
+func listAccounts(c *common.Contract, epoch *types.Epoch) ([]string, error) {
+	total, err := c.TotalAccount(epoch)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to get total number of accounts")
+	}
+	addresses := []string{}
+	for offset := big.NewInt(0); offset.Cmp(total) < 0; {
+		ret, err := c.ListAccounts(offset, epoch)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "failed to list accounts since offset %s", offset)
+		}
+		addresses = append(addresses, ret...)
+		offset.Add(offset, big.NewInt(int64(len(ret))))
+	}
+	return addresses, nil
+}
+
+func (w *Worker) assetSync(ctx context.Context, asset string, fromEpoch *big.Int, toEpoch *big.Int, isFull bool) (map[uint64]*BalanceChange, error) {
 	addresses := make(map[string]bool)
 	if !isFull {
 		// just audit balance of addresses appeared in events
@@ -76,9 +167,13 @@ func (w *Worker) assetSync(asset string, fromEpoch *big.Int, toEpoch *big.Int, c
 				Address:   []types.Address{*w.assetsMap[asset].Contract.Address},
 			})
 			if err != nil {
-				panic(err)
+				return nil, errors.WithMessagef(err, "failed to get event logs of %s at epoch %s", asset, i)
 			}
 			for _, log := range logs {
+				if len(log.Topics) == 0 {
+					continue
+				}
+
 				switch log.Topics[0] {
 				case common.EventHashTransfer:
 					addresses[DataToAddress(log.Topics[1].String())] = true
@@ -92,46 +187,63 @@ func (w *Worker) assetSync(asset string, fromEpoch *big.Int, toEpoch *big.Int, c
 	} else {
 		// audit all addresses
 		epoch := types.NewEpochNumberBig(toEpoch)
-		ret := w.assetsMap[asset].ListAllAccounts(epoch)
+		ret, err := listAccounts(w.assetsMap[asset], epoch)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "failed to list accounts of %s", asset)
+		}
 		for _, address := range ret {
 			addresses[address] = true
 		}
 	}
 	delete(addresses, common.ZeroAddress)
-	result := make(map[uint64]*big.Int)
-	balanceChangeCh := make(chan *BalanceChange)
-	k := 0
+
+	var accounts []string
 	for address := range addresses {
-		if k == maxGoroutineNum {
-			for i := 0; i < k; i++ {
-				ans := <-balanceChangeCh
-				result[ans.accountID] = ans.amount
-			}
-			k = 0
-		}
-		go w.getOnchainBalanceChange(address, asset, fromEpoch, toEpoch, balanceChangeCh)
-		k++
+		accounts = append(accounts, address)
 	}
-	for i := 0; i < k; i++ {
-		ans := <-balanceChangeCh
-		result[ans.accountID] = ans.amount
+
+	var mu sync.Mutex
+	result := make(map[uint64]*BalanceChange)
+	err := runInBatch(ctx, len(accounts), func(ctx context.Context, i int) error {
+		ans, err := w.getOnchainBalanceChange(ctx, accounts[i], asset, fromEpoch, toEpoch)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		result[ans.accountID] = ans
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	ch <- result
+	return result, nil
 }
 
-func (w *Worker) onchainSync(fromEpoch *big.Int, toEpoch *big.Int, ch chan map[uint64]*big.Int, isFull bool) {
-	assetSyncCh := make(chan map[uint64]*big.Int)
+func (w *Worker) onchainSync(ctx context.Context, fromEpoch *big.Int, toEpoch *big.Int, isFull bool) (map[uint64]*BalanceChange, error) {
+	var assets []string
 	for k := range w.assetsMap {
-		go w.assetSync(k, fromEpoch, toEpoch, assetSyncCh, isFull)
+		assets = append(assets, k)
 	}
-	result := make(map[uint64]*big.Int)
-	for i := 0; i < len(w.assetsMap); i++ {
-		assetResult := <-assetSyncCh
+
+	var mu sync.Mutex
+	result := make(map[uint64]*BalanceChange)
+	err := runInBatch(ctx, len(assets), func(ctx context.Context, i int) error {
+		assetResult, err := w.assetSync(ctx, assets[i], fromEpoch, toEpoch, isFull)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
 		for k, v := range assetResult {
 			result[k] = v
 		}
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	ch <- result
+	return result, nil
 }
 
 func parseFloat(x string) decimal.Decimal {
@@ -142,31 +254,142 @@ func parseFloat(x string) decimal.Decimal {
 	return ans
 }
 
-func (w *Worker) parseTrade(trade *Trade, ch chan []*TradeDetail) {
-	product := w.db.GetProduct(trade.productID)
+// getDecimals returns the decimals of specified currency.
+func (w *Worker) getDecimals(currency string) int32 {
+	if decimals, ok := w.decimals[currency]; ok {
+		return decimals
+	}
+	return defaultDecimals
+}
+
+// parseAmount parses the off-chain amount of specified currency, and checks its precision.
+func (w *Worker) parseAmount(amount, currency string) (decimal.Decimal, error) {
+	value, err := decimal.NewFromString(amount)
+	if err != nil {
+		return value, errors.WithMessagef(err, "invalid amount %s of %s", amount, currency)
+	}
+	w.checkPrecision(value, currency)
+	return value, nil
+}
+
+// precisionViolations collects the off-chain amounts with precision beyond the granularity of
+// currency, so that they are alerted once per audit window instead of once per amount.
+type precisionViolations struct {
+	mu      sync.Mutex
+	count   int
+	samples []string // the first maxPrecisionSamples violations
+}
+
+const maxPrecisionSamples = 5
+
+func (v *precisionViolations) add(violation string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.count++
+	if len(v.samples) < maxPrecisionSamples {
+		v.samples = append(v.samples, violation)
+	}
+}
+
+// flush returns the collected violations as a message, or empty if none, and then clears them.
+func (v *precisionViolations) flush() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.count == 0 {
+		return ""
+	}
+	msg := fmt.Sprintf("%d amounts have precision beyond decimals of currency, e.g. %s", v.count, strings.Join(v.samples, "; "))
+	v.count, v.samples = 0, nil
+	return msg
+}
+
+// alertPrecisionViolations alerts once for the violations collected in scope, e.g. audit window.
+func (w *Worker) alertPrecisionViolations(scope string) {
+	if msg := w.precision.flush(); len(msg) > 0 {
+		msg = fmt.Sprintf("%s in %s", msg, scope)
+		logger.Error(msg)
+		common.Alert(module, msg)
+	}
+}
 
-	baseCurrencyName := w.db.GetCurrencyName(product.baseCurrencyID)
-	quoteCurrencyName := w.db.GetCurrencyName(product.quoteCurrencyID)
+// checkPrecision collects the violation if the off-chain amount has precision beyond the granularity of
+// currency on chain, which will be truncated when converted to the minimum unit.
+func (w *Worker) checkPrecision(amount decimal.Decimal, currency string) {
+	decimals := w.getDecimals(currency)
+	if amount.Equal(amount.Truncate(decimals)) {
+		return
+	}
+	violation := fmt.Sprintf("amount %s of %s has precision beyond %d decimals", amount, currency, decimals)
+	logger.Warn(violation)
+	w.precision.add(violation)
+}
 
-	takerOrder := w.db.GetOrder(trade.takerOrderID)
-	makerOrder := w.db.GetOrder(trade.makerOrderID)
+// toMinUnit converts the off-chain amount of specified currency to the minimum unit on chain.
+func (w *Worker) toMinUnit(amount decimal.Decimal, currency string) *big.Int {
+	return amount.Shift(w.getDecimals(currency)).BigInt()
+}
 
-	takerOrder.baseAccountID = w.db.MustGetAccountIDByUserID(takerOrder.userID, baseCurrencyName)
-	takerOrder.quoteAccountID = w.db.MustGetAccountIDByUserID(takerOrder.userID, quoteCurrencyName)
-	makerOrder.baseAccountID = w.db.MustGetAccountIDByUserID(makerOrder.userID, baseCurrencyName)
-	makerOrder.quoteAccountID = w.db.MustGetAccountIDByUserID(makerOrder.userID, quoteCurrencyName)
+func (w *Worker) parseTrade(ctx context.Context, trade *Trade) ([]*TradeDetail, error) {
+	product, err := w.db.GetProduct(ctx, trade.productID)
+	if err != nil {
+		return nil, err
+	}
+
+	baseCurrencyName, err := w.db.GetCurrencyName(ctx, product.baseCurrencyID)
+	if err != nil {
+		return nil, err
+	}
+	quoteCurrencyName, err := w.db.GetCurrencyName(ctx, product.quoteCurrencyID)
+	if err != nil {
+		return nil, err
+	}
+
+	takerOrder, err := w.db.GetOrder(ctx, trade.takerOrderID)
+	if err != nil {
+		return nil, err
+	}
+	makerOrder, err := w.db.GetOrder(ctx, trade.makerOrderID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, order := range []*Order{takerOrder, makerOrder} {
+		if order.baseAccountID, err = w.db.GetAccountIDByUserID(ctx, order.userID, baseCurrencyName); err != nil {
+			return nil, err
+		}
+		if order.quoteAccountID, err = w.db.GetAccountIDByUserID(ctx, order.userID, quoteCurrencyName); err != nil {
+			return nil, err
+		}
+	}
 
 	details := []*TradeDetail{}
-	tradeAmount := parseFloat(trade.amount)
-	tradePrice := parseFloat(trade.price)
-	tradeFunds := parseFloat(trade.amount)
-	tradeFunds = common.Mul(tradeFunds, tradePrice)
-	takerFee := parseFloat(trade.takerFee)
-	makerFee := parseFloat(trade.makerFee)
+	tradeAmount, err := w.parseAmount(trade.amount, baseCurrencyName)
+	if err != nil {
+		return nil, err
+	}
+	tradePrice, err := decimal.NewFromString(trade.price)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "invalid trade price %s", trade.price)
+	}
+	tradeFunds := tradeAmount.Mul(tradePrice).Truncate(w.getDecimals(quoteCurrencyName))
 
 	if trade.side == "Buy" {
-		takerOrder.feeAccountID = w.db.MustGetAccountID(takerOrder.feeAddress, baseCurrencyName)
-		makerOrder.feeAccountID = w.db.MustGetAccountID(makerOrder.feeAddress, quoteCurrencyName)
+		// taker pays fee in base currency, and maker pays fee in quote currency
+		takerFee, err := w.parseAmount(trade.takerFee, baseCurrencyName)
+		if err != nil {
+			return nil, err
+		}
+		makerFee, err := w.parseAmount(trade.makerFee, quoteCurrencyName)
+		if err != nil {
+			return nil, err
+		}
+
+		if takerOrder.feeAccountID, err = w.db.GetAccountID(ctx, takerOrder.feeAddress, baseCurrencyName); err != nil {
+			return nil, err
+		}
+		if makerOrder.feeAccountID, err = w.db.GetAccountID(ctx, makerOrder.feeAddress, quoteCurrencyName); err != nil {
+			return nil, err
+		}
 		/*
 			refundAmount := parseFloat("0")
 			if takerOrder.orderType == "Limit" {
@@ -177,212 +400,254 @@ func (w *Worker) parseTrade(trade *Trade, ch chan []*TradeDetail) {
 
 		// taker side
 		details = append(details, &TradeDetail{
-			userID: takerOrder.baseAccountID,
-			amount: tradeAmount.Sub(takerFee),
+			userID:   takerOrder.baseAccountID,
+			currency: baseCurrencyName,
+			amount:   tradeAmount.Sub(takerFee),
 		})
 		details = append(details, &TradeDetail{
-			userID: takerOrder.feeAccountID,
-			amount: takerFee,
+			userID:   takerOrder.feeAccountID,
+			currency: baseCurrencyName,
+			amount:   takerFee,
 		})
 		details = append(details, &TradeDetail{
-			userID: takerOrder.quoteAccountID,
-			amount: tradeFunds.Neg(),
+			userID:   takerOrder.quoteAccountID,
+			currency: quoteCurrencyName,
+			amount:   tradeFunds.Neg(),
 		})
 		// maker side
 		details = append(details, &TradeDetail{
-			userID: makerOrder.baseAccountID,
-			amount: tradeAmount.Neg(),
+			userID:   makerOrder.baseAccountID,
+			currency: baseCurrencyName,
+			amount:   tradeAmount.Neg(),
 		})
 		details = append(details, &TradeDetail{
-			userID: makerOrder.quoteAccountID,
-			amount: tradeFunds.Sub(makerFee),
+			userID:   makerOrder.quoteAccountID,
+			currency: quoteCurrencyName,
+			amount:   tradeFunds.Sub(makerFee),
 		})
 		details = append(details, &TradeDetail{
-			userID: makerOrder.feeAccountID,
-			amount: makerFee,
+			userID:   makerOrder.feeAccountID,
+			currency: quoteCurrencyName,
+			amount:   makerFee,
 		})
 	} else {
-		takerOrder.feeAccountID = w.db.MustGetAccountID(takerOrder.feeAddress, quoteCurrencyName)
-		makerOrder.feeAccountID = w.db.MustGetAccountID(makerOrder.feeAddress, baseCurrencyName)
+		// taker pays fee in quote currency, and maker pays fee in base currency
+		takerFee, err := w.parseAmount(trade.takerFee, quoteCurrencyName)
+		if err != nil {
+			return nil, err
+		}
+		makerFee, err := w.parseAmount(trade.makerFee, baseCurrencyName)
+		if err != nil {
+			return nil, err
+		}
+
+		if takerOrder.feeAccountID, err = w.db.GetAccountID(ctx, takerOrder.feeAddress, quoteCurrencyName); err != nil {
+			return nil, err
+		}
+		if makerOrder.feeAccountID, err = w.db.GetAccountID(ctx, makerOrder.feeAddress, baseCurrencyName); err != nil {
+			return nil, err
+		}
 
 		// taker side
 		details = append(details, &TradeDetail{
-			userID: takerOrder.baseAccountID,
-			amount: tradeAmount.Neg(),
+			userID:   takerOrder.baseAccountID,
+			currency: baseCurrencyName,
+			amount:   tradeAmount.Neg(),
 		})
 		details = append(details, &TradeDetail{
-			userID: takerOrder.quoteAccountID,
-			amount: tradeFunds.Sub(takerFee),
+			userID:   takerOrder.quoteAccountID,
+			currency: quoteCurrencyName,
+			amount:   tradeFunds.Sub(takerFee),
 		})
 		details = append(details, &TradeDetail{
-			userID: takerOrder.feeAccountID,
-			amount: takerFee,
+			userID:   takerOrder.feeAccountID,
+			currency: quoteCurrencyName,
+			amount:   takerFee,
 		})
 		// maker side
 		details = append(details, &TradeDetail{
-			userID: makerOrder.baseAccountID,
-			amount: tradeAmount.Sub(makerFee),
+			userID:   makerOrder.baseAccountID,
+			currency: baseCurrencyName,
+			amount:   tradeAmount.Sub(makerFee),
 		})
 		details = append(details, &TradeDetail{
-			userID: makerOrder.feeAccountID,
-			amount: makerFee,
+			userID:   makerOrder.feeAccountID,
+			currency: baseCurrencyName,
+			amount:   makerFee,
 		})
 		details = append(details, &TradeDetail{
-			userID: makerOrder.quoteAccountID,
-			amount: tradeFunds.Neg(),
+			userID:   makerOrder.quoteAccountID,
+			currency: quoteCurrencyName,
+			amount:   tradeFunds.Neg(),
 		})
 	}
-	ch <- details
+	return details, nil
 }
 
-func (w *Worker) parseWithdraw(withdraw *Withdraw, ch chan []*TradeDetail) {
+func (w *Worker) parseWithdraw(ctx context.Context, withdraw *Withdraw) ([]*TradeDetail, error) {
+	accountID, err := w.db.GetAccountID(ctx, withdraw.userAddress, withdraw.currency)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := w.parseAmount(withdraw.amount, withdraw.currency)
+	if err != nil {
+		return nil, err
+	}
 	details := []*TradeDetail{}
 	details = append(details, &TradeDetail{
-		userID: w.db.MustGetAccountID(withdraw.userAddress, withdraw.currency),
-		amount: parseFloat(withdraw.amount).Neg(),
+		userID:   accountID,
+		currency: withdraw.currency,
+		amount:   amount.Neg(),
 	})
-	ch <- details
+	return details, nil
 }
 
-func (w *Worker) parseDeposit(deposit *Deposit, ch chan []*TradeDetail) {
+func (w *Worker) parseDeposit(ctx context.Context, deposit *Deposit) ([]*TradeDetail, error) {
+	accountID, err := w.db.GetAccountID(ctx, deposit.userAddress, deposit.currency)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := w.parseAmount(deposit.amount, deposit.currency)
+	if err != nil {
+		return nil, err
+	}
 	details := []*TradeDetail{}
 	details = append(details, &TradeDetail{
-		userID: w.db.MustGetAccountID(deposit.userAddress, deposit.currency),
-		amount: parseFloat(deposit.amount),
+		userID:   accountID,
+		currency: deposit.currency,
+		amount:   amount,
 	})
-	ch <- details
+	return details, nil
 }
 
-func (w *Worker) parseTransfer(transfer *Transfer, ch chan []*TradeDetail) {
+func (w *Worker) parseTransfer(ctx context.Context, transfer *Transfer) ([]*TradeDetail, error) {
 	details := []*TradeDetail{}
 	pairs := strings.Split(strings.Trim(transfer.recipients, "{}"), ",")
-	senderAccountID := w.db.MustGetAccountID(transfer.userAddress, transfer.currency)
+	senderAccountID, err := w.db.GetAccountID(ctx, transfer.userAddress, transfer.currency)
+	if err != nil {
+		return nil, err
+	}
 	for _, pair := range pairs {
 		elements := strings.Split(pair, ":")
+		if len(elements) != 2 {
+			return nil, fmt.Errorf("invalid transfer recipients %s", transfer.recipients)
+		}
 		recipientAddress := strings.Trim(elements[0], `"`)
-		recipientAccountID := w.db.MustGetAccountID(recipientAddress, transfer.currency)
-		recipientAmount := parseFloat(elements[1])
+		recipientAccountID, err := w.db.GetAccountID(ctx, recipientAddress, transfer.currency)
+		if err != nil {
+			return nil, err
+		}
+		recipientAmount, err := w.parseAmount(elements[1], transfer.currency)
+		if err != nil {
+			return nil, err
+		}
 		details = append(details, &TradeDetail{
-			userID: senderAccountID,
-			amount: recipientAmount.Neg(),
+			userID:   senderAccountID,
+			currency: transfer.currency,
+			amount:   recipientAmount.Neg(),
 		})
 		details = append(details, &TradeDetail{
-			userID: recipientAccountID,
-			amount: recipientAmount,
+			userID:   recipientAccountID,
+			currency: transfer.currency,
+			amount:   recipientAmount,
 		})
 	}
-	ch <- details
+	return details, nil
 }
 
-func (w *Worker) offchainReplay(fromEpoch *big.Int, toEpoch *big.Int, ch chan map[uint64]*big.Int) {
+// offchainReplay replays the off-chain records between fromEpoch and toEpoch, and returns the
+// balance change of accounts along with the balance changes of each record.
+func (w *Worker) offchainReplay(ctx context.Context, fromEpoch *big.Int, toEpoch *big.Int) (map[uint64]*big.Int, []*TradeDetail, error) {
 	// get nonce range
-	fromNonceWrap, err := w.cfxClient.GetNextNonce(cfxaddress.MustNewFromHex(common.DexAdmin, common.GetNetworkId()), types.NewEpochNumberBig(big.NewInt(0).Sub(fromEpoch, big.NewInt(1))))
+	fromNonce, err := w.adminNonce(new(big.Int).Sub(fromEpoch, common.Big1))
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
-	toNonceWrap, err := w.cfxClient.GetNextNonce(cfxaddress.MustNewFromHex(common.DexAdmin, common.GetNetworkId()), types.NewEpochNumberBig(toEpoch))
+	toNonce, err := w.adminNonce(toEpoch)
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
-	fromNonce := fromNonceWrap.ToInt()
-	toNonce := toNonceWrap.ToInt()
-	toNonce.Sub(toNonce, big.NewInt(1))
+	w.lastNonceEpoch, w.lastNonce = new(big.Int).Set(toEpoch), new(big.Int).Set(toNonce)
+	toNonce = new(big.Int).Sub(toNonce, common.Big1)
 	logger.Infof("nonce from %s to %s", fromNonce, toNonce)
 
-	tradeDetailCh := make(chan []*TradeDetail)
+	var mu sync.Mutex
 	details := []*TradeDetail{}
+	collect := func(source *recordSource, ret []*TradeDetail, err error) error {
+		if err != nil {
+			return err
+		}
+		for _, detail := range ret {
+			detail.source = source
+		}
+		mu.Lock()
+		details = append(details, ret...)
+		mu.Unlock()
+		return nil
+	}
 
-	k := 0
 	if fromNonce.Cmp(toNonce) <= 0 {
 		// get trades
-		trades := w.db.GetTrades(fromNonce, toNonce)
-		logger.Infof("trade amount: %d", len(trades))
-		for _, trade := range trades {
-			if k == maxGoroutineNum {
-				for i := 0; i < k; i++ {
-					ans := <-tradeDetailCh
-					details = append(details, ans...)
-				}
-				k = 0
-			}
-			go w.parseTrade(trade, tradeDetailCh)
-			k++
+		trades, err := w.db.GetTrades(ctx, fromNonce, toNonce)
+		if err != nil {
+			return nil, nil, err
 		}
-		for i := 0; i < k; i++ {
-			ans := <-tradeDetailCh
-			details = append(details, ans...)
+		logger.Infof("trade amount: %d", len(trades))
+		if err = runInBatch(ctx, len(trades), func(ctx context.Context, i int) error {
+			ret, err := w.parseTrade(ctx, trades[i])
+			return collect(trades[i].source(), ret, err)
+		}); err != nil {
+			return nil, nil, errors.WithMessage(err, "failed to parse trades")
 		}
 
 		// get withdraw records
-		withdraws := w.db.GetWithdraws(fromNonce, toNonce)
-		k = 0
-		for _, withdraw := range withdraws {
-			if k == maxGoroutineNum {
-				for i := 0; i < k; i++ {
-					ans := <-tradeDetailCh
-					details = append(details, ans...)
-				}
-				k = 0
-			}
-			go w.parseWithdraw(withdraw, tradeDetailCh)
-			k++
+		withdraws, err := w.db.GetWithdraws(ctx, fromNonce, toNonce)
+		if err != nil {
+			return nil, nil, err
 		}
-		for i := 0; i < k; i++ {
-			ans := <-tradeDetailCh
-			details = append(details, ans...)
+		if err = runInBatch(ctx, len(withdraws), func(ctx context.Context, i int) error {
+			ret, err := w.parseWithdraw(ctx, withdraws[i])
+			return collect(withdraws[i].source(), ret, err)
+		}); err != nil {
+			return nil, nil, errors.WithMessage(err, "failed to parse withdraws")
 		}
 
 		// get transfer records
-		k = 0
-		transfers := w.db.GetTransfers(fromNonce, toNonce)
-		for _, transfer := range transfers {
-			if k == maxGoroutineNum {
-				for i := 0; i < k; i++ {
-					ans := <-tradeDetailCh
-					details = append(details, ans...)
-				}
-				k = 0
-			}
-			go w.parseTransfer(transfer, tradeDetailCh)
-			k++
+		transfers, err := w.db.GetTransfers(ctx, fromNonce, toNonce)
+		if err != nil {
+			return nil, nil, err
 		}
-		for i := 0; i < k; i++ {
-			ans := <-tradeDetailCh
-			details = append(details, ans...)
+		if err = runInBatch(ctx, len(transfers), func(ctx context.Context, i int) error {
+			ret, err := w.parseTransfer(ctx, transfers[i])
+			return collect(transfers[i].source(), ret, err)
+		}); err != nil {
+			return nil, nil, errors.WithMessage(err, "failed to parse transfers")
 		}
 	}
 
 	// get deposit records
-	k = 0
-	deposits := w.db.GetDeposits(fromEpoch, toEpoch, w.cfxClient)
-	for _, deposit := range deposits {
-		if k == maxGoroutineNum {
-			for i := 0; i < k; i++ {
-				ans := <-tradeDetailCh
-				details = append(details, ans...)
-			}
-			k = 0
-		}
-		go w.parseDeposit(deposit, tradeDetailCh)
-		k++
+	deposits, err := w.db.GetDeposits(ctx, fromEpoch, toEpoch)
+	if err != nil {
+		return nil, nil, err
 	}
-	for i := 0; i < k; i++ {
-		ans := <-tradeDetailCh
-		details = append(details, ans...)
+	if err = runInBatch(ctx, len(deposits), func(ctx context.Context, i int) error {
+		ret, err := w.parseDeposit(ctx, deposits[i])
+		return collect(deposits[i].source(), ret, err)
+	}); err != nil {
+		return nil, nil, errors.WithMessage(err, "failed to parse deposits")
 	}
 
 	// conclude
 	result := make(map[uint64]*big.Int)
 	for _, detail := range details {
-		detailAmount := common.Mul(detail.amount, parseFloat(ten18))
-		detailAmountInt := detailAmount.BigInt()
+		detailAmountInt := w.toMinUnit(detail.amount, detail.currency)
 		if amount, ok := result[detail.userID]; ok {
 			amount.Add(amount, detailAmountInt)
 		} else {
 			result[detail.userID] = detailAmountInt
 		}
 	}
-	ch <- result
+	return result, details, nil
 }
 
 func filterZeroValue(m map[uint64]*big.Int) {
@@ -397,25 +662,79 @@ func filterZeroValue(m map[uint64]*big.Int) {
 	}
 }
 
-func (w *Worker) audit(fromEpoch *big.Int, toEpoch *big.Int, isFull bool) {
+// syncResult is the balance changes of accounts in an audit window, key is account id.
+type syncResult struct {
+	changes map[uint64]*big.Int
+	onchain map[uint64]*BalanceChange // onchain balance changes along with address and currency
+	details []*TradeDetail            // offchain balance changes of each record
+	err     error
+}
+
+// audit audits the balance changes between onchain and offchain for the window of epochs.
+// If failed to sync or replay balance changes, the error is returned so that the window
+// could be audited again.
+func (w *Worker) audit(ctx context.Context, fromEpoch *big.Int, toEpoch *big.Int, isFull bool) error {
 	logger.Infof("audit from %s to %s, isFull: %t\n", fromEpoch, toEpoch, isFull)
 
-	onchainSyncCh, offchainReplayCh := make(chan map[uint64]*big.Int), make(chan map[uint64]*big.Int)
-	go w.onchainSync(fromEpoch, toEpoch, onchainSyncCh, isFull)
-	go w.offchainReplay(fromEpoch, toEpoch, offchainReplayCh)
-	onchainResult, offchainResult := <-onchainSyncCh, <-offchainReplayCh
+	// drop violations of the last failed attempt, which are collected again
+	w.precision.flush()
+
+	// cancel the other side once any side failed
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	onchainSyncCh, offchainReplayCh := make(chan syncResult, 1), make(chan syncResult, 1)
+	go func() {
+		onchain, err := w.onchainSync(ctx, fromEpoch, toEpoch, isFull)
+		if err != nil {
+			cancel()
+			err = errors.WithMessage(err, "onchain sync failed")
+		}
+		changes := make(map[uint64]*big.Int)
+		for accountID, change := range onchain {
+			changes[accountID] = change.amount
+		}
+		onchainSyncCh <- syncResult{changes: changes, onchain: onchain, err: err}
+	}()
+	go func() {
+		changes, details, err := w.offchainReplay(ctx, fromEpoch, toEpoch)
+		if err != nil {
+			cancel()
+			err = errors.WithMessage(err, "offchain replay failed")
+		}
+		offchainReplayCh <- syncResult{changes: changes, details: details, err: err}
+	}()
+	onchain, offchain := <-onchainSyncCh, <-offchainReplayCh
+
+	// report the root cause rather than the cancelled side
+	if onchain.err != nil && (offchain.err == nil || !errors.Is(onchain.err, context.Canceled)) {
+		return onchain.err
+	}
+	if offchain.err != nil {
+		return offchain.err
+	}
+	w.alertPrecisionViolations(fmt.Sprintf("epoch %s to %s", fromEpoch, toEpoch))
+
+	onchainResult, offchainResult := onchain.changes, offchain.changes
 	logger.Infof("onchain #account with balance change: %v", len(onchainResult))
 	logger.Infof("offchain #account with balance change: %v", len(offchainResult))
 	filterZeroValue(onchainResult)
 	filterZeroValue(offchainResult)
 	logger.Infof("onchain #account with balance change abs > 0: %v", len(onchainResult))
 	logger.Infof("offchain #account with balance change abs > 0: %v", len(offchainResult))
+
+	// reference the per-account breakdown in alerts
+	report := ""
+	if mismatches := findMismatches(onchainResult, offchainResult); len(mismatches) > 0 {
+		report = w.reportMismatches(ctx, fromEpoch, toEpoch, isFull, mismatches, onchain.onchain, offchainResult, offchain.details)
+	}
+
 	if len(onchainResult) != len(offchainResult) {
-		err := fmt.Sprintf("list of account with balance change of onchain(%d) and offchain(%d) are different for epoch %s to %s!",
-			len(onchainResult), len(offchainResult), fromEpoch, toEpoch)
+		err := fmt.Sprintf("list of account with balance change of onchain(%d) and offchain(%d) are different for epoch %s to %s!%s",
+			len(onchainResult), len(offchainResult), fromEpoch, toEpoch, report)
 		logger.Errorf("%v\n", onchainResult)
 		logger.Errorf("%v\n", offchainResult)
-		logger.Errorf(err)
+		logger.Error(err)
 		common.Alert(module, err)
 		if w.pausable {
 			common.AlertMatchflow()
@@ -424,23 +743,48 @@ func (w *Worker) audit(fromEpoch *big.Int, toEpoch *big.Int, isFull bool) {
 	for accountID, onchainAmount := range onchainResult {
 		if offchainAmount, ok := offchainResult[accountID]; ok {
 			if onchainAmount.Cmp(offchainAmount) != 0 {
-				err := fmt.Sprintf("account with ID %d onchain balance change is different with offchain. onchain: %s, offchain: %s",
-					accountID, onchainAmount, offchainAmount)
-				logger.Errorf(err)
+				err := fmt.Sprintf("account with ID %d onchain balance change is different with offchain. onchain: %s, offchain: %s%s",
+					accountID, onchainAmount, offchainAmount, report)
+				logger.Error(err)
 				common.Alert(module, err)
 				if w.pausable {
 					common.AlertMatchflow()
 				}
 			}
 		} else {
-			err := fmt.Sprintf("account with ID %d onchain balance changed but offchain didn't!", accountID)
-			logger.Errorf(err)
+			err := fmt.Sprintf("account with ID %d onchain balance changed but offchain didn't!%s", accountID, report)
+			logger.Error(err)
 			common.Alert(module, err)
 			if w.pausable {
 				common.AlertMatchflow()
 			}
 		}
 	}
+	return nil
+}
+
+// reportMismatches writes the per-account breakdown of mismatched accounts into report files,
+// and returns the text to reference the report in alerts, or empty if report unavailable.
+func (w *Worker) reportMismatches(ctx context.Context, fromEpoch, toEpoch *big.Int, isFull bool,
+	mismatches []uint64, onchain map[uint64]*BalanceChange, offchain map[uint64]*big.Int, details []*TradeDetail) string {
+	if len(w.reportDir) == 0 {
+		return ""
+	}
+
+	report, err := w.buildMismatchReport(ctx, fromEpoch, toEpoch, isFull, mismatches, onchain, offchain, details)
+	if err != nil {
+		logger.Warnf("failed to build mismatch report: %v", err)
+		return ""
+	}
+
+	link, err := w.writeMismatchReport(report)
+	if err != nil {
+		logger.Warnf("failed to write mismatch report: %v", err)
+		return ""
+	}
+
+	logger.Infof("mismatch report of %d accounts written to %s", len(mismatches), link)
+	return " report: " + link
 }
 
 func parseEpoch(epoch string, bestEpoch *big.Int) *big.Int {
@@ -476,14 +820,12 @@ func (w *Worker) runFetcher(accounts map[string]bool, accountCh chan *Account) {
 	close(accountCh)
 }
 
-var pow10_18 = new(big.Float).SetPrec(200).SetInt(big.NewInt(1000000000000000000))
-
 func (w *Worker) auditAccountBalance(name string, account string, balance decimal.Decimal) []string {
 	if name == "EOS" || name == "CNY" {
 		return nil
 	}
-	balance = common.Mul(balance, parseFloat(ten18))
-	offchainBalance := balance.BigInt()
+	w.checkPrecision(balance, name)
+	offchainBalance := w.toMinUnit(balance, name)
 
 	onchainBalance, err := w.assetsMap[name].BalanceOf(account)
 	if err != nil {
@@ -543,6 +885,7 @@ func (w *Worker) initialAudit() bool {
 			break
 		}
 	}
+	w.alertPrecisionViolations("initial audit")
 	writer.WriteAll(records)
 	file.Close()
 	return hasErr
@@ -557,34 +900,144 @@ func (w *Worker) Start(config *common.MatchflowConfig) {
 		}
 	}
 
-	bestEpochWrap, err := w.cfxClient.GetEpochNumber(types.EpochLatestState)
-	if err != nil {
-		panic(err)
+	resumed := false
+	if len(config.CheckpointPath) > 0 {
+		if err := w.OpenCheckpoint(config.CheckpointPath, config.Reset); err != nil {
+			logger.Fatalf("failed to open checkpoint: %v", err)
+		}
+
+		if config.FromCheckpoint {
+			var err error
+			if resumed, err = w.loadCheckpoint(); err != nil {
+				logger.Fatalf("failed to resume from checkpoint: %v", err)
+			}
+		}
 	}
-	bestEpoch := bestEpochWrap.ToInt()
-	bestEpoch.Sub(bestEpoch, common.NumEpochsConfirmed)
-	logger.Infof("best epoch: %s", bestEpoch)
-	w.lastPartialAuditEpoch = parseEpoch(config.PartialEpoch, bestEpoch)
-	w.lastFullAuditEpoch = parseEpoch(config.FullEpoch, bestEpoch)
 
+	if !resumed {
+		bestEpoch := w.mustGetBestEpoch()
+		logger.Infof("best epoch: %s", bestEpoch)
+		w.lastPartialAuditEpoch = parseEpoch(config.PartialEpoch, bestEpoch)
+		w.lastFullAuditEpoch = parseEpoch(config.FullEpoch, bestEpoch)
+	}
+
+	ctx := context.Background()
 	for {
-		bestEpochWrap, err := w.cfxClient.GetEpochNumber(types.EpochLatestState)
+		bestEpoch, err := w.getBestEpoch()
 		if err != nil {
-			panic(err)
+			logger.Warnf("failed to get best epoch: %v", err)
+			time.Sleep(5 * time.Second)
+			continue
 		}
-		bestEpoch := bestEpochWrap.ToInt()
-		bestEpoch.Sub(bestEpoch, common.NumEpochsConfirmed)
 		if new(big.Int).Sub(bestEpoch, w.lastFullAuditEpoch).Cmp(big.NewInt(10000)) >= 0 {
 			// do fully audit once per 10000 epoch
-			w.audit(w.lastFullAuditEpoch, bestEpoch, true)
-			w.lastFullAuditEpoch = bestEpoch
+			if w.auditWindow(ctx, w.lastFullAuditEpoch, bestEpoch, true) == nil {
+				w.lastFullAuditEpoch = bestEpoch
+				w.recordPivot(bestEpoch)
+				w.saveCheckpoint()
+			}
 		} else {
-			// do partial audit
-			for ; bestEpoch.Cmp(w.lastPartialAuditEpoch) > 0; w.lastPartialAuditEpoch.Add(w.lastPartialAuditEpoch, big.NewInt(1)) {
-				w.audit(w.lastPartialAuditEpoch, w.lastPartialAuditEpoch, false)
+			// do partial audit, and retry the failed window later
+			for bestEpoch.Cmp(w.lastPartialAuditEpoch) > 0 {
+				if w.auditWindow(ctx, w.lastPartialAuditEpoch, w.lastPartialAuditEpoch, false) != nil {
+					break
+				}
+				w.recordPivot(w.lastPartialAuditEpoch)
+				w.lastPartialAuditEpoch = new(big.Int).Add(w.lastPartialAuditEpoch, common.Big1)
+				w.saveCheckpoint()
 			}
 		}
+		w.checkReorg()
 		w.db.CleanCache()
 		time.Sleep(5 * time.Second)
 	}
 }
+
+// getBestEpoch returns the latest confirmed epoch.
+func (w *Worker) getBestEpoch() (*big.Int, error) {
+	bestEpochWrap, err := w.cfxClient.GetEpochNumber(types.EpochLatestState)
+	if err != nil {
+		return nil, err
+	}
+	bestEpoch := bestEpochWrap.ToInt()
+	return bestEpoch.Sub(bestEpoch, common.NumEpochsConfirmed), nil
+}
+
+// mustGetBestEpoch returns the latest confirmed epoch, and retries until succeeded.
+func (w *Worker) mustGetBestEpoch() *big.Int {
+	for {
+		bestEpoch, err := w.getBestEpoch()
+		if err == nil {
+			return bestEpoch
+		}
+		logger.Warnf("failed to get best epoch: %v", err)
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// auditWindow audits the window of epochs, and reports "window N failed: reason" if failed,
+// so that the same window will be audited again. Alert once the window failed too many times.
+func (w *Worker) auditWindow(ctx context.Context, fromEpoch, toEpoch *big.Int, isFull bool) error {
+	window := w.auditedWindows + 1
+
+	err := w.audit(ctx, fromEpoch, toEpoch, isFull)
+	if err == nil {
+		w.auditedWindows++
+		w.windowFailures = 0
+		return nil
+	}
+
+	w.windowFailures++
+	logger.WithFields(logrus.Fields{
+		"window":   window,
+		"from":     fromEpoch,
+		"to":       toEpoch,
+		"isFull":   isFull,
+		"failures": w.windowFailures,
+		"reason":   err.Error(),
+	}).Errorf("window %d failed: %v", window, err)
+
+	if w.windowFailures == maxWindowFailures {
+		common.Alertf(module, "window %d failed %d times, from = %s, to = %s, isFull = %t: %v",
+			window, w.windowFailures, fromEpoch, toEpoch, isFull, err)
+	}
+
+	return err
+}
+
+// recordPivot records the pivot block of audited epoch to detect pivot chain reorg.
+func (w *Worker) recordPivot(epoch *big.Int) {
+	if err := w.pivots.Record(epoch); err != nil {
+		logger.Warnf("failed to record pivot block of epoch %s: %v", epoch, err)
+	}
+}
+
+// checkReorg checks the pivot blocks of audited epochs, and rewinds the audit progress
+// so that the reorged epochs will be audited again.
+func (w *Worker) checkReorg() {
+	reorg, err := w.pivots.Check()
+	if err != nil {
+		logger.Warnf("failed to check pivot chain reorg: %v", err)
+		return
+	}
+
+	if reorg == nil {
+		return
+	}
+
+	logger.Warnf(reorg.String())
+	common.Alert(module, reorg.String())
+
+	if reorg.Epoch.Cmp(w.lastPartialAuditEpoch) < 0 {
+		w.lastPartialAuditEpoch = new(big.Int).Set(reorg.Epoch)
+	}
+
+	if reorg.Epoch.Cmp(w.lastFullAuditEpoch) < 0 {
+		w.lastFullAuditEpoch = new(big.Int).Set(reorg.Epoch)
+	}
+
+	// nonce of the last replayed window may be reorged
+	w.lastNonceEpoch, w.lastNonce = nil, nil
+
+	w.saveCheckpoint()
+}
@@ -0,0 +1,329 @@
+package matchflow
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	testUser1 = "0x1000000000000000000000000000000000000001"
+	testUser2 = "0x1000000000000000000000000000000000000002"
+	testUser3 = "0x1000000000000000000000000000000000000003"
+)
+
+// newTestWorker creates a worker with fixture in testdata, and serves the next nonce of DEX
+// admin from the specified map, key is epoch.
+func newTestWorker(t *testing.T, nonces map[uint64]uint64) *Worker {
+	db, err := NewMemoryDataManager("testdata/replay.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := &Worker{
+		db:       db,
+		decimals: map[string]int32{"ETH": 18, "USDT": 6},
+	}
+
+	w.adminNonce = func(epoch *big.Int) (*big.Int, error) {
+		nonce, ok := nonces[epoch.Uint64()]
+		if !ok {
+			return nil, fmt.Errorf("nonce of epoch %v not found", epoch)
+		}
+		return new(big.Int).SetUint64(nonce), nil
+	}
+
+	return w
+}
+
+// detailsToStrings formats details as "accountID currency amount" for comparison.
+func detailsToStrings(details []*TradeDetail) []string {
+	var result []string
+	for _, detail := range details {
+		result = append(result, fmt.Sprintf("%d %s %s", detail.userID, detail.currency, detail.amount))
+	}
+	return result
+}
+
+func TestParseTrade(t *testing.T) {
+	w := newTestWorker(t, nil)
+
+	for _, c := range []struct {
+		name     string
+		trade    Trade
+		expected []string
+	}{
+		{
+			// taker pays fee in base currency, and maker pays fee in quote currency
+			name:  "buy",
+			trade: Trade{productID: 1, takerOrderID: 1, makerOrderID: 2, price: "1500.5", amount: "2", side: "Buy", takerFee: "0.002", makerFee: "3.001"},
+			expected: []string{
+				"11 ETH 1.998", "31 ETH 0.002", "12 USDT -3001",
+				"21 ETH -2", "22 USDT 2997.999", "32 USDT 3.001",
+			},
+		},
+		{
+			// taker pays fee in quote currency, and maker pays fee in base currency,
+			// and funds are truncated to the decimals of quote currency
+			name:  "sell",
+			trade: Trade{productID: 1, takerOrderID: 1, makerOrderID: 2, price: "1499.1234567", amount: "1", side: "Sell", takerFee: "1.5", makerFee: "0.001"},
+			expected: []string{
+				"11 ETH -1", "12 USDT 1497.623456", "32 USDT 1.5",
+				"21 ETH 0.999", "31 ETH 0.001", "22 USDT -1499.123456",
+			},
+		},
+		{
+			name:  "zero fee",
+			trade: Trade{productID: 1, takerOrderID: 2, makerOrderID: 1, price: "1500", amount: "0.5", side: "Buy", takerFee: "0", makerFee: "0"},
+			expected: []string{
+				"21 ETH 0.5", "31 ETH 0", "22 USDT -750",
+				"11 ETH -0.5", "12 USDT 750", "32 USDT 0",
+			},
+		},
+	} {
+		details, err := w.parseTrade(context.Background(), &c.trade)
+		if err != nil {
+			t.Errorf("%v: %v", c.name, err)
+			continue
+		}
+
+		if got := detailsToStrings(details); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%v: details = %v, want %v", c.name, got, c.expected)
+		}
+
+		// trade is balance neutral for each currency
+		sums := make(map[string]decimal.Decimal)
+		for _, detail := range details {
+			sums[detail.currency] = sums[detail.currency].Add(detail.amount)
+		}
+		for currency, sum := range sums {
+			if !sum.IsZero() {
+				t.Errorf("%v: sum of %v = %v", c.name, currency, sum)
+			}
+		}
+	}
+}
+
+func TestParseTradeError(t *testing.T) {
+	w := newTestWorker(t, nil)
+
+	for _, c := range []struct {
+		name  string
+		trade Trade
+	}{
+		{"unknown product", Trade{productID: 9, takerOrderID: 1, makerOrderID: 2, price: "1", amount: "1", side: "Buy", takerFee: "0", makerFee: "0"}},
+		{"unknown order", Trade{productID: 1, takerOrderID: 9, makerOrderID: 2, price: "1", amount: "1", side: "Buy", takerFee: "0", makerFee: "0"}},
+		{"invalid amount", Trade{productID: 1, takerOrderID: 1, makerOrderID: 2, price: "1", amount: "x", side: "Buy", takerFee: "0", makerFee: "0"}},
+		{"invalid price", Trade{productID: 1, takerOrderID: 1, makerOrderID: 2, price: "x", amount: "1", side: "Buy", takerFee: "0", makerFee: "0"}},
+		{"invalid fee", Trade{productID: 1, takerOrderID: 1, makerOrderID: 2, price: "1", amount: "1", side: "Sell", takerFee: "x", makerFee: "0"}},
+	} {
+		if _, err := w.parseTrade(context.Background(), &c.trade); err == nil {
+			t.Errorf("%v: error expected", c.name)
+		}
+	}
+}
+
+func TestParseTransfer(t *testing.T) {
+	w := newTestWorker(t, nil)
+
+	for _, c := range []struct {
+		name       string
+		recipients string
+		expected   []string // nil means error expected
+	}{
+		{
+			name:       "single recipient",
+			recipients: fmt.Sprintf(`{"%s":10.5}`, testUser1),
+			expected:   []string{"22 USDT -10.5", "12 USDT 10.5"},
+		},
+		{
+			name:       "multiple recipients",
+			recipients: fmt.Sprintf(`{"%s":10.5,"%s":0.25}`, testUser1, testUser3),
+			expected:   []string{"22 USDT -10.5", "12 USDT 10.5", "22 USDT -0.25", "32 USDT 0.25"},
+		},
+		{
+			name:       "unknown recipient",
+			recipients: `{"0x1000000000000000000000000000000000000009":1}`,
+		},
+		{
+			name:       "invalid recipients",
+			recipients: "{}",
+		},
+		{
+			name:       "invalid amount",
+			recipients: fmt.Sprintf(`{"%s":abc}`, testUser1),
+		},
+	} {
+		details, err := w.parseTransfer(context.Background(), &Transfer{userAddress: testUser2, currency: "USDT", recipients: c.recipients})
+		if c.expected == nil {
+			if err == nil {
+				t.Errorf("%v: error expected", c.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%v: %v", c.name, err)
+			continue
+		}
+
+		if got := detailsToStrings(details); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%v: details = %v, want %v", c.name, got, c.expected)
+		}
+	}
+}
+
+func TestOffchainReplay(t *testing.T) {
+	// DEX admin settles nonce 100 to 103 between epoch 10 and 100
+	nonces := map[uint64]uint64{9: 100, 10: 101, 49: 101, 50: 101, 100: 104}
+
+	for _, c := range []struct {
+		name      string
+		fromEpoch int64
+		toEpoch   int64
+		expected  map[uint64]string // balance change in minimum unit, key is account id
+	}{
+		{
+			// ETH has 18 decimals, and USDT has 6 decimals
+			name:      "all records",
+			fromEpoch: 10,
+			toEpoch:   100,
+			expected: map[uint64]string{
+				11: "998000000000000000",
+				12: "-1592876544",
+				21: "3999000000000000000",
+				22: "1488125544",
+				31: "3000000000000000",
+				32: "4751000",
+			},
+		},
+		{
+			name:      "single trade",
+			fromEpoch: 10,
+			toEpoch:   10,
+			expected: map[uint64]string{
+				11: "1998000000000000000",
+				12: "-3001000000",
+				21: "-2000000000000000000",
+				22: "2997999000",
+				31: "2000000000000000",
+				32: "3001000",
+			},
+		},
+		{
+			name:      "deposit only",
+			fromEpoch: 50,
+			toEpoch:   50,
+			expected: map[uint64]string{
+				21: "5000000000000000000",
+			},
+		},
+	} {
+		w := newTestWorker(t, nonces)
+
+		result, details, err := w.offchainReplay(context.Background(), big.NewInt(c.fromEpoch), big.NewInt(c.toEpoch))
+		if err != nil {
+			t.Errorf("%v: %v", c.name, err)
+			continue
+		}
+
+		got := make(map[uint64]string)
+		for accountID, amount := range result {
+			got[accountID] = amount.String()
+		}
+
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%v: result = %v, want %v", c.name, got, c.expected)
+		}
+
+		for _, detail := range details {
+			if detail.source == nil {
+				t.Errorf("%v: source of detail %v not tagged", c.name, detail)
+			}
+		}
+	}
+}
+
+func TestOffchainReplayNonceError(t *testing.T) {
+	w := newTestWorker(t, map[uint64]uint64{9: 100})
+
+	if _, _, err := w.offchainReplay(context.Background(), big.NewInt(10), big.NewInt(100)); err == nil {
+		t.Error("error expected")
+	}
+}
+
+func TestFindMismatches(t *testing.T) {
+	nonces := map[uint64]uint64{9: 100, 100: 104}
+	w := newTestWorker(t, nonces)
+
+	offchain, _, err := w.offchainReplay(context.Background(), big.NewInt(10), big.NewInt(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	toBigInts := func(changes map[uint64]string) map[uint64]*big.Int {
+		result := make(map[uint64]*big.Int)
+		for accountID, amount := range changes {
+			result[accountID], _ = new(big.Int).SetString(amount, 10)
+		}
+		return result
+	}
+
+	matched := map[uint64]string{
+		11: "998000000000000000",
+		12: "-1592876544",
+		21: "3999000000000000000",
+		22: "1488125544",
+		31: "3000000000000000",
+		32: "4751000",
+	}
+
+	for _, c := range []struct {
+		name     string
+		onchain  map[uint64]string
+		expected []uint64
+	}{
+		{"matched", matched, nil},
+		{"amount differs", map[uint64]string{11: "998000000000000000", 12: "-1592876543", 21: "3999000000000000000", 22: "1488125544", 31: "3000000000000000", 32: "4751000"}, []uint64{12}},
+		{"missing on chain", map[uint64]string{11: "998000000000000000", 12: "-1592876544", 21: "3999000000000000000", 22: "1488125544", 31: "3000000000000000"}, []uint64{32}},
+		{"missing off chain", map[uint64]string{11: "998000000000000000", 12: "-1592876544", 21: "3999000000000000000", 22: "1488125544", 31: "3000000000000000", 32: "4751000", 41: "1"}, []uint64{41}},
+		{"multiple", map[uint64]string{11: "1", 12: "-1592876544", 22: "1488125544", 31: "3000000000000000", 32: "4751000", 41: "1"}, []uint64{11, 21, 41}},
+	} {
+		if got := findMismatches(toBigInts(c.onchain), offchain); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%v: mismatches = %v, want %v", c.name, got, c.expected)
+		}
+	}
+}
+
+func TestCheckPrecision(t *testing.T) {
+	w := newTestWorker(t, nil)
+
+	for _, c := range []struct {
+		amount   string
+		currency string
+	}{
+		{"1.000001", "USDT"},
+		{"1.0000001", "USDT"},
+		{"0.000000000000000001", "ETH"},
+		{"1.0000000000000000001", "ETH"},
+		{"2.00000001", "USDT"},
+	} {
+		if _, err := w.parseAmount(c.amount, c.currency); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msg := w.precision.flush()
+	if !strings.HasPrefix(msg, "3 amounts") || !strings.Contains(msg, "1.0000001 of USDT") {
+		t.Errorf("message = %v", msg)
+	}
+
+	if msg = w.precision.flush(); len(msg) != 0 {
+		t.Errorf("violations not cleared, message = %v", msg)
+	}
+}
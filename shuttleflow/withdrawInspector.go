@@ -59,18 +59,28 @@ func (w *WithdrawInspector) runCFXListener(wg *sync.WaitGroup, vp *viper.Viper)
 
 	topics := [][]types.Hash{{types.Hash(burntEventHash.Hex())}}
 
+	fetcher := common.NewEpochLogFetcher(w.Client, erc777Values, topics)
+
 	var currentBlock big.Int
 	currentBlock.SetString(w.Cb.CFXInitialBlock, 10)
 	for {
 		w.waitForEpochConfirmed(&currentBlock)
 
-		logs, err := GetCFXEvents(w.Client, &currentBlock, erc777Values, topics)
-		if err != nil {
+		logs, err := fetcher.GetLogs(&currentBlock)
+		if err != nil && common.IsTransient(err) {
 			logger.WithError(err).Warn("Error getting CFX withdraw events for epoch ", currentBlock)
 			time.Sleep(time.Second)
 			continue
 		}
 
+		// retry never helps, e.g. too many logs, so skip the epoch to inspect later withdrawals
+		if err != nil {
+			logger.WithError(err).Error("Failed to get CFX withdraw events, skip epoch ", currentBlock)
+			common.Alert(module, fmt.Sprintf("withdraw events of epoch %v not inspected: %v", currentBlock.String(), err.Error()))
+			currentBlock.Add(&currentBlock, common.Big1)
+			continue
+		}
+
 		for _, log := range logs {
 			_, to, amount := w.decodeBurntEvent(&log)
 
@@ -0,0 +1,59 @@
+package watcher
+
+import (
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/open-dex/conflux-dex-audit/common"
+	"github.com/sirupsen/logrus"
+)
+
+const module = "watcher"
+
+// logger is the global logger of watcher module.
+var logger = common.NewLogger(module)
+
+// Start bootstraps watchers of privileged roles on Conflux since specified epoch, and on
+// Ethereum if ETH api url configured, along with the pause state monitor on Conflux.
+func Start(cfxURL string, assets []common.Asset, epoch *big.Int, cb *common.WatcherConfig, wg *sync.WaitGroup) {
+	config, err := loadConfig(cb.ConfigPath)
+	if err != nil {
+		logger.WithError(err).Fatal("failed to load config")
+	}
+
+	cfx := common.MustNewCfx(cfxURL)
+	roleWatcher := NewRoleWatcher(cfx, assets, config)
+	pauseMonitor := NewPauseMonitor(cfx, assets, config)
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		roleWatcher.WatchConflux(epoch)
+	}()
+
+	go func() {
+		defer wg.Done()
+		pauseMonitor.Watch(epoch)
+	}()
+
+	if len(cb.ETHDial) > 0 && len(config.Ethereum.EthFactory) > 0 {
+		client, err := ethclient.Dial(cb.ETHDial)
+		if err != nil {
+			logger.WithError(err).Fatal("failed to dial Ethereum")
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer client.Close()
+			roleWatcher.WatchEthereum(client, big.NewInt(config.Ethereum.InitialBlock), cb.ETHDelayBlock)
+		}()
+	}
+
+	logger.WithFields(logrus.Fields{
+		"epoch":     epoch,
+		"contracts": len(roleWatcher.contracts),
+		"pausable":  len(pauseMonitor.contracts),
+	}).Info("start to watch privileged roles and pause state")
+}
@@ -0,0 +1,70 @@
+package watcher
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// config represents the contracts to watch and expected role holders.
+type config struct {
+	Conflux struct {
+		Boomflow  string `json:"boomflow"`
+		Custodian string `json:"custodian"`
+		FC        string `json:"fc"`
+	} `json:"conflux"`
+
+	Ethereum struct {
+		EthFactory   string `json:"ethFactory"`
+		InitialBlock int64  `json:"initialBlock"`
+	} `json:"ethereum"`
+
+	Roles map[string][]string `json:"roles"` // expected role holders, key is role name
+
+	Pause struct {
+		CheckIntervalSeconds int64    `json:"checkIntervalSeconds"` // interval to read paused() of contracts
+		MaxPausedSeconds     int64    `json:"maxPausedSeconds"`     // alert if paused longer, zero means unlimited
+		ExpectedPaused       []string `json:"expectedPaused"`       // names of contracts expected to be paused, e.g. CRCL BTC
+	} `json:"pause"`
+}
+
+func loadConfig(path string) (*config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to read config file")
+	}
+
+	var result config
+	result.Pause.CheckIntervalSeconds = 60
+	result.Pause.MaxPausedSeconds = 3600
+
+	if err = json.Unmarshal(data, &result); err != nil {
+		return nil, errors.WithMessage(err, "failed to unmarshal config")
+	}
+
+	return &result, nil
+}
+
+// isExpectedPaused checks whether the contract of specified name is expected to be paused.
+func (c *config) isExpectedPaused(name string) bool {
+	for _, expected := range c.Pause.ExpectedPaused {
+		if expected == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isExpected checks whether the account is an expected holder of specified role.
+func (c *config) isExpected(role, account string) bool {
+	for _, holder := range c.Roles[role] {
+		if strings.EqualFold(holder, account) {
+			return true
+		}
+	}
+
+	return false
+}
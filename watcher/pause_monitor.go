@@ -0,0 +1,275 @@
+package watcher
+
+import (
+	"math/big"
+	"strings"
+	"time"
+
+	sdk "github.com/Conflux-Chain/go-conflux-sdk"
+	"github.com/Conflux-Chain/go-conflux-sdk/types"
+	"github.com/Conflux-Chain/go-conflux-sdk/types/cfxaddress"
+	"github.com/open-dex/conflux-dex-audit/common"
+	"github.com/sirupsen/logrus"
+)
+
+// pausableContract represents a DEX contract that exposes paused() and emits Paused/Unpaused.
+type pausableContract struct {
+	name      string
+	contract  *common.Contract
+	expected  bool      // expected pause state
+	paused    bool      // last recorded pause state
+	epoch     *big.Int  // epoch of last recorded pause state
+	since     time.Time // time when pause state changed
+	lastAlert time.Time // last time to alert long pause
+}
+
+// PauseMonitor records the pause state of CRCL, ERC777 and Boomflow contracts epoch by epoch,
+// driven by Paused/Unpaused events and periodic reads of paused(), and alerts on unexpected
+// changes or long pause.
+type PauseMonitor struct {
+	cfx       *sdk.Client
+	config    *config
+	contracts map[string]*pausableContract // key is contract address in lower case hex
+	logger    logrus.FieldLogger
+
+	isPaused func(contract *pausableContract, epoch *big.Int) (bool, error) // replaceable in test
+}
+
+// NewPauseMonitor creates an instance of PauseMonitor for the CRCL and ERC777 contracts of
+// specified assets, and the Boomflow contract in config.
+func NewPauseMonitor(cfx *sdk.Client, assets []common.Asset, config *config) *PauseMonitor {
+	m := PauseMonitor{
+		cfx:       cfx,
+		config:    config,
+		contracts: make(map[string]*pausableContract),
+		logger:    logger.WithField("submodule", "pause"),
+	}
+
+	for _, asset := range assets {
+		m.addContract("CRCL "+asset.Name, common.CrclABI, asset.ContractAddress)
+		m.addContract("ERC777 "+asset.Name, common.Erc777ABI, asset.TokenAddress)
+	}
+
+	m.addContract("Boomflow", common.BoomflowABI, config.Conflux.Boomflow)
+
+	m.isPaused = m.readPaused
+
+	return &m
+}
+
+// readPaused reads the pause state of contract at the specified epoch from full node.
+func (m *PauseMonitor) readPaused(contract *pausableContract, epoch *big.Int) (bool, error) {
+	return contract.contract.IsPaused("paused", types.NewEpochNumberBig(epoch))
+}
+
+func (m *PauseMonitor) addContract(name, abi, address string) {
+	if len(address) == 0 {
+		return
+	}
+
+	address = strings.ToLower(address)
+	m.contracts[address] = &pausableContract{
+		name:     name,
+		contract: common.GetContract(m.cfx, abi, address),
+		expected: m.config.isExpectedPaused(name),
+	}
+}
+
+// Watch reads the pause state of contracts before the specified epoch, and then watches the
+// Paused/Unpaused events epoch by epoch. The pause state is also read periodically, so that
+// any change missed by events is recorded as well. If failed to read the initial pause state
+// of any contract, it is retried before each epoch, and events of the contract are skipped
+// until initialized.
+func (m *PauseMonitor) Watch(epoch *big.Int) {
+	var addresses []types.Address
+	for address := range m.contracts {
+		addresses = append(addresses, cfxaddress.MustNewFromHex(address, common.GetNetworkId()))
+	}
+
+	decoder := common.MustNewEventDecoder(common.CrclABI)
+	pausedHash, unpausedHash := decoder.EventHash("Paused"), decoder.EventHash("Unpaused")
+	fetcher := common.NewEpochLogFetcher(m.cfx, addresses, [][]types.Hash{{pausedHash, unpausedHash}})
+
+	epoch = new(big.Int).Set(epoch)
+	m.poll(new(big.Int).Sub(epoch, common.Big1))
+	lastPoll := time.Now()
+	interval := time.Duration(m.config.Pause.CheckIntervalSeconds) * time.Second
+
+	for ; ; epoch.Add(epoch, common.Big1) {
+		common.WaitForEpochConfirmed(m.cfx, epoch, m.logger)
+
+		logs, err := fetcher.GetLogs(epoch)
+		for err != nil {
+			m.logger.WithError(err).WithField("epoch", epoch).Warn("failed to poll event logs")
+			time.Sleep(time.Second)
+			logs, err = fetcher.GetLogs(epoch)
+		}
+
+		m.handleLogs(epoch, logs, pausedHash)
+
+		if time.Since(lastPoll) >= interval {
+			m.poll(epoch)
+			lastPoll = time.Now()
+		}
+	}
+}
+
+// handleLogs records the pause state changed by Paused/Unpaused events of the specified epoch.
+func (m *PauseMonitor) handleLogs(epoch *big.Int, logs []types.Log, pausedHash types.Hash) {
+	m.initPending(new(big.Int).Sub(epoch, common.Big1))
+
+	for i := range logs {
+		contract, ok := m.contracts[strings.ToLower(logs[i].Address.GetHexAddress())]
+		if !ok || len(logs[i].Topics) == 0 {
+			continue
+		}
+
+		txHash := ""
+		if logs[i].TransactionHash != nil {
+			txHash = logs[i].TransactionHash.String()
+		}
+
+		// changes will be detected by paused() once initialized
+		if contract.epoch == nil {
+			m.logger.WithFields(logrus.Fields{
+				"contract": contract.name,
+				"epoch":    epoch,
+				"tx":       txHash,
+			}).Warn("pause state not initialized yet, event skipped")
+			continue
+		}
+
+		m.record(contract, logs[i].Topics[0] == pausedHash, epoch, txHash)
+	}
+}
+
+// initPending retries to read the initial pause state of contracts that failed to read before,
+// at the specified epoch.
+func (m *PauseMonitor) initPending(epoch *big.Int) {
+	for _, contract := range m.contracts {
+		if contract.epoch != nil {
+			continue
+		}
+
+		paused, err := m.isPaused(contract, epoch)
+		if err != nil {
+			m.logger.WithError(err).WithFields(logrus.Fields{
+				"contract": contract.name,
+				"epoch":    epoch,
+			}).Warn("failed to read initial pause state")
+			continue
+		}
+
+		m.init(contract, paused, epoch)
+	}
+}
+
+// poll reads the pause state of all contracts at the specified epoch, and alerts on long pause.
+func (m *PauseMonitor) poll(epoch *big.Int) {
+	for _, contract := range m.contracts {
+		paused, err := m.isPaused(contract, epoch)
+		if err != nil {
+			m.logger.WithError(err).WithFields(logrus.Fields{
+				"contract": contract.name,
+				"epoch":    epoch,
+			}).Warn("failed to read pause state")
+			continue
+		}
+
+		if contract.epoch == nil {
+			m.init(contract, paused, epoch)
+		} else {
+			m.record(contract, paused, epoch, "")
+		}
+
+		m.checkLongPause(contract)
+	}
+}
+
+// init records the initial pause state of contract, and alerts if not expected.
+func (m *PauseMonitor) init(contract *pausableContract, paused bool, epoch *big.Int) {
+	// epoch is advanced in place by caller
+	epoch = new(big.Int).Set(epoch)
+
+	contract.paused = paused
+	contract.epoch = epoch
+	contract.since = time.Now()
+
+	m.logger.WithFields(logrus.Fields{
+		"contract": contract.name,
+		"paused":   paused,
+		"epoch":    epoch,
+	}).Info("initial pause state")
+
+	if paused != contract.expected {
+		common.Alertf(module, "unexpected pause state of %v (%v), paused = %v, epoch = %v",
+			contract.name, contract.contract.Address(), paused, epoch)
+	}
+}
+
+// record records the pause state of contract at the specified epoch, and alerts if changed.
+// Empty tx hash means the change is detected by reading paused() instead of events.
+func (m *PauseMonitor) record(contract *pausableContract, paused bool, epoch *big.Int, txHash string) {
+	if contract.epoch != nil && contract.epoch.Cmp(epoch) > 0 {
+		return
+	}
+
+	// epoch is advanced in place by caller
+	epoch = new(big.Int).Set(epoch)
+
+	contract.epoch = epoch
+	if contract.paused == paused {
+		return
+	}
+
+	logger := m.logger.WithFields(logrus.Fields{
+		"contract":   contract.name,
+		"address":    contract.contract.Address(),
+		"paused":     paused,
+		"epoch":      epoch,
+		"tx":         txHash,
+		"lastChange": contract.since,
+	})
+
+	contract.paused = paused
+	contract.since = time.Now()
+	contract.lastAlert = time.Time{}
+
+	if len(txHash) == 0 {
+		txHash = "unknown, detected by paused()"
+	}
+
+	if paused == contract.expected {
+		logger.Info("pause state changed")
+		common.Notifyf("pause state of %v (%v) changed, paused = %v, epoch = %v, tx = %v",
+			contract.name, contract.contract.Address(), paused, epoch, txHash)
+	} else {
+		logger.Error("unexpected pause state changed")
+		common.Criticalf(module, "unexpected pause state of %v (%v) changed, paused = %v, epoch = %v, tx = %v",
+			contract.name, contract.contract.Address(), paused, epoch, txHash)
+	}
+}
+
+// checkLongPause alerts if the contract is unexpectedly paused longer than the configured limit,
+// and alerts again once per limit.
+func (m *PauseMonitor) checkLongPause(contract *pausableContract) {
+	limit := time.Duration(m.config.Pause.MaxPausedSeconds) * time.Second
+	if limit <= 0 || !contract.paused || contract.expected {
+		return
+	}
+
+	if time.Since(contract.since) < limit || time.Since(contract.lastAlert) < limit {
+		return
+	}
+
+	contract.lastAlert = time.Now()
+
+	m.logger.WithFields(logrus.Fields{
+		"contract": contract.name,
+		"since":    contract.since,
+		"epoch":    contract.epoch,
+	}).Warn("contract paused too long")
+
+	common.Alertf(module, "%v (%v) has been paused since %v, longer than %v",
+		contract.name, contract.contract.Address(), contract.since.Format(time.RFC3339), limit)
+}
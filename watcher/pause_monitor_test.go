@@ -0,0 +1,79 @@
+package watcher
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	sdk "github.com/Conflux-Chain/go-conflux-sdk"
+	"github.com/Conflux-Chain/go-conflux-sdk/types"
+	"github.com/Conflux-Chain/go-conflux-sdk/types/cfxaddress"
+	"github.com/open-dex/conflux-dex-audit/common"
+)
+
+const testPausableAddress = "0x8000000000000000000000000000000000000001"
+
+func newTestPauseMonitor() (*PauseMonitor, *pausableContract) {
+	address := cfxaddress.MustNewFromHex(testPausableAddress, common.GetNetworkId())
+	contract := &pausableContract{
+		name:     "CRCL CFX",
+		contract: &common.Contract{Contract: &sdk.Contract{Address: &address}},
+	}
+
+	m := &PauseMonitor{
+		config:    &config{},
+		contracts: map[string]*pausableContract{testPausableAddress: contract},
+		logger:    logger,
+	}
+
+	return m, contract
+}
+
+func TestPauseMonitorInitialPollFailed(t *testing.T) {
+	m, contract := newTestPauseMonitor()
+
+	// full node unavailable
+	var polled []int64
+	m.isPaused = func(contract *pausableContract, epoch *big.Int) (bool, error) {
+		polled = append(polled, epoch.Int64())
+		return false, errors.New("rpc error")
+	}
+
+	m.poll(big.NewInt(9))
+
+	decoder := common.MustNewEventDecoder("../" + common.CrclABI)
+	pausedHash := decoder.EventHash("Paused")
+	txHash := types.Hash("0x01")
+	logs := []types.Log{{
+		Address:         cfxaddress.MustNewFromHex(testPausableAddress, common.GetNetworkId()),
+		Topics:          []types.Hash{pausedHash},
+		TransactionHash: &txHash,
+	}}
+
+	// event skipped until initialized
+	m.handleLogs(big.NewInt(10), logs, pausedHash)
+	if contract.epoch != nil || contract.paused {
+		t.Fatalf("event recorded without initial state, epoch = %v, paused = %v", contract.epoch, contract.paused)
+	}
+
+	// initial poll retried before the next epoch, which includes the skipped event
+	m.isPaused = func(contract *pausableContract, epoch *big.Int) (bool, error) {
+		polled = append(polled, epoch.Int64())
+		return true, nil
+	}
+
+	m.handleLogs(big.NewInt(11), nil, pausedHash)
+	if contract.epoch == nil || contract.epoch.Int64() != 10 || !contract.paused {
+		t.Fatalf("not initialized, epoch = %v, paused = %v", contract.epoch, contract.paused)
+	}
+
+	if len(polled) != 3 || polled[0] != 9 || polled[1] != 9 || polled[2] != 10 {
+		t.Fatalf("polled epochs = %v", polled)
+	}
+
+	// no more initial poll once initialized
+	m.handleLogs(big.NewInt(12), nil, pausedHash)
+	if len(polled) != 3 {
+		t.Fatalf("polled epochs = %v", polled)
+	}
+}
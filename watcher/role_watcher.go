@@ -0,0 +1,268 @@
+package watcher
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"time"
+
+	sdk "github.com/Conflux-Chain/go-conflux-sdk"
+	"github.com/Conflux-Chain/go-conflux-sdk/types"
+	"github.com/Conflux-Chain/go-conflux-sdk/types/cfxaddress"
+	"github.com/ethereum/go-ethereum"
+	ethCommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/open-dex/conflux-dex-audit/common"
+	"github.com/sirupsen/logrus"
+)
+
+// roleGrantedEvents maps the events that grant privileged role to role name.
+var roleGrantedEvents = map[string]string{
+	"WhitelistAdminAdded":  "WhitelistAdmin",
+	"WhitelistedAdded":     "Whitelisted",
+	"PauserAdded":          "Pauser",
+	"OwnershipTransferred": "Owner",
+	"AdminAdded":           "Admin",
+	"MinterAdded":          "Minter",
+	"MemberChange":         "Custodian",
+}
+
+// roleRevokedEvents are the events that revoke privileged role.
+var roleRevokedEvents = map[string]bool{
+	"WhitelistAdminRemoved": true,
+	"WhitelistedRemoved":    true,
+	"PauserRemoved":         true,
+	"AdminRemoved":          true,
+	"MinterRemoved":         true,
+}
+
+// watchedContract represents a DEX contract that emits privileged role events.
+type watchedContract struct {
+	name    string
+	address string
+	decoder *common.EventDecoder
+}
+
+// RoleWatcher watches the privileged role events of DEX contracts, and alerts on any
+// unexpected role grant or ownership transfer.
+type RoleWatcher struct {
+	cfx       *sdk.Client
+	config    *config
+	contracts map[string]*watchedContract // key is contract address in lower case hex
+	custodian *common.Contract
+	logger    logrus.FieldLogger
+}
+
+// NewRoleWatcher creates an instance of RoleWatcher for the CRCL and ERC777 contracts of
+// specified assets, and the contracts in config.
+func NewRoleWatcher(cfx *sdk.Client, assets []common.Asset, config *config) *RoleWatcher {
+	w := RoleWatcher{
+		cfx:       cfx,
+		config:    config,
+		contracts: make(map[string]*watchedContract),
+		logger:    logger.WithField("submodule", "role"),
+	}
+
+	crclDecoder := common.MustNewEventDecoder(common.CrclABI)
+	erc777Decoder := common.MustNewEventDecoder(common.Erc777ABI)
+
+	for _, asset := range assets {
+		w.addContract("CRCL "+asset.Name, asset.ContractAddress, crclDecoder)
+		w.addContract("ERC777 "+asset.Name, asset.TokenAddress, erc777Decoder)
+	}
+
+	w.addContract("Boomflow", config.Conflux.Boomflow, common.MustNewEventDecoder(common.BoomflowABI))
+	w.addContract("CustodianCore", config.Conflux.Custodian, common.MustNewEventDecoder(common.CustodianABI))
+	w.addContract("FC", config.Conflux.FC, common.MustNewEventDecoder(common.FcABI))
+
+	if len(config.Conflux.Custodian) > 0 {
+		w.custodian = common.GetContract(cfx, common.CustodianABI, config.Conflux.Custodian)
+	}
+
+	return &w
+}
+
+func (w *RoleWatcher) addContract(name, address string, decoder *common.EventDecoder) {
+	if len(address) == 0 {
+		return
+	}
+
+	address = strings.ToLower(address)
+	w.contracts[address] = &watchedContract{name, address, decoder}
+}
+
+// topics returns the topic filter of all privileged role events.
+func topics(decoders ...*common.EventDecoder) []types.Hash {
+	unique := make(map[types.Hash]bool)
+	for _, decoder := range decoders {
+		for event := range roleGrantedEvents {
+			if hash := decoder.EventHash(event); len(hash) > 0 {
+				unique[hash] = true
+			}
+		}
+
+		for event := range roleRevokedEvents {
+			if hash := decoder.EventHash(event); len(hash) > 0 {
+				unique[hash] = true
+			}
+		}
+	}
+
+	var result []types.Hash
+	for hash := range unique {
+		result = append(result, hash)
+	}
+
+	return result
+}
+
+// WatchConflux watches the privileged role events on Conflux epoch by epoch since the specified epoch.
+func (w *RoleWatcher) WatchConflux(epoch *big.Int) {
+	var addresses []types.Address
+	var decoders []*common.EventDecoder
+	for _, contract := range w.contracts {
+		addresses = append(addresses, cfxaddress.MustNewFromHex(contract.address, common.GetNetworkId()))
+		decoders = append(decoders, contract.decoder)
+	}
+
+	fetcher := common.NewEpochLogFetcher(w.cfx, addresses, [][]types.Hash{topics(decoders...)})
+
+	for epoch = new(big.Int).Set(epoch); ; epoch.Add(epoch, common.Big1) {
+		common.WaitForEpochConfirmed(w.cfx, epoch, w.logger)
+
+		logs, err := fetcher.GetLogs(epoch)
+		for err != nil {
+			w.logger.WithError(err).WithField("epoch", epoch).Warn("failed to poll event logs")
+			time.Sleep(time.Second)
+			logs, err = fetcher.GetLogs(epoch)
+		}
+
+		for i := range logs {
+			contract, ok := w.contracts[strings.ToLower(logs[i].Address.GetHexAddress())]
+			if !ok {
+				continue
+			}
+
+			event, err := contract.decoder.Decode(&logs[i])
+			if err != nil {
+				w.logger.WithError(err).WithField("epoch", epoch).Warn("failed to decode event log")
+				continue
+			}
+
+			w.onEvent(contract, event, "epoch", epoch)
+		}
+	}
+}
+
+// WatchEthereum watches the privileged role events of EthFactory on Ethereum block by block since
+// the specified block, and delays the specified number of blocks for confirmation.
+func (w *RoleWatcher) WatchEthereum(client *ethclient.Client, block *big.Int, delay int64) {
+	if len(w.config.Ethereum.EthFactory) == 0 {
+		return
+	}
+
+	decoder := common.MustNewEventDecoder(common.EthFactoryABI)
+	contract := &watchedContract{"EthFactory", strings.ToLower(w.config.Ethereum.EthFactory), decoder}
+
+	var topicHashes []ethCommon.Hash
+	for _, hash := range topics(decoder) {
+		topicHashes = append(topicHashes, *hash.ToCommonHash())
+	}
+
+	query := ethereum.FilterQuery{
+		Addresses: []ethCommon.Address{ethCommon.HexToAddress(contract.address)},
+		Topics:    [][]ethCommon.Hash{topicHashes},
+	}
+
+	for block = new(big.Int).Set(block); ; {
+		header, err := client.HeaderByNumber(context.Background(), nil)
+		if err != nil {
+			w.logger.WithError(err).Warn("failed to get latest block from Ethereum")
+			time.Sleep(30 * time.Second)
+			continue
+		}
+
+		if new(big.Int).Sub(header.Number, big.NewInt(delay)).Cmp(block) < 0 {
+			time.Sleep(30 * time.Second)
+			continue
+		}
+
+		query.FromBlock = block
+		query.ToBlock = block
+
+		logs, err := client.FilterLogs(context.Background(), query)
+		if err != nil {
+			w.logger.WithError(err).WithField("block", block).Warn("failed to filter Ethereum logs")
+			time.Sleep(30 * time.Second)
+			continue
+		}
+
+		for i := range logs {
+			event, err := decoder.DecodeEthLog(&logs[i])
+			if err != nil {
+				w.logger.WithError(err).WithField("block", block).Warn("failed to decode event log")
+				continue
+			}
+
+			w.onEvent(contract, event, "block", block)
+		}
+
+		block = new(big.Int).Add(block, common.Big1)
+	}
+}
+
+// onEvent checks the privileged role event against expected role holders.
+func (w *RoleWatcher) onEvent(contract *watchedContract, event *common.Event, positionName string, position *big.Int) {
+	logger := w.logger.WithFields(logrus.Fields{
+		"contract":   contract.name,
+		"address":    contract.address,
+		"event":      event.Name,
+		"args":       event.Args,
+		"tx":         event.TxHash(),
+		positionName: position,
+	})
+
+	if roleRevokedEvents[event.Name] {
+		logger.Info("privileged role revoked")
+		common.Notifyf("%v revoked on %v (%v), account = %v, tx = %v", event.Name, contract.name, contract.address, event.Address("account"), event.TxHash())
+		return
+	}
+
+	role, ok := roleGrantedEvents[event.Name]
+	if !ok {
+		return
+	}
+
+	var holders []string
+	switch event.Name {
+	case "MemberChange":
+		if w.custodian == nil {
+			logger.Warn("custodian contract not configured")
+			return
+		}
+
+		custodians, err := w.custodian.ListCustodians(types.NewEpochNumberBig(position))
+		if err != nil {
+			logger.WithError(err).Error("failed to list custodians")
+			common.Criticalf(module, "custodian member changed but failed to list custodians, %v = %v, tx = %v", positionName, position, event.TxHash())
+			return
+		}
+
+		holders = custodians
+	case "OwnershipTransferred":
+		holders = []string{event.Address("newOwner")}
+	default:
+		holders = []string{event.Address("account")}
+	}
+
+	for _, holder := range holders {
+		if w.config.isExpected(role, holder) {
+			logger.WithField("holder", holder).Info("expected privileged role granted")
+			continue
+		}
+
+		logger.WithField("holder", holder).Error("unexpected privileged role granted")
+		common.Criticalf(module, "unexpected %v granted on %v (%v), account = %v, %v = %v, tx = %v",
+			role, contract.name, contract.address, holder, positionName, position, event.TxHash())
+	}
+}